@@ -34,6 +34,8 @@ func providers(app *applicationConfiguration) *applicationConfiguration {
 	registerRedisCfgFactory(app)
 	registerServiceKvFactory(app)
 	registerLocalStorageKvFactory(app)
+	registerFastCgiKvFactory(app)
+	registerQueueKvFactory(app)
 	return app
 }
 
@@ -241,3 +243,29 @@ func (l *applicationConfiguration) GetRedisKv() RedisKv {
 	}
 	return *rdKv
 }
+
+// GetFastCgiKv 获取 FastCGI upstream 配置组
+func (l *applicationConfiguration) GetFastCgiKv() FastCgiKv {
+	var cgi, ok = l.GetKvObj("fastcgi")
+	if !ok {
+		return nil
+	}
+	kv, ok := cgi.(*FastCgiKv)
+	if !ok || kv == nil {
+		return nil
+	}
+	return *kv
+}
+
+// GetQueueKv 获取队列消费配置组
+func (l *applicationConfiguration) GetQueueKv() QueueKv {
+	var queues, ok = l.GetKvObj("queues")
+	if !ok {
+		return nil
+	}
+	kv, ok := queues.(*QueueKv)
+	if !ok || kv == nil {
+		return nil
+	}
+	return *kv
+}
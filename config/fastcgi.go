@@ -0,0 +1,82 @@
+package config
+
+import (
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/utils"
+	"sort"
+	"strings"
+)
+
+// FastCgi FastCGI upstream 配置 [fastcgi_pass, fastcgi_file, root, fastcgi_add_headers ...]
+type FastCgi map[string]interface{}
+
+// FastCgiKv 命名 FastCGI upstream 配置组
+type FastCgiKv map[string]FastCgi
+
+func (data *FastCgiKv) Get(key string) (FastCgi, bool) {
+	if val, ok := (*data)[strings.ToLower(key)]; ok {
+		return val, ok
+	}
+	return nil, false
+}
+
+func (data *FastCgiKv) Keys() []string {
+	var keys []string
+	for k := range *data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (data *FastCgiKv) String() string {
+	return utils.JsonEncode(data).String()
+}
+
+func (data *FastCgiKv) Decode(content []byte) error {
+	return utils.JsonDecode(content, data)
+}
+
+func (data *FastCgiKv) ValueOf(key string, def ...interface{}) interface{} {
+	if val, ok := data.Get(key); ok {
+		return val
+	}
+	def = append(def, nil)
+	return def[0]
+}
+
+func (data *FastCgiKv) MAdd(mArr map[string]interface{}) int {
+	var n = 0
+	for k, v := range mArr {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		(*data)[strings.ToLower(k)] = m
+		n++
+	}
+	return n
+}
+
+// Bytes upstream 配置 json
+func (cgi FastCgi) Bytes() []byte {
+	return utils.JsonEncode(cgi).Bytes()
+}
+
+// 创建 FastCGI 配置
+func createFastCgiKv(v interface{}) *FastCgiKv {
+	switch v.(type) {
+	case map[string]interface{}:
+		kv := FastCgiKv{}
+		kv.MAdd(v.(map[string]interface{}))
+		return &kv
+	}
+	return nil
+}
+
+// 注册
+func registerFastCgiKvFactory(app *applicationConfiguration) {
+	app.Register("fastcgi", func(v interface{}) facede.CfgKv {
+		return createFastCgiKv(v)
+	})
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"github.com/spf13/cast"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/utils"
+	"sort"
+	"strings"
+)
+
+// Queue 队列消费配置 [type, consumer_max_num, properties, bindings]
+type Queue map[string]interface{}
+
+// QueueKv 命名队列消费配置组
+type QueueKv map[string]Queue
+
+func (data *QueueKv) Get(key string) (Queue, bool) {
+	if val, ok := (*data)[strings.ToLower(key)]; ok {
+		return val, ok
+	}
+	return nil, false
+}
+
+func (data *QueueKv) Keys() []string {
+	var keys []string
+	for k := range *data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (data *QueueKv) String() string {
+	return utils.JsonEncode(data).String()
+}
+
+func (data *QueueKv) Decode(content []byte) error {
+	return utils.JsonDecode(content, data)
+}
+
+func (data *QueueKv) ValueOf(key string, def ...interface{}) interface{} {
+	if val, ok := data.Get(key); ok {
+		return val
+	}
+	def = append(def, nil)
+	return def[0]
+}
+
+func (data *QueueKv) MAdd(mArr map[string]interface{}) int {
+	var n = 0
+	for k, v := range mArr {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		(*data)[strings.ToLower(k)] = normalize(m).(map[string]interface{})
+		n++
+	}
+	return n
+}
+
+// 转换 yaml 列表中的 map[interface{}]interface{} [便于 json 编码]
+func normalize(v interface{}) interface{} {
+	switch v.(type) {
+	case map[interface{}]interface{}:
+		return normalize(cast.ToStringMap(v))
+	case map[string]interface{}:
+		var m = v.(map[string]interface{})
+		for k, item := range m {
+			m[k] = normalize(item)
+		}
+		return m
+	case []interface{}:
+		var arr = v.([]interface{})
+		for i, item := range arr {
+			arr[i] = normalize(item)
+		}
+		return arr
+	}
+	return v
+}
+
+// 创建队列消费配置
+func createQueueKv(v interface{}) *QueueKv {
+	switch v.(type) {
+	case map[string]interface{}:
+		kv := QueueKv{}
+		kv.MAdd(v.(map[string]interface{}))
+		return &kv
+	}
+	return nil
+}
+
+// 注册
+func registerQueueKvFactory(app *applicationConfiguration) {
+	app.Register("queues", func(v interface{}) facede.CfgKv {
+		return createQueueKv(v)
+	})
+}
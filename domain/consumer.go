@@ -0,0 +1,281 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	ConsumerCreator func() facede.Consumer
+
+	// 队列消费器管理
+	consumerDomainImpl struct {
+		safe     sync.RWMutex
+		creators map[string]ConsumerCreator
+	}
+)
+
+const (
+	HeaderQueueName      = "X-Queue-Name"
+	HeaderQueueMessageID = "X-Queue-Message-ID"
+	HeaderQueueAttempts  = "X-Queue-Delivery-Count"
+	EnvQueuePrefix       = "QUEUE_"
+	// EnvDedupStore 去重存储 redis|leveldb
+	EnvDedupStore = "QUEUE_DEDUP_STORE"
+	// EnvDedupRedis 去重 redis 连接名
+	EnvDedupRedis = "QUEUE_DEDUP_REDIS"
+)
+
+var (
+	consumerDefault      *consumerDomainImpl
+	consumerDefaultMutex sync.Mutex
+	envNameReplacer      = regexp.MustCompile(`[^A-Z0-9_]`)
+)
+
+func NewConsumerDomain() *consumerDomainImpl {
+	var domain = new(consumerDomainImpl)
+	return domain.init()
+}
+
+func GetConsumerDomain() *consumerDomainImpl {
+	consumerDefaultMutex.Lock()
+	defer consumerDefaultMutex.Unlock()
+	if consumerDefault == nil {
+		consumerDefault = NewConsumerDomain()
+	}
+	return consumerDefault
+}
+
+func (domain *consumerDomainImpl) init() *consumerDomainImpl {
+	domain.safe = sync.RWMutex{}
+	domain.creators = make(map[string]ConsumerCreator)
+	domain.Register(entity.ConsumerApi, func() facede.Consumer {
+		return NewApiConsumerDomain()
+	})
+	domain.Register(entity.ConsumerShell, func() facede.Consumer {
+		return NewShellConsumerDomain()
+	})
+	domain.Register(entity.ConsumerFastCGI, func() facede.Consumer {
+		return NewFastCgiConsumerDomain()
+	})
+	domain.Register(entity.ConsumerMysql, func() facede.Consumer {
+		return NewMysqlConsumerDomain()
+	})
+	domain.Register(entity.ConsumerPlugins, func() facede.Consumer {
+		return NewPluginsConsumerDomain()
+	})
+	domain.Register(entity.ConsumerProxy, func() facede.Consumer {
+		return NewProxyConsumerDomain()
+	})
+	domain.Register(entity.ConsumerFcm, func() facede.Consumer {
+		return NewFcmConsumerDomain()
+	})
+	domain.Register(entity.ConsumerGrpc, func() facede.Consumer {
+		return NewGrpcConsumerDomain()
+	})
+	domain.Register(entity.ConsumerPipeline, func() facede.Consumer {
+		return NewPipelineConsumerDomain()
+	})
+	return domain
+}
+
+// Register 注册消费器类型 [类型名忽略大小写]
+func (domain *consumerDomainImpl) Register(ty string, creator ConsumerCreator) bool {
+	if ty == "" || creator == nil {
+		return false
+	}
+	domain.safe.Lock()
+	defer domain.safe.Unlock()
+	domain.creators[strings.ToUpper(ty)] = creator
+	return true
+}
+
+// Create 创建并解析消费器
+func (domain *consumerDomainImpl) Create(ty string, properties []byte) (facede.Consumer, error) {
+	domain.safe.RLock()
+	creator, ok := domain.creators[strings.ToUpper(ty)]
+	domain.safe.RUnlock()
+	if !ok {
+		return nil, entity.ErrorSupport
+	}
+	var consumer = creator()
+	if err := consumer.Parse(properties); err != nil {
+		return nil, err
+	}
+	return consumer, nil
+}
+
+// Types 已注册消费器类型
+func (domain *consumerDomainImpl) Types() []string {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
+	var types = make([]string, 0, len(domain.creators))
+	for ty := range domain.creators {
+		types = append(types, ty)
+	}
+	return types
+}
+
+// CreateConsumeHandler 绑定转换为队列回调 [过滤不匹配的消息确认, 按绑定处理超时调用消费器, 按处理结果 ack|nack, rpc 调用时应答, 失败记录 app_queue_fails]
+func CreateConsumeHandler(binding *repo.QueueBinding, logger ...*logrus.Logger) func(broker rabbitmq.MessageWrapper) {
+	logger = append(logger, repo.GetLogger("consumer"))
+	return func(broker rabbitmq.MessageWrapper) {
+		var (
+			msg    = entity.MessageOf(broker)
+			action = entity.ConsumeAck
+			err    error
+		)
+		if binding.Filter.Match(msg) {
+			action, err = handleSafely(binding, msg)
+		}
+		if err != nil {
+			logger[0].WithFields(logrus.Fields{
+				"type":    binding.Consumer.Type(),
+				"binding": binding.Name,
+				"queue":   msg.Queue,
+				"id":      msg.ID,
+				"action":  action.String(),
+			}).Errorln("consume error:", err)
+		}
+		if e := repo.ReplyRpc(msg.Context(), action); e != nil {
+			logger[0].WithField("id", msg.ID).Errorln("rpc reply error:", e)
+		}
+		if e := Reply(broker, action); e != nil {
+			logger[0].WithField("id", msg.ID).Errorln("consume reply error:", e)
+		}
+	}
+}
+
+// 处理消息 [绑定超时, 异常、超时及停止消费视为重试, 反复异常时隔离, 失败时记录]
+func handleSafely(binding *repo.QueueBinding, msg *entity.QueueMessage) (action entity.ConsumeAction, err error) {
+	var stack string
+	defer func() {
+		if e := recover(); e != nil {
+			action, err, stack = entity.ConsumeRetry, fmt.Errorf("panic: %v", e), string(debug.Stack())
+			// 反复异常的毒消息 隔离后确认
+			if repo.GetQuarantineRepo().Panicked(messageDriver(msg), binding.Name, msg, err, stack) {
+				action = entity.ConsumeAck
+			}
+		}
+		if err != nil || action != entity.ConsumeAck {
+			repo.RecordFailure(&entity.ConsumeFailure{
+				Queue:    msg.Queue,
+				Consumer: binding.Consumer.Type(),
+				Binding:  binding.Name,
+				Action:   action,
+				Panic:    stack != "",
+				Stack:    stack,
+				Err:      err,
+				Message:  msg,
+			})
+		}
+	}()
+	return repo.HandleContext(msg.Context(), binding.Consumer, msg, binding.Timeout)
+}
+
+// 消息来源驱动
+func messageDriver(msg *entity.QueueMessage) string {
+	switch msg.GetRowMessage().(type) {
+	case *amqp.Delivery:
+		return repo.DriverAmqp
+	case *redis.XMessage:
+		return repo.DriverRedis
+	}
+	return ""
+}
+
+// CreateBinding 创建队列绑定 [绑定协程池容量取队列 ConsumerMaxNum 与批量消费器批次大小的较大值, 处理超时取 properties.consume_timeout, policy 为空时不延迟重投]
+func CreateBinding(queue entity.QueueParams, name, filter string, consumer facede.Consumer, policy *entity.RetryPolicy) (*repo.QueueBinding, error) {
+	var size = repo.BindingPoolSize(queue.ConsumerMaxNum)
+	// 批量消费器 并发提交至批次大小, 预取数随之放大
+	if batcher, ok := consumer.(facede.BatchConsumer); ok && batcher.BatchSize() > size {
+		size = batcher.BatchSize()
+	}
+	binding, err := repo.NewQueueBinding(name, filter, size, consumer)
+	if err != nil {
+		return nil, err
+	}
+	binding.Timeout = queue.ConsumeTimeout()
+	if policy != nil {
+		if err = policy.Verify(); err != nil {
+			return nil, fmt.Errorf("binding %s: %v", name, err)
+		}
+		binding.Retry = policy
+	}
+	return binding, nil
+}
+
+// CreateDedup 创建去重窗口 [存储由 QUEUE_DEDUP_STORE 指定 redis|leveldb, 默认 redis]
+func CreateDedup(window time.Duration, by string) (*repo.Dedup, error) {
+	switch store := strings.ToLower(utils.GetEnvVal(EnvDedupStore, repo.DedupStoreRedis)); store {
+	case repo.DedupStoreRedis:
+		var db = repo.RedisDb(utils.GetEnvVal(EnvDedupRedis, repo.DefaultRedis))
+		if db == nil {
+			return nil, fmt.Errorf("dedup redis connection missing")
+		}
+		return repo.NewDedup(window, by, repo.NewRedisDedupStore(db))
+	case repo.DedupStoreLevelDB:
+		db, ok := repo.GetLocalStorageRepo().GetStorage()
+		if !ok {
+			return nil, fmt.Errorf("dedup leveldb storage missing")
+		}
+		return repo.NewDedup(window, by, repo.NewLevelDedupStore(db))
+	default:
+		return nil, fmt.Errorf("unknown dedup store: %s", store)
+	}
+}
+
+// SetDeadLetter 按队列配置设置死信队列 [properties.dead_letter_queue, 需在队列声明前调用]
+func SetDeadLetter(queue entity.QueueParams) error {
+	return repo.SetDeadLetterQueue(queue.Name, queue.DeadLetterQueue())
+}
+
+// Reply 按处理动作应答消息 [非 amqp 消息忽略]
+func Reply(broker rabbitmq.MessageWrapper, action entity.ConsumeAction) error {
+	var replier, ok = broker.(rabbitmq.MessageReplier)
+	if !ok {
+		return nil
+	}
+	switch action {
+	case entity.ConsumeAck:
+		return replier.Ack(false)
+	case entity.ConsumeRetry:
+		return replier.Nack(false, true)
+	default:
+		return replier.Nack(false, false)
+	}
+}
+
+// 消息元信息请求头
+func messageHeaders(msg *entity.QueueMessage) map[string]string {
+	return map[string]string{
+		HeaderQueueName:      msg.Queue,
+		HeaderQueueMessageID: msg.ID,
+		HeaderQueueAttempts:  fmt.Sprintf("%d", msg.Attempts),
+	}
+}
+
+// 消息环境变量 [QUEUE_NAME, QUEUE_MESSAGE_ID, QUEUE_DELIVERY_COUNT, QUEUE_<HEADER>]
+func messageEnv(msg *entity.QueueMessage) map[string]string {
+	var env = make(map[string]string)
+	for k, v := range msg.Headers {
+		var name = envNameReplacer.ReplaceAllString(strings.ToUpper(k), "_")
+		env[EnvQueuePrefix+name] = fmt.Sprintf("%v", v)
+	}
+	env[EnvQueuePrefix+"NAME"] = msg.Queue
+	env[EnvQueuePrefix+"MESSAGE_ID"] = msg.ID
+	env[EnvQueuePrefix+"DELIVERY_COUNT"] = fmt.Sprintf("%d", msg.Attempts)
+	return env
+}
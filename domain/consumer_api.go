@@ -0,0 +1,250 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ApiConsumerDomainImpl http webhook 消费器
+type ApiConsumerDomainImpl struct {
+	params     entity.KvMap
+	url        string
+	method     string
+	headers    map[string]string
+	typeHeader string
+	timeout    time.Duration
+	secret     string
+	signHeader string
+	tpl        *template.Template
+	client     *fasthttp.HostClient
+}
+
+const (
+	ParamApiUrl          = "url"
+	ParamApiMethod       = "method"
+	ParamApiHeaders      = "headers"
+	ParamApiTimeout      = "timeout"
+	ParamApiBodyTemplate = "body_template"
+	ParamApiSecret       = "secret"
+	ParamApiSignHeader   = "sign_header"
+	HeaderApiTimestamp   = "X-Queue-Timestamp"
+	defaultApiSignHeader = "X-Queue-Signature"
+	defaultApiTimeout    = 30 * time.Second
+	apiUserAgent         = "queue_mgr/webhook"
+)
+
+func NewApiConsumerDomain() *ApiConsumerDomainImpl {
+	var domain = new(ApiConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *ApiConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.method = fiber.MethodPost
+	domain.headers = map[string]string{}
+	domain.timeout = defaultApiTimeout
+	domain.signHeader = defaultApiSignHeader
+}
+
+func (domain *ApiConsumerDomainImpl) Type() string {
+	return entity.ConsumerApi
+}
+
+// Parse 解析配置
+// {"url":"https://host/hook","method":"POST","headers":{"k":"v"},"timeout":"10s","body_template":"{{.Body}}","secret":"","sign_header":"X-Queue-Signature"}
+func (domain *ApiConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.url = domain.params.GetStr(ParamApiUrl)
+	if domain.url == "" {
+		return errors.New("miss param: " + ParamApiUrl)
+	}
+	domain.method = strings.ToUpper(domain.params.GetStr(ParamApiMethod, fiber.MethodPost))
+	domain.timeout = domain.params.GetDuration(ParamApiTimeout, defaultApiTimeout)
+	domain.secret = domain.params.GetStr(ParamApiSecret, utils.GetEnvVal("APP_SECRET"))
+	domain.signHeader = domain.params.GetStr(ParamApiSignHeader, defaultApiSignHeader)
+	for k, v := range domain.params.GetKvMap(ParamApiHeaders) {
+		if strings.EqualFold(k, fiber.HeaderContentType) {
+			domain.typeHeader = fmt.Sprintf("%v", v)
+			continue
+		}
+		domain.headers[k] = fmt.Sprintf("%v", v)
+	}
+	if text := domain.params.GetStr(ParamApiBodyTemplate); text != "" {
+		tpl, err := template.New(ParamApiBodyTemplate).Parse(text)
+		if err != nil {
+			return err
+		}
+		domain.tpl = tpl
+	}
+	return domain.initClient()
+}
+
+// 复用连接 [同一 webhook 共享 HostClient]
+func (domain *ApiConsumerDomainImpl) initClient() error {
+	var agent = fiber.AcquireAgent()
+	defer fiber.ReleaseAgent(agent)
+	agent.Request().SetRequestURI(domain.url)
+	if err := agent.Parse(); err != nil {
+		return err
+	}
+	domain.client = agent.HostClient
+	return nil
+}
+
+// Handle 推送消息到 webhook [2xx:确认, 4xx:丢弃, 5xx|超时:重试]
+func (domain *ApiConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var _, action, err = domain.ProcessContext(msg.Context(), msg)
+	return action, err
+}
+
+// HandleContext 推送消息到 webhook [请求超时不超过 ctx 截止时间]
+func (domain *ApiConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var _, action, err = domain.ProcessContext(ctx, msg)
+	return action, err
+}
+
+// 请求超时 [取配置超时与 ctx 剩余时间较小者]
+func (domain *ApiConsumerDomainImpl) timeoutOf(ctx context.Context) time.Duration {
+	var timeout = domain.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < timeout {
+			// 已到期时 fasthttp 超时为 0 表示不限时
+			timeout = left
+			if timeout <= 0 {
+				timeout = time.Millisecond
+			}
+		}
+	}
+	return timeout
+}
+
+// Process 推送消息到 webhook [响应体作为输出消息]
+func (domain *ApiConsumerDomainImpl) Process(msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	return domain.ProcessContext(msg.Context(), msg)
+}
+
+// ProcessContext 推送消息到 webhook [请求超时不超过 ctx 截止时间, 已取消时不再请求]
+func (domain *ApiConsumerDomainImpl) ProcessContext(ctx context.Context, msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	if ctx.Err() != nil {
+		return nil, entity.ConsumeRetry, entity.ContextError(ctx, nil)
+	}
+	body, err := domain.render(msg)
+	if err != nil {
+		return nil, entity.ConsumeDrop, err
+	}
+	var (
+		agent     = fiber.AcquireAgent()
+		req       = agent.Request()
+		timestamp = fmt.Sprintf("%d", time.Now().Unix())
+	)
+	req.Header.SetMethod(domain.method)
+	req.SetRequestURI(domain.url)
+	for k, v := range domain.headers {
+		agent.Set(k, v)
+	}
+	agent.ContentType(domain.getContentType(msg))
+	for k, v := range messageHeaders(msg) {
+		agent.Set(k, v)
+	}
+	agent.Set(HeaderApiTimestamp, timestamp)
+	if domain.secret != "" {
+		agent.Set(domain.signHeader, domain.Sign(timestamp, body))
+	}
+	agent.UserAgent(apiUserAgent)
+	agent.Timeout(domain.timeoutOf(ctx))
+	agent.Body(body)
+	if err = agent.Parse(); err != nil {
+		fiber.ReleaseAgent(agent)
+		return nil, entity.ConsumeDrop, err
+	}
+	agent.HostClient = domain.client
+	var response = fiber.AcquireResponse()
+	defer fiber.ReleaseResponse(response)
+	code, resp, errs := agent.SetResponse(response).Bytes()
+	if len(errs) > 0 {
+		return nil, entity.ConsumeRetry, errs[0]
+	}
+	var output = entity.NewQueueMessage(msg.Queue, resp, msg.Headers.Copy())
+	output.ID = msg.ID
+	output.Attempts = msg.Attempts
+	output.ContentType = string(response.Header.ContentType())
+	output.Timestamp = msg.Timestamp
+	action, err := domain.actionOf(code, resp)
+	return output, action, err
+}
+
+// Sign 签名 hex(hmac_sha256(secret, timestamp + "." + body))
+func (domain *ApiConsumerDomainImpl) Sign(timestamp string, body []byte) string {
+	var mac = hmac.New(sha256.New, []byte(domain.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 按响应状态码决定处理动作
+func (domain *ApiConsumerDomainImpl) actionOf(code int, resp []byte) (entity.ConsumeAction, error) {
+	switch {
+	case code >= 200 && code < 300:
+		return entity.ConsumeAck, nil
+	// 请求超时|限流 视为临时失败
+	case code == fasthttp.StatusRequestTimeout || code == fasthttp.StatusTooManyRequests:
+		return entity.ConsumeRetry, fmt.Errorf("webhook response %d: %s", code, domain.brief(resp))
+	case code >= 400 && code < 500:
+		return entity.ConsumeDrop, fmt.Errorf("webhook response %d: %s", code, domain.brief(resp))
+	}
+	return entity.ConsumeRetry, fmt.Errorf("webhook response %d: %s", code, domain.brief(resp))
+}
+
+// 请求体 [未配置模板时直接发送消息体]
+func (domain *ApiConsumerDomainImpl) render(msg *entity.QueueMessage) ([]byte, error) {
+	if domain.tpl == nil {
+		return msg.Body, nil
+	}
+	var buf = bytes.NewBuffer(nil)
+	if err := domain.tpl.Execute(buf, map[string]interface{}{
+		"ID":       msg.ID,
+		"Queue":    msg.Queue,
+		"Body":     string(msg.Body),
+		"Headers":  msg.Headers,
+		"Attempts": msg.Attempts,
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (domain *ApiConsumerDomainImpl) getContentType(msg *entity.QueueMessage) string {
+	if domain.typeHeader != "" {
+		return domain.typeHeader
+	}
+	if msg.ContentType != "" && domain.tpl == nil {
+		return msg.ContentType
+	}
+	return fiber.MIMEApplicationJSON
+}
+
+// 响应摘要
+func (domain *ApiConsumerDomainImpl) brief(resp []byte) string {
+	if len(resp) > 256 {
+		return string(resp[:256])
+	}
+	return string(resp)
+}
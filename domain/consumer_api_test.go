@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestApiConsumerDomainImpl_Handle(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var code, _ = strconv.Atoi(r.URL.Query().Get("code"))
+		body, _ := ioutil.ReadAll(r.Body)
+		var consumer = NewApiConsumerDomain()
+		consumer.secret = "secret"
+		if r.Header.Get(defaultApiSignHeader) != consumer.Sign(r.Header.Get(HeaderApiTimestamp), body) {
+			code = http.StatusUnauthorized
+		}
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	var cases = map[int]entity.ConsumeAction{
+		http.StatusOK:                  entity.ConsumeAck,
+		http.StatusNotFound:            entity.ConsumeDrop,
+		http.StatusTooManyRequests:     entity.ConsumeRetry,
+		http.StatusInternalServerError: entity.ConsumeRetry,
+	}
+	for code, expect := range cases {
+		var (
+			consumer   = NewApiConsumerDomain()
+			properties = fmt.Sprintf(`{"url":"%s/?code=%d","secret":"secret","timeout":"3s"}`, server.URL, code)
+		)
+		if err := consumer.Parse([]byte(properties)); err != nil {
+			t.Fatal(err)
+		}
+		action, _ := consumer.Handle(entity.NewQueueMessage("test", []byte(`{"id":1}`)))
+		if action != expect {
+			t.Errorf("ApiConsumer Handle code %d expect %s, got %s", code, expect, action)
+		}
+	}
+}
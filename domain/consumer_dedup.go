@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+)
+
+// DedupConsumerDomainImpl 去重消费器 [不支持绑定分发的驱动使用, 窗口内重复消息直接确认, 处理成功保留去重键至窗口结束, 失败释放]
+type DedupConsumerDomainImpl struct {
+	facede.Consumer
+	dedup   *repo.Dedup
+	binding string
+}
+
+// WithDedup 包装消费器 [binding: 去重键使用的绑定名]
+func WithDedup(consumer facede.Consumer, dedup *repo.Dedup, binding string) *DedupConsumerDomainImpl {
+	return &DedupConsumerDomainImpl{Consumer: consumer, dedup: dedup, binding: binding}
+}
+
+// Handle 处理消息
+func (domain *DedupConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	return domain.HandleContext(msg.Context(), msg)
+}
+
+// HandleContext 处理消息 [存储异常时不去重, 异常时释放去重键]
+func (domain *DedupConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (action entity.ConsumeAction, err error) {
+	var (
+		key    = domain.dedup.Key(msg.Queue, domain.binding, msg)
+		ok, e  = domain.dedup.Reserve(key)
+		logger = repo.GetLogger("consumer").WithFields(logrus.Fields{"queue": msg.Queue, "binding": domain.binding, "id": msg.ID})
+	)
+	switch {
+	case e != nil:
+		logger.Warnln("dedup reserve error:", e)
+		return repo.HandleContext(ctx, domain.Consumer, msg, 0)
+	case !ok:
+		repo.GetPrometheusRepo().IncrDedupDuplicate(msg.Queue, domain.binding)
+		logger.Infoln("dedup duplicate message acked")
+		return entity.ConsumeAck, nil
+	}
+	action = entity.ConsumeRetry
+	defer func() {
+		if action == entity.ConsumeAck {
+			e = domain.dedup.Commit(key)
+		} else {
+			e = domain.dedup.Release(key)
+		}
+		if e != nil {
+			err = joinError(err, e)
+		}
+	}()
+	return repo.HandleContext(ctx, domain.Consumer, msg, 0)
+}
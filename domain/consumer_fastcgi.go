@@ -0,0 +1,239 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"github.com/yookoala/gofast"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// FastCgiConsumerDomainImpl FastCGI 消费器 [消息直接投递 php-fpm, 无需 http 请求]
+	FastCgiConsumerDomainImpl struct {
+		params   entity.KvMap
+		file     string
+		timeout  time.Duration
+		extras   map[string]string
+		upstream facede.FastCgiUpstream
+		session  gofast.SessionHandler
+		logger   *logrus.Logger
+	}
+
+	// FastCGI 响应收集
+	fastCgiResponse struct {
+		code   int
+		header http.Header
+		body   bytes.Buffer
+	}
+
+	// 请求上下文 消息键
+	fastCgiMessageKey struct{}
+)
+
+const (
+	ParamFastCgiParams = "fastcgi_params"
+	// HeaderQueueAction 脚本响应头 指定处理动作 ack|retry|drop
+	HeaderQueueAction     = "X-Queue-Action"
+	defaultFastCgiTimeout = time.Minute
+)
+
+func NewFastCgiConsumerDomain() *FastCgiConsumerDomainImpl {
+	var domain = new(FastCgiConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *FastCgiConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.timeout = defaultFastCgiTimeout
+	domain.extras = map[string]string{}
+}
+
+func (domain *FastCgiConsumerDomainImpl) Type() string {
+	return entity.ConsumerFastCGI
+}
+
+// Parse 解析配置
+// {"fastcgi_pass":"127.0.0.1:9000|unix:/run/php-fpm.sock","fastcgi_file":"/app/queue.php","fastcgi_timeout":"30s","fastcgi_params":{"APP_ENV":"prod"},"fastcgi_max_open":32,"ping.path":"/ping"}
+func (domain *FastCgiConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.file = domain.params.GetStr(ParamFastCgiFile)
+	if domain.file == "" {
+		return errors.New("miss param: " + ParamFastCgiFile)
+	}
+	domain.timeout = domain.params.GetDuration(ParamFastCgiTimeout, defaultFastCgiTimeout)
+	for k, v := range domain.params.GetKvMap(ParamFastCgiParams) {
+		domain.extras[k] = fmt.Sprintf("%v", v)
+	}
+	upstream, err := getFastCgiUpstream(domain.params)
+	if err != nil {
+		return err
+	}
+	domain.upstream = upstream
+	domain.session = gofast.Chain(
+		gofast.BasicParamsMap,
+		gofast.MapHeader,
+		gofast.MapEndpoint(domain.file),
+		domain.mapParams,
+	)(gofast.BasicSession)
+	return nil
+}
+
+// SetLogger 设置脚本 stderr 输出日志
+func (domain *FastCgiConsumerDomainImpl) SetLogger(logger *logrus.Logger) *FastCgiConsumerDomainImpl {
+	domain.logger = logger
+	return domain
+}
+
+// Handle 投递消息到 FastCGI 脚本
+// [X-Queue-Action 响应头优先, 否则 2xx:确认, 4xx:丢弃, 5xx|超时|连接失败:重试]
+func (domain *FastCgiConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	return domain.HandleContext(msg.Context(), msg)
+}
+
+// HandleContext 投递消息到 FastCGI 脚本 [ctx 取消时中断读取]
+func (domain *FastCgiConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, fastCgiMessageKey{}, msg), domain.timeout)
+	defer cancel()
+	req, err := domain.createRequest(ctx, msg)
+	if err != nil {
+		return entity.ConsumeDrop, err
+	}
+	client, err := domain.upstream.CreateClient()
+	if err != nil {
+		return entity.ConsumeRetry, err
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	pipe, err := domain.session(client, gofast.NewRequest(req))
+	if err != nil {
+		return entity.ConsumeRetry, err
+	}
+	var (
+		resp   = newFastCgiResponse()
+		stderr = domain.getWriter(msg)
+	)
+	defer stderr.Flush()
+	if err = pipe.WriteTo(resp, stderr); err != nil {
+		return entity.ConsumeRetry, err
+	}
+	if ctx.Err() != nil {
+		return entity.ConsumeRetry, ctx.Err()
+	}
+	return resp.actionOf()
+}
+
+// 构造模拟请求 [消息体作为请求体, 队列信息作为请求头]
+func (domain *FastCgiConsumerDomainImpl) createRequest(ctx context.Context, msg *entity.QueueMessage) (*http.Request, error) {
+	var uri = "http://localhost/" + filepath.Base(domain.file)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+	var contentType = msg.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(msg.Body)))
+	for k, v := range messageHeaders(msg) {
+		req.Header.Set(k, v)
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	req.RequestURI = req.URL.RequestURI()
+	return req, nil
+}
+
+// 附加 FastCGI 参数 [QUEUE_* 及 fastcgi_params]
+func (domain *FastCgiConsumerDomainImpl) mapParams(inner gofast.SessionHandler) gofast.SessionHandler {
+	return func(client gofast.Client, req *gofast.Request) (*gofast.ResponsePipe, error) {
+		for k, v := range domain.extras {
+			req.Params[k] = v
+		}
+		if msg, ok := req.Raw.Context().Value(fastCgiMessageKey{}).(*entity.QueueMessage); ok {
+			for k, v := range messageEnv(msg) {
+				req.Params[k] = v
+			}
+		}
+		return inner(client, req)
+	}
+}
+
+func (domain *FastCgiConsumerDomainImpl) getWriter(msg *entity.QueueMessage) *logWriter {
+	if domain.logger == nil {
+		domain.logger = repo.GetLogger("consumer")
+	}
+	var logger = domain.logger.WithFields(logrus.Fields{"fastcgi": domain.file, "id": msg.ID})
+	return &logWriter{level: logrus.WarnLevel, logger: logger}
+}
+
+func newFastCgiResponse() *fastCgiResponse {
+	return &fastCgiResponse{header: http.Header{}}
+}
+
+func (resp *fastCgiResponse) Header() http.Header {
+	return resp.header
+}
+
+func (resp *fastCgiResponse) Write(p []byte) (int, error) {
+	if resp.code == 0 {
+		resp.code = http.StatusOK
+	}
+	return resp.body.Write(p)
+}
+
+func (resp *fastCgiResponse) WriteHeader(code int) {
+	if resp.code == 0 {
+		resp.code = code
+	}
+}
+
+// 响应转换处理动作
+func (resp *fastCgiResponse) actionOf() (entity.ConsumeAction, error) {
+	var (
+		code = resp.code
+		err  error
+	)
+	if code < 200 || code >= 300 {
+		err = fmt.Errorf("fastcgi response %d: %s", code, resp.brief())
+	}
+	switch strings.ToLower(resp.header.Get(HeaderQueueAction)) {
+	case entity.ConsumeAck.String():
+		return entity.ConsumeAck, nil
+	case entity.ConsumeRetry.String():
+		return entity.ConsumeRetry, err
+	case entity.ConsumeDrop.String():
+		return entity.ConsumeDrop, err
+	}
+	switch {
+	case code >= 200 && code < 300:
+		return entity.ConsumeAck, nil
+	case code >= 400 && code < 500:
+		return entity.ConsumeDrop, err
+	}
+	return entity.ConsumeRetry, err
+}
+
+// 响应摘要
+func (resp *fastCgiResponse) brief() string {
+	if resp.body.Len() > 256 {
+		return string(resp.body.Bytes()[:256])
+	}
+	return resp.body.String()
+}
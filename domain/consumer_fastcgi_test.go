@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"strconv"
+	"testing"
+)
+
+func TestFastCgiConsumerDomainImpl_Handle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var (
+				env     = fcgi.ProcessEnv(r)
+				body, _ = ioutil.ReadAll(r.Body)
+				code, _ = strconv.Atoi(string(body))
+			)
+			if env["QUEUE_NAME"] != "test" || env["APP_ENV"] != "testing" {
+				code = http.StatusBadRequest
+			}
+			if code == http.StatusConflict {
+				w.Header().Set(HeaderQueueAction, entity.ConsumeRetry.String())
+			}
+			w.WriteHeader(code)
+		}))
+	}()
+
+	var (
+		consumer   = NewFastCgiConsumerDomain()
+		properties = fmt.Sprintf(`{"fastcgi_pass":"%s","fastcgi_file":"/app/queue.php","fastcgi_timeout":"3s","fastcgi_params":{"APP_ENV":"testing"}}`, listener.Addr())
+	)
+	if err = consumer.Parse([]byte(properties)); err != nil {
+		t.Fatal(err)
+	}
+	var cases = map[int]entity.ConsumeAction{
+		http.StatusOK:                  entity.ConsumeAck,
+		http.StatusNotFound:            entity.ConsumeDrop,
+		http.StatusConflict:            entity.ConsumeRetry,
+		http.StatusInternalServerError: entity.ConsumeRetry,
+	}
+	for code, expect := range cases {
+		action, _ := consumer.Handle(entity.NewQueueMessage("test", []byte(strconv.Itoa(code))))
+		if action != expect {
+			t.Errorf("code %d: expect %s, got %s", code, expect, action)
+		}
+	}
+}
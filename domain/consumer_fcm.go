@@ -0,0 +1,312 @@
+package domain
+
+import (
+	"errors"
+	"firebase.google.com/go/messaging"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/service"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// FcmConsumerDomainImpl firebase 推送消费器 [消息聚合批量发送, 失效 token 投递清理队列]
+	FcmConsumerDomainImpl struct {
+		locker    sync.Mutex
+		safe      sync.RWMutex
+		params    entity.KvMap
+		app       string
+		batchSize int
+		batchWait time.Duration
+		queue     string
+		pending   chan *fcmItem
+		stop      chan struct{}
+		started   bool
+		incoming  int32 // 已提交未入批次的消息数
+		pusher    facede.FcmPusher
+		cleanup   facede.QueueEntry
+	}
+
+	// 待发送消息
+	fcmItem struct {
+		source  *entity.QueueMessage
+		message *messaging.Message
+		result  chan fcmResult
+	}
+
+	// 发送结果
+	fcmResult struct {
+		action entity.ConsumeAction
+		err    error
+	}
+
+	// FcmInvalidToken 失效 token 清理消息
+	FcmInvalidToken struct {
+		Token     string `json:"token"`
+		Reason    string `json:"reason"`
+		Queue     string `json:"queue"`
+		MessageID string `json:"message_id"`
+		Timestamp int64  `json:"timestamp"`
+	}
+)
+
+const (
+	ParamFcmApp              = "app"
+	ParamFcmBatchSize        = "batch_size"
+	ParamFcmBatchWait        = "batch_wait"
+	ParamFcmCleanupQueue     = "cleanup_queue"
+	ParamFcmCleanupDriver    = "cleanup_driver"
+	ParamFcmCleanupNamespace = "cleanup_namespace"
+	// FcmReasonUnregistered token 已注销
+	FcmReasonUnregistered = "unregistered"
+	// FcmReasonInvalid token 格式无效
+	FcmReasonInvalid    = "invalid"
+	defaultFcmBatchWait = 100 * time.Millisecond
+	maxFcmBatchSize     = 500
+	// sdk 校验消息失败时的错误前缀
+	fcmInvalidMessage = "invalid message"
+)
+
+var (
+	ErrorFcmConsumerClosed = errors.New("fcm consumer closed")
+	ErrorFcmMissResponse   = errors.New("fcm batch response missing")
+)
+
+func NewFcmConsumerDomain() *FcmConsumerDomainImpl {
+	var domain = new(FcmConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *FcmConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.batchSize = maxFcmBatchSize
+	domain.batchWait = defaultFcmBatchWait
+	domain.stop = make(chan struct{})
+}
+
+func (domain *FcmConsumerDomainImpl) Type() string {
+	return entity.ConsumerFcm
+}
+
+// BatchSize 单批最大消息数 [绑定并发数不低于此值]
+func (domain *FcmConsumerDomainImpl) BatchSize() int {
+	return domain.batchSize
+}
+
+// Parse 解析配置
+// {"app":"","batch_size":500,"batch_wait":"100ms","cleanup_queue":"fcm_invalid_tokens","cleanup_driver":"AMQP","cleanup_namespace":""}
+func (domain *FcmConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.app = domain.params.GetStr(ParamFcmApp)
+	domain.batchSize = domain.params.GetInt(ParamFcmBatchSize, maxFcmBatchSize)
+	if domain.batchSize <= 0 || domain.batchSize > maxFcmBatchSize {
+		return fmt.Errorf("batch_size must be in 1..%d", maxFcmBatchSize)
+	}
+	domain.batchWait = domain.params.GetDuration(ParamFcmBatchWait, defaultFcmBatchWait)
+	domain.pending = make(chan *fcmItem, domain.batchSize)
+	domain.pusher = service.NewFireBasePusher(domain.app)
+	domain.queue = domain.params.GetStr(ParamFcmCleanupQueue)
+	if domain.queue == "" {
+		return nil
+	}
+	var driver = strings.ToUpper(domain.params.GetStr(ParamFcmCleanupDriver, repo.DriverAmqp))
+	entry, err := repo.GetQueueDriverRepo().Get(driver, domain.params.GetStr(ParamFcmCleanupNamespace))
+	if err != nil {
+		return err
+	}
+	domain.cleanup = entry
+	return nil
+}
+
+// SetPusher 指定推送客户端 [默认按 app 配置创建]
+func (domain *FcmConsumerDomainImpl) SetPusher(pusher facede.FcmPusher) *FcmConsumerDomainImpl {
+	domain.pusher = pusher
+	return domain
+}
+
+// SetCleanup 指定失效 token 清理队列
+func (domain *FcmConsumerDomainImpl) SetCleanup(entry facede.QueueEntry, queue string) *FcmConsumerDomainImpl {
+	domain.cleanup = entry
+	domain.queue = queue
+	return domain
+}
+
+// Handle 推送消息 [等待所在批次发送, 临时错误重试, 失效 token 清理后丢弃]
+func (domain *FcmConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var message = new(messaging.Message)
+	if err := utils.JsonDecode(msg.Body, message); err != nil {
+		return entity.ConsumeDrop, err
+	}
+	if message.Token == "" && message.Topic == "" && message.Condition == "" {
+		return entity.ConsumeDrop, errors.New("fcm message miss token|topic|condition")
+	}
+	domain.start()
+	var item = &fcmItem{source: msg, message: message, result: make(chan fcmResult, 1)}
+	if !domain.enqueue(item) {
+		return entity.ConsumeRetry, ErrorFcmConsumerClosed
+	}
+	var result = <-item.result
+	return result.action, result.err
+}
+
+// Close 停止批量发送 [发送中的批次完成, 未发送的消息重试]
+func (domain *FcmConsumerDomainImpl) Close() {
+	domain.safe.Lock()
+	defer domain.safe.Unlock()
+	select {
+	case <-domain.stop:
+	default:
+		close(domain.stop)
+	}
+}
+
+// 加入待发送批次 [已关闭时返回 false]
+func (domain *FcmConsumerDomainImpl) enqueue(item *fcmItem) bool {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
+	select {
+	case <-domain.stop:
+		return false
+	default:
+	}
+	atomic.AddInt32(&domain.incoming, 1)
+	domain.pending <- item
+	return true
+}
+
+// 启动批量发送协程
+func (domain *FcmConsumerDomainImpl) start() {
+	domain.locker.Lock()
+	defer domain.locker.Unlock()
+	if domain.started {
+		return
+	}
+	domain.started = true
+	go domain.loop()
+}
+
+// 按 batch_size|batch_wait 聚合批次 [无其他消息在途时提前提交]
+func (domain *FcmConsumerDomainImpl) loop() {
+	var batch = make([]*fcmItem, 0, domain.batchSize)
+	for {
+		select {
+		case <-domain.stop:
+			domain.drain()
+			return
+		case item := <-domain.pending:
+			atomic.AddInt32(&domain.incoming, -1)
+			batch = append(batch[:0], item)
+		}
+		var timer = time.NewTimer(domain.batchWait)
+	collect:
+		// 无其他消息在途时立即提交 不等待 batch_wait
+		for len(batch) < domain.batchSize && atomic.LoadInt32(&domain.incoming) > 0 {
+			select {
+			case item := <-domain.pending:
+				atomic.AddInt32(&domain.incoming, -1)
+				batch = append(batch, item)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		domain.flush(batch)
+	}
+}
+
+// 关闭后 未发送的消息返回错误
+func (domain *FcmConsumerDomainImpl) drain() {
+	for {
+		select {
+		case item := <-domain.pending:
+			atomic.AddInt32(&domain.incoming, -1)
+			item.result <- fcmResult{action: entity.ConsumeRetry, err: ErrorFcmConsumerClosed}
+		default:
+			return
+		}
+	}
+}
+
+// 批量发送 [按 BatchResponse 逐条处理结果, 消息校验失败时逐条发送]
+func (domain *FcmConsumerDomainImpl) flush(batch []*fcmItem) {
+	var messages = make([]*messaging.Message, 0, len(batch))
+	for _, item := range batch {
+		messages = append(messages, item.message)
+	}
+	resp, err := domain.pusher.SendBatch(messages)
+	if err != nil {
+		var invalid = strings.HasPrefix(err.Error(), fcmInvalidMessage)
+		if invalid && len(batch) > 1 {
+			for _, item := range batch {
+				domain.flush([]*fcmItem{item})
+			}
+			return
+		}
+		var action = entity.ConsumeRetry
+		if invalid {
+			action = entity.ConsumeDrop
+		}
+		for _, item := range batch {
+			item.result <- fcmResult{action: action, err: err}
+		}
+		return
+	}
+	for i, item := range batch {
+		if i >= len(resp.Responses) || resp.Responses[i] == nil {
+			item.result <- fcmResult{action: entity.ConsumeRetry, err: ErrorFcmMissResponse}
+			continue
+		}
+		item.result <- domain.resultOf(item, resp.Responses[i])
+	}
+}
+
+// 单条发送结果 [成功:确认, 服务端临时错误:重试, 失效 token:清理后丢弃, 其他:丢弃]
+func (domain *FcmConsumerDomainImpl) resultOf(item *fcmItem, resp *messaging.SendResponse) fcmResult {
+	if resp.Success {
+		return fcmResult{action: entity.ConsumeAck}
+	}
+	var err = resp.Error
+	if err == nil {
+		err = errors.New("fcm send failed")
+	}
+	switch {
+	case messaging.IsServerUnavailable(err), messaging.IsInternal(err),
+		messaging.IsMessageRateExceeded(err), messaging.IsUnknown(err):
+		return fcmResult{action: entity.ConsumeRetry, err: err}
+	case messaging.IsRegistrationTokenNotRegistered(err):
+		return domain.invalidate(item, FcmReasonUnregistered, err)
+	case messaging.IsInvalidArgument(err) && item.message.Token != "":
+		return domain.invalidate(item, FcmReasonInvalid, err)
+	}
+	return fcmResult{action: entity.ConsumeDrop, err: err}
+}
+
+// 失效 token 投递清理队列 [投递失败时重试, 保证清理消息不丢失]
+func (domain *FcmConsumerDomainImpl) invalidate(item *fcmItem, reason string, err error) fcmResult {
+	if domain.cleanup == nil || domain.queue == "" || item.message.Token == "" {
+		return fcmResult{action: entity.ConsumeDrop, err: err}
+	}
+	var body = utils.JsonEncode(FcmInvalidToken{
+		Token:     item.message.Token,
+		Reason:    reason,
+		Queue:     item.source.Queue,
+		MessageID: item.source.ID,
+		Timestamp: time.Now().Unix(),
+	}).Bytes()
+	if e := domain.cleanup.Push(entity.NewQueueMessage(domain.queue, body), domain.queue); e != nil {
+		return fcmResult{action: entity.ConsumeRetry, err: fmt.Errorf("%v; cleanup: %v", err, e)}
+	}
+	return fcmResult{action: entity.ConsumeDrop, err: err}
+}
@@ -0,0 +1,226 @@
+package domain
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/service"
+	"google.golang.org/api/option"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 请求改写到本地 fcm 服务
+type fcmTestTransport struct {
+	target *url.URL
+}
+
+func (transport fcmTestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = transport.target.Scheme, transport.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// 模拟 fcm batch 接口 [token 前缀决定结果: ok 成功, gone 已注销, busy 服务不可用]
+func fcmTestHandler(batches *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(batches, 1)
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var (
+			reader = multipart.NewReader(r.Body, params["boundary"])
+			writer = multipart.NewWriter(w)
+		)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			if err != nil {
+				break
+			}
+			var payload struct {
+				Message struct {
+					Token string `json:"token"`
+				} `json:"message"`
+			}
+			_ = json.NewDecoder(req.Body).Decode(&payload)
+			var status, body = http.StatusOK, `{"name":"projects/test/messages/1"}`
+			switch {
+			case strings.HasPrefix(payload.Message.Token, "gone"):
+				status, body = http.StatusNotFound, `{"error":{"status":"NOT_FOUND","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`
+			case strings.HasPrefix(payload.Message.Token, "busy"):
+				status, body = http.StatusServiceUnavailable, `{"error":{"status":"UNAVAILABLE"}}`
+			}
+			out, _ := writer.CreatePart(map[string][]string{"Content-Type": {"application/http"}})
+			_, _ = fmt.Fprintf(out, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\n\r\n%s", status, http.StatusText(status), body)
+		}
+		_ = writer.Close()
+	}
+}
+
+func TestFcmConsumerDomainImpl_Handle(t *testing.T) {
+	var batches int32
+	var server = httptest.NewServer(fcmTestHandler(&batches))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	app, err := firebase.NewApp(context.Background(), &firebase.Config{ProjectID: "test"},
+		option.WithHTTPClient(&http.Client{Transport: fcmTestTransport{target: target}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := app.Messaging(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		cleanup  = new(proxyTestEntry)
+		consumer = NewFcmConsumerDomain()
+	)
+	if err = consumer.Parse([]byte(`{"batch_size":3,"batch_wait":"5s"}`)); err != nil {
+		t.Fatal(err)
+	}
+	consumer.SetPusher(service.NewFireBasePusher().SetClient(client)).SetCleanup(cleanup, "fcm_invalid_tokens")
+	defer consumer.Close()
+
+	var (
+		wait    sync.WaitGroup
+		cases   = map[string]entity.ConsumeAction{"ok-1": entity.ConsumeAck, "gone-1": entity.ConsumeDrop, "busy-1": entity.ConsumeRetry}
+		actions sync.Map
+	)
+	for token := range cases {
+		wait.Add(1)
+		go func(token string) {
+			defer wait.Done()
+			var msg = entity.NewQueueMessage("push", []byte(`{"token":"`+token+`","data":{"k":"v"}}`))
+			action, _ := consumer.Handle(msg)
+			actions.Store(token, action)
+		}(token)
+	}
+	wait.Wait()
+	for token, expect := range cases {
+		if action, _ := actions.Load(token); action != expect {
+			t.Errorf("token %s: expect %s, got %v", token, expect, action)
+		}
+	}
+	// 并发提交时批次数取决于调度 [无其他消息在途即提交]
+	if n := atomic.LoadInt32(&batches); n < 1 || n > int32(len(cases)) {
+		t.Fatalf("expect 1..%d batch requests, got %d", len(cases), n)
+	}
+	if cleanup.queue != "fcm_invalid_tokens" || !strings.Contains(string(cleanup.pushed.Body), `"token":"gone-1"`) {
+		t.Fatalf("expect invalid token published, got %s %+v", cleanup.queue, cleanup.pushed)
+	}
+	if _, err = consumer.Handle(entity.NewQueueMessage("push", []byte(`{"data":{"k":"v"}}`))); err == nil {
+		t.Fatal("expect message without target dropped")
+	}
+}
+
+// 记录批次大小 首批阻塞至 release 关闭
+type fcmTestPusher struct {
+	locker  sync.Mutex
+	sizes   []int
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (pusher *fcmTestPusher) SendBatch(messages []*messaging.Message) (*messaging.BatchResponse, error) {
+	pusher.locker.Lock()
+	pusher.sizes = append(pusher.sizes, len(messages))
+	var first = len(pusher.sizes) == 1
+	pusher.locker.Unlock()
+	if first {
+		close(pusher.sending)
+		<-pusher.release
+	}
+	var resp = &messaging.BatchResponse{SuccessCount: len(messages)}
+	for range messages {
+		resp.Responses = append(resp.Responses, &messaging.SendResponse{Success: true})
+	}
+	return resp, nil
+}
+
+func TestFcmConsumerDomainImpl_Binding(t *testing.T) {
+	var (
+		pusher   = &fcmTestPusher{sending: make(chan struct{}), release: make(chan struct{})}
+		consumer = NewFcmConsumerDomain()
+		fanout   = repo.NewQueueFanout("push", new(proxyTestEntry))
+		replies  = make(chan entity.ConsumeAction, 3)
+	)
+	if err := consumer.Parse([]byte(`{"batch_size":3,"batch_wait":"5s"}`)); err != nil {
+		t.Fatal(err)
+	}
+	consumer.SetPusher(pusher)
+	defer consumer.Close()
+	defer fanout.Close()
+	// 队列并发数 1, 绑定并发数随批次大小放大
+	binding, err := CreateBinding(entity.QueueParams{Name: "push", ConsumerMaxNum: 1}, "fcm", "", consumer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fanout.Bind(binding); err != nil {
+		t.Fatal(err)
+	}
+	if n := fanout.Prefetch(); n < 3 {
+		t.Fatalf("expect prefetch >= batch_size, got %d", n)
+	}
+	var (
+		once    sync.Once
+		release = func() { once.Do(func() { close(pusher.release) }) }
+		start   = time.Now()
+		reply   = func(action entity.ConsumeAction) error {
+			replies <- action
+			return nil
+		}
+	)
+	defer release()
+	// 首条无其他消息在途 立即发送, 发送期间到达的消息聚合为下一批
+	fanout.Dispatch(entity.NewQueueMessage("push", []byte(`{"token":"ok-0"}`)), reply)
+	select {
+	case <-pusher.sending:
+	case <-time.After(time.Second):
+		t.Fatal("expect first message sent without waiting batch_wait")
+	}
+	for i := 1; i < 3; i++ {
+		fanout.Dispatch(entity.NewQueueMessage("push", []byte(fmt.Sprintf(`{"token":"ok-%d"}`, i))), reply)
+	}
+	for deadline := time.Now().Add(time.Second); len(consumer.pending) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 2 pending messages, got %d", len(consumer.pending))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	for i := 0; i < 3; i++ {
+		select {
+		case action := <-replies:
+			if action != entity.ConsumeAck {
+				t.Fatalf("expect ack, got %s", action)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expect replies before batch_wait")
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expect flush without batch_wait, took %s", elapsed)
+	}
+	pusher.locker.Lock()
+	defer pusher.locker.Unlock()
+	if !reflect.DeepEqual(pusher.sizes, []int{1, 2}) {
+		t.Fatalf("expect batches [1 2], got %v", pusher.sizes)
+	}
+}
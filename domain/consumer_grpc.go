@@ -0,0 +1,215 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GrpcConsumerDomainImpl gRPC 消费器 [每条消息调用一次一元方法, json 消息体按服务反射|描述符集编码]
+type GrpcConsumerDomainImpl struct {
+	locker     sync.Mutex
+	params     entity.KvMap
+	target     string
+	service    string
+	method     string
+	timeout    time.Duration
+	secure     bool
+	metadata   entity.KvMap
+	retryCodes map[codes.Code]bool
+	input      protoreflect.MessageDescriptor
+	files      *protoregistry.Files
+	conn       *grpc.ClientConn
+}
+
+const (
+	ParamGrpcTarget        = "target"
+	ParamGrpcMethod        = "method"
+	ParamGrpcDescriptorSet = "descriptor_set"
+	ParamGrpcTimeout       = "timeout"
+	ParamGrpcTls           = "tls"
+	ParamGrpcMetadata      = "metadata"
+	ParamGrpcRetryCodes    = "retry_codes"
+	defaultGrpcTimeout     = 5 * time.Second
+)
+
+var (
+	// 默认重试状态码 [其余失败状态丢弃, 配置死信时进入死信队列]
+	defaultGrpcRetryCodes = map[codes.Code]bool{
+		codes.Canceled:          true,
+		codes.Unknown:           true,
+		codes.DeadlineExceeded:  true,
+		codes.ResourceExhausted: true,
+		codes.Aborted:           true,
+		codes.Internal:          true,
+		codes.Unavailable:       true,
+	}
+	grpcMetadataKey = regexp.MustCompile(`^[0-9a-z_.-]+$`)
+)
+
+func NewGrpcConsumerDomain() *GrpcConsumerDomainImpl {
+	var domain = new(GrpcConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *GrpcConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.timeout = defaultGrpcTimeout
+	domain.metadata = entity.KvMap{}
+	domain.retryCodes = defaultGrpcRetryCodes
+}
+
+func (domain *GrpcConsumerDomainImpl) Type() string {
+	return entity.ConsumerGrpc
+}
+
+// Parse 解析配置
+// {"target":"127.0.0.1:9090","method":"pkg.Service/Method","descriptor_set":"./etc/service.pb","timeout":"5s","tls":false,
+// "metadata":{"x-app":"queue"},"retry_codes":["UNAVAILABLE","DEADLINE_EXCEEDED"]}
+func (domain *GrpcConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.target = domain.params.GetStr(ParamGrpcTarget)
+	if domain.target == "" {
+		return errors.New("miss param: " + ParamGrpcTarget)
+	}
+	var method = strings.TrimPrefix(domain.params.GetStr(ParamGrpcMethod), "/")
+	var index = strings.LastIndex(method, "/")
+	if index <= 0 || index == len(method)-1 {
+		return errors.New("invalid param: " + ParamGrpcMethod + ", expect pkg.Service/Method")
+	}
+	domain.service, domain.method = method[:index], method[index+1:]
+	domain.timeout = domain.params.GetDuration(ParamGrpcTimeout, defaultGrpcTimeout)
+	domain.secure = domain.params.GetBool(ParamGrpcTls)
+	domain.metadata = domain.params.GetKvMap(ParamGrpcMetadata, entity.KvMap{})
+	if names := domain.params.GetArr(ParamGrpcRetryCodes); len(names) > 0 {
+		domain.retryCodes = make(map[codes.Code]bool)
+		for _, name := range names {
+			var code codes.Code
+			if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+				return err
+			}
+			domain.retryCodes[code] = true
+		}
+	}
+	// 描述符集在本地 解析时校验方法
+	if file := domain.params.GetStr(ParamGrpcDescriptorSet); file != "" {
+		files, err := repo.LoadGrpcDescriptorSet(file)
+		if err != nil {
+			return err
+		}
+		desc, err := repo.GrpcFindMethod(files, domain.service, domain.method)
+		if err != nil {
+			return err
+		}
+		domain.input, domain.files = desc.Input(), files
+	}
+	conn, err := repo.GetGrpcConnRepo().Get(domain.target, domain.secure)
+	if err != nil {
+		return err
+	}
+	domain.conn = conn
+	return nil
+}
+
+// Handle 调用方法 [成功:确认, 临时错误状态:重试, 其他状态:丢弃]
+func (domain *GrpcConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	return domain.HandleContext(msg.Context(), msg)
+}
+
+// HandleContext 调用方法 [ctx 取消时中断调用]
+func (domain *GrpcConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	input, files, err := domain.resolve()
+	if err != nil {
+		return entity.ConsumeRetry, err
+	}
+	payload, err := repo.GrpcEncodeJson(input, msg.Body, files)
+	if err != nil {
+		return entity.ConsumeDrop, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, domain.timeout)
+	defer cancel()
+	var reply []byte
+	ctx = metadata.NewOutgoingContext(ctx, domain.metadataOf(msg))
+	err = domain.conn.Invoke(ctx, domain.FullMethod(), payload, &reply, grpc.ForceCodec(repo.GrpcRawCodec))
+	return domain.actionOf(err), err
+}
+
+// FullMethod 调用路径 /pkg.Service/Method
+func (domain *GrpcConsumerDomainImpl) FullMethod() string {
+	return fmt.Sprintf("/%s/%s", domain.service, domain.method)
+}
+
+// 请求消息描述及其描述文件 [未配置描述符集时 首次调用通过服务反射获取]
+func (domain *GrpcConsumerDomainImpl) resolve() (protoreflect.MessageDescriptor, *protoregistry.Files, error) {
+	domain.locker.Lock()
+	defer domain.locker.Unlock()
+	if domain.input != nil {
+		return domain.input, domain.files, nil
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), domain.timeout)
+	defer cancel()
+	files, err := repo.GrpcReflect(ctx, domain.conn, domain.service)
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, err := repo.GrpcFindMethod(files, domain.service, domain.method)
+	if err != nil {
+		return nil, nil, err
+	}
+	domain.input, domain.files = desc.Input(), files
+	return domain.input, domain.files, nil
+}
+
+// 调用元数据 [消息头 + 队列信息头 + 配置 metadata, 跳过 grpc 保留及非法键]
+func (domain *GrpcConsumerDomainImpl) metadataOf(msg *entity.QueueMessage) metadata.MD {
+	var md = metadata.MD{}
+	var set = func(k string, v interface{}) {
+		var key = strings.ToLower(k)
+		if strings.HasPrefix(key, "grpc-") || !grpcMetadataKey.MatchString(key) {
+			return
+		}
+		md.Set(key, fmt.Sprintf("%v", v))
+	}
+	for k, v := range msg.Headers {
+		set(k, v)
+	}
+	for k, v := range messageHeaders(msg) {
+		set(k, v)
+	}
+	for k, v := range domain.metadata {
+		set(k, v)
+	}
+	return md
+}
+
+// 状态码映射消费结果
+func (domain *GrpcConsumerDomainImpl) actionOf(err error) entity.ConsumeAction {
+	var code = status.Code(err)
+	switch {
+	case code == codes.OK:
+		return entity.ConsumeAck
+	case domain.retryCodes[code]:
+		return entity.ConsumeRetry
+	}
+	return entity.ConsumeDrop
+}
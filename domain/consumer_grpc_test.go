@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// queue.test.Worker/Run(Job{id, code, at}) returns google.protobuf.Empty
+var grpcTestFile = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("queue/worker.proto"),
+	Package:    proto.String("queue.test"),
+	Syntax:     proto.String("proto3"),
+	Dependency: []string{"google/protobuf/empty.proto", "google/protobuf/timestamp.proto"},
+	MessageType: []*descriptorpb.DescriptorProto{{
+		Name: proto.String("Job"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1),
+				Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			{Name: proto.String("code"), JsonName: proto.String("code"), Number: proto.Int32(2),
+				Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			{Name: proto.String("at"), JsonName: proto.String("at"), Number: proto.Int32(3), TypeName: proto.String(".google.protobuf.Timestamp"),
+				Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+		},
+	}},
+	Service: []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("Worker"),
+		Method: []*descriptorpb.MethodDescriptorProto{
+			{Name: proto.String("Run"), InputType: proto.String(".queue.test.Job"), OutputType: proto.String(".google.protobuf.Empty")},
+		},
+	}},
+}
+
+// 注册测试描述文件 [服务反射按 ServiceDesc.Metadata 文件名从全局注册表查找]
+func grpcTestJob(t *testing.T) protoreflect.MessageDescriptor {
+	var desc, err = protoregistry.GlobalFiles.FindDescriptorByName("queue.test.Job")
+	if err == nil {
+		return desc.(protoreflect.MessageDescriptor)
+	}
+	file, err := protodesc.NewFile(grpcTestFile, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = protoregistry.GlobalFiles.RegisterFile(file); err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().ByName("Job")
+}
+
+// Job.code 非 0 时返回对应状态码, 缺少元数据或时间时返回 FailedPrecondition
+func grpcTestService(job protoreflect.MessageDescriptor) *grpc.ServiceDesc {
+	var run = func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		var request = dynamicpb.NewMessage(job)
+		if err := dec(request); err != nil {
+			return nil, err
+		}
+		var md, _ = metadata.FromIncomingContext(ctx)
+		for k, v := range map[string]string{HeaderQueueName: "jobs", "x-app": "queue", "region": "eu"} {
+			if values := md.Get(k); len(values) == 0 || values[0] != v {
+				return nil, status.Error(codes.FailedPrecondition, "miss metadata "+k)
+			}
+		}
+		var at = request.Get(job.Fields().ByName("at")).Message().Interface()
+		if seconds := at.ProtoReflect().Get(at.ProtoReflect().Descriptor().Fields().ByName("seconds")).Int(); seconds != 1609459200 {
+			return nil, status.Errorf(codes.FailedPrecondition, "unexpected at %d", seconds)
+		}
+		if code := request.Get(job.Fields().ByName("code")).Int(); code != 0 {
+			return nil, status.Error(codes.Code(code), "failed")
+		}
+		return new(emptypb.Empty), nil
+	}
+	return &grpc.ServiceDesc{
+		ServiceName: "queue.test.Worker",
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Run", Handler: run}},
+		Metadata:    "queue/worker.proto",
+	}
+}
+
+func TestGrpcConsumerDomainImpl_Handle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server = grpc.NewServer()
+	server.RegisterService(grpcTestService(grpcTestJob(t)), new(struct{}))
+	reflection.Register(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	// 描述符集文件 [包含依赖]
+	var set = new(descriptorpb.FileDescriptorSet)
+	for _, name := range append(grpcTestFile.Dependency, grpcTestFile.GetName()) {
+		file, err := protoregistry.GlobalFiles.FindFileByPath(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+	}
+	data, _ := proto.Marshal(set)
+	var descriptorSet = filepath.Join(t.TempDir(), "worker.pb")
+	if err = ioutil.WriteFile(descriptorSet, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, extra := range []string{"", fmt.Sprintf(`,"descriptor_set":%q`, descriptorSet)} {
+		var consumer = NewGrpcConsumerDomain()
+		var properties = fmt.Sprintf(`{"target":"%s","method":"queue.test.Worker/Run","timeout":"2s","metadata":{"x-app":"queue"}%s}`, listener.Addr(), extra)
+		if err = consumer.Parse([]byte(properties)); err != nil {
+			t.Fatal(err)
+		}
+		var cases = map[string]entity.ConsumeAction{
+			`{"id":"a","code":0,"at":"2021-01-01T00:00:00Z"}`:  entity.ConsumeAck,
+			`{"id":"b","code":14,"at":"2021-01-01T00:00:00Z"}`: entity.ConsumeRetry,
+			`{"id":"c","code":3,"at":"2021-01-01T00:00:00Z"}`:  entity.ConsumeDrop,
+			`{"id":"d","at":{"seconds":1}}`:                    entity.ConsumeDrop,
+			`{"id":"e","bad":1}`:                               entity.ConsumeDrop,
+		}
+		for body, expect := range cases {
+			var msg = entity.NewQueueMessage("jobs", []byte(body), entity.KvMap{"region": "eu"})
+			if action, err := consumer.Handle(msg); action != expect {
+				t.Errorf("%s %s: expect %s, got %s %v", extra, body, expect, action, err)
+			}
+		}
+	}
+}
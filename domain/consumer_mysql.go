@@ -0,0 +1,436 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xorm.io/xorm"
+)
+
+type (
+	// MysqlConsumerDomainImpl 数据库写入消费器 [消息按 json 路径映射字段, 批量事务写入]
+	MysqlConsumerDomainImpl struct {
+		locker      sync.Mutex
+		safe        sync.RWMutex
+		params      entity.KvMap
+		connection  string
+		table       string
+		columns     []string
+		paths       []string
+		onDuplicate string
+		updates     []string
+		batchSize   int
+		batchWait   time.Duration
+		pending     chan *mysqlRow
+		stop        chan struct{}
+		started     bool
+		incoming    int32 // 已提交未入批次的消息数
+		engine      xorm.EngineInterface
+	}
+
+	// 待写入行
+	mysqlRow struct {
+		values []interface{}
+		result chan error
+	}
+)
+
+const (
+	ParamMysqlConnection  = "connection"
+	ParamMysqlTable       = "table"
+	ParamMysqlColumns     = "columns"
+	ParamMysqlOnDuplicate = "on_duplicate"
+	ParamMysqlUpdates     = "update_columns"
+	ParamMysqlBatchSize   = "batch_size"
+	ParamMysqlBatchWait   = "batch_wait"
+	// DuplicateError 主键冲突时报错 [消息丢弃]
+	DuplicateError = "error"
+	// DuplicateIgnore 主键冲突时忽略 INSERT IGNORE
+	DuplicateIgnore = "ignore"
+	// DuplicateUpdate 主键冲突时更新 ON DUPLICATE KEY UPDATE
+	DuplicateUpdate       = "update"
+	defaultMysqlBatchWait = 100 * time.Millisecond
+	maxMysqlBatchSize     = 1000
+	// 消息元信息路径
+	mysqlPathBody     = "$"
+	mysqlPathQueue    = "@queue"
+	mysqlPathID       = "@id"
+	mysqlPathAttempts = "@attempts"
+	mysqlPathTime     = "@timestamp"
+)
+
+var (
+	ErrorMysqlConsumerClosed = errors.New("mysql consumer closed")
+	mysqlIdentifier          = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	// 数据类错误 重试无效 [主键冲突, 字段超长, 类型错误, 非空字段为空 ...]
+	mysqlDataErrors = map[uint16]bool{
+		1048: true, 1062: true, 1264: true, 1265: true, 1292: true,
+		1366: true, 1406: true, 1452: true, 3140: true,
+	}
+)
+
+func NewMysqlConsumerDomain() *MysqlConsumerDomainImpl {
+	var domain = new(MysqlConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *MysqlConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.onDuplicate = DuplicateError
+	domain.batchSize = 1
+	domain.batchWait = defaultMysqlBatchWait
+	domain.stop = make(chan struct{})
+}
+
+func (domain *MysqlConsumerDomainImpl) Type() string {
+	return entity.ConsumerMysql
+}
+
+// BatchSize 单批最大消息数 [绑定并发数不低于此值]
+func (domain *MysqlConsumerDomainImpl) BatchSize() int {
+	return domain.batchSize
+}
+
+// Parse 解析配置
+// {"connection":"data","table":"events","columns":{"user_id":"user.id","event":"type","payload":"$","msg_id":"@id"},
+// "on_duplicate":"error|ignore|update","update_columns":["payload"],"batch_size":100,"batch_wait":"100ms"}
+func (domain *MysqlConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.connection = domain.params.GetStr(ParamMysqlConnection)
+	domain.table = domain.params.GetStr(ParamMysqlTable)
+	if domain.table == "" {
+		return errors.New("miss param: " + ParamMysqlTable)
+	}
+	if !mysqlIdentifier.MatchString(domain.table) {
+		return errors.New("invalid table name: " + domain.table)
+	}
+	var columns = domain.params.GetKvMap(ParamMysqlColumns)
+	if len(columns) == 0 {
+		return errors.New("miss param: " + ParamMysqlColumns)
+	}
+	// 字段排序 保证语句稳定
+	domain.columns = columns.Keys()
+	sort.Strings(domain.columns)
+	for _, column := range domain.columns {
+		if !mysqlIdentifier.MatchString(column) || strings.Contains(column, ".") {
+			return errors.New("invalid column name: " + column)
+		}
+		// 未指定路径时 取同名字段
+		domain.paths = append(domain.paths, columns.GetStr(column, column))
+	}
+	domain.onDuplicate = strings.ToLower(domain.params.GetStr(ParamMysqlOnDuplicate, DuplicateError))
+	switch domain.onDuplicate {
+	case DuplicateError, DuplicateIgnore:
+	case DuplicateUpdate:
+		domain.updates = domain.params.GetArr(ParamMysqlUpdates, domain.columns)
+		for _, column := range domain.updates {
+			if _, ok := columns[column]; !ok {
+				return errors.New("unknown update column: " + column)
+			}
+		}
+	default:
+		return errors.New("unknown on_duplicate: " + domain.onDuplicate)
+	}
+	domain.batchSize = domain.params.GetInt(ParamMysqlBatchSize, 1)
+	if domain.batchSize <= 0 || domain.batchSize > maxMysqlBatchSize {
+		return fmt.Errorf("batch_size must be in 1..%d", maxMysqlBatchSize)
+	}
+	domain.batchWait = domain.params.GetDuration(ParamMysqlBatchWait, defaultMysqlBatchWait)
+	domain.pending = make(chan *mysqlRow, domain.batchSize)
+	return nil
+}
+
+// SetEngine 指定数据库连接 [默认按 connection 从数据库库获取]
+func (domain *MysqlConsumerDomainImpl) SetEngine(engine xorm.EngineInterface) *MysqlConsumerDomainImpl {
+	domain.engine = engine
+	return domain
+}
+
+// Handle 写入消息 [等待所在批次提交, 数据错误丢弃, 连接类错误重试]
+func (domain *MysqlConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	values, err := domain.Values(msg)
+	if err != nil {
+		return entity.ConsumeDrop, err
+	}
+	if err = domain.start(); err != nil {
+		return entity.ConsumeRetry, err
+	}
+	var row = &mysqlRow{values: values, result: make(chan error, 1)}
+	if !domain.enqueue(row) {
+		return entity.ConsumeRetry, ErrorMysqlConsumerClosed
+	}
+	if err = <-row.result; err != nil {
+		return domain.actionOf(err), err
+	}
+	return entity.ConsumeAck, nil
+}
+
+// Close 停止批量写入 [写入中的批次提交, 未写入的消息重试]
+func (domain *MysqlConsumerDomainImpl) Close() {
+	domain.safe.Lock()
+	defer domain.safe.Unlock()
+	select {
+	case <-domain.stop:
+	default:
+		close(domain.stop)
+	}
+}
+
+// 加入待写入批次 [已关闭时返回 false]
+func (domain *MysqlConsumerDomainImpl) enqueue(row *mysqlRow) bool {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
+	select {
+	case <-domain.stop:
+		return false
+	default:
+	}
+	atomic.AddInt32(&domain.incoming, 1)
+	domain.pending <- row
+	return true
+}
+
+// Values 按字段映射提取消息值
+func (domain *MysqlConsumerDomainImpl) Values(msg *entity.QueueMessage) ([]interface{}, error) {
+	var (
+		payload interface{}
+		values  = make([]interface{}, 0, len(domain.paths))
+	)
+	for _, path := range domain.paths {
+		switch path {
+		case mysqlPathBody:
+			values = append(values, string(msg.Body))
+			continue
+		case mysqlPathQueue:
+			values = append(values, msg.Queue)
+			continue
+		case mysqlPathID:
+			values = append(values, msg.ID)
+			continue
+		case mysqlPathAttempts:
+			values = append(values, msg.Attempts)
+			continue
+		case mysqlPathTime:
+			values = append(values, msg.Timestamp)
+			continue
+		}
+		if payload == nil {
+			var decoder = json.NewDecoder(bytes.NewReader(msg.Body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&payload); err != nil {
+				return nil, err
+			}
+		}
+		value, err := jsonPathValue(payload, path)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// 启动批量写入协程
+func (domain *MysqlConsumerDomainImpl) start() (err error) {
+	domain.locker.Lock()
+	defer domain.locker.Unlock()
+	if domain.started {
+		return nil
+	}
+	if domain.engine == nil {
+		// 连接创建失败时 GetDb panic
+		defer func() {
+			if e := recover(); e != nil {
+				err = fmt.Errorf("mysql connection %s: %v", domain.connection, e)
+			}
+		}()
+		if domain.connection == "" {
+			domain.engine = repo.GetDatabaseRepository().GetDb()
+		} else {
+			domain.engine = repo.GetDatabaseRepository().GetDb(domain.connection)
+		}
+	}
+	domain.started = true
+	go domain.loop()
+	return nil
+}
+
+// 按 batch_size|batch_wait 聚合批次 [无其他消息在途时提前提交]
+func (domain *MysqlConsumerDomainImpl) loop() {
+	var batch = make([]*mysqlRow, 0, domain.batchSize)
+	for {
+		select {
+		case <-domain.stop:
+			domain.drain()
+			return
+		case row := <-domain.pending:
+			atomic.AddInt32(&domain.incoming, -1)
+			batch = append(batch[:0], row)
+		}
+		var timer = time.NewTimer(domain.batchWait)
+	collect:
+		// 无其他消息在途时立即提交 不等待 batch_wait
+		for len(batch) < domain.batchSize && atomic.LoadInt32(&domain.incoming) > 0 {
+			select {
+			case row := <-domain.pending:
+				atomic.AddInt32(&domain.incoming, -1)
+				batch = append(batch, row)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		domain.flush(batch)
+	}
+}
+
+// 关闭后 未写入的消息返回错误
+func (domain *MysqlConsumerDomainImpl) drain() {
+	for {
+		select {
+		case row := <-domain.pending:
+			atomic.AddInt32(&domain.incoming, -1)
+			row.result <- ErrorMysqlConsumerClosed
+		default:
+			return
+		}
+	}
+}
+
+// 批次事务写入 [批次失败时逐行写入 区分每条消息结果]
+func (domain *MysqlConsumerDomainImpl) flush(batch []*mysqlRow) {
+	var rows = make([][]interface{}, 0, len(batch))
+	for _, row := range batch {
+		rows = append(rows, row.values)
+	}
+	var err = domain.exec(rows)
+	if err == nil || len(batch) == 1 {
+		for _, row := range batch {
+			row.result <- err
+		}
+		return
+	}
+	for _, row := range batch {
+		row.result <- domain.exec([][]interface{}{row.values})
+	}
+}
+
+func (domain *MysqlConsumerDomainImpl) exec(rows [][]interface{}) error {
+	var session = domain.engine.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	var query, args = domain.BuildInsert(rows)
+	if _, err := session.Exec(append([]interface{}{query}, args...)...); err != nil {
+		_ = session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
+
+// BuildInsert 生成批量写入语句
+func (domain *MysqlConsumerDomainImpl) BuildInsert(rows [][]interface{}) (string, []interface{}) {
+	var (
+		buf    = bytes.NewBufferString("INSERT ")
+		args   = make([]interface{}, 0, len(rows)*len(domain.columns))
+		holder = "(" + strings.TrimSuffix(strings.Repeat("?,", len(domain.columns)), ",") + ")"
+	)
+	if domain.onDuplicate == DuplicateIgnore {
+		buf.WriteString("IGNORE ")
+	}
+	buf.WriteString("INTO " + quoteMysql(domain.table) + " (")
+	for i, column := range domain.columns {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(quoteMysql(column))
+	}
+	buf.WriteString(") VALUES ")
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(holder)
+		args = append(args, row...)
+	}
+	if domain.onDuplicate == DuplicateUpdate && len(domain.updates) > 0 {
+		buf.WriteString(" ON DUPLICATE KEY UPDATE ")
+		for i, column := range domain.updates {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString(quoteMysql(column) + "=VALUES(" + quoteMysql(column) + ")")
+		}
+	}
+	return buf.String(), args
+}
+
+// 写入错误转换处理动作 [数据类错误丢弃, 其他重试]
+func (domain *MysqlConsumerDomainImpl) actionOf(err error) entity.ConsumeAction {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlDataErrors[mysqlErr.Number] {
+		return entity.ConsumeDrop
+	}
+	return entity.ConsumeRetry
+}
+
+// 标识符引用 [已校验仅包含字母数字下划线]
+func quoteMysql(name string) string {
+	return "`" + strings.Replace(name, ".", "`.`", 1) + "`"
+}
+
+// json 路径取值 [a.b.0.c, 缺失为 NULL, 对象|数组 序列化为 json]
+func jsonPathValue(payload interface{}, path string) (interface{}, error) {
+	var value = payload
+	for _, key := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				value = nil
+				break
+			}
+			value = node[index]
+		default:
+			value = nil
+		}
+		if value == nil {
+			return nil, nil
+		}
+	}
+	switch node := value.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(node)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	case json.Number:
+		if n, err := node.Int64(); err == nil {
+			return n, nil
+		}
+		return node.String(), nil
+	}
+	return value, nil
+}
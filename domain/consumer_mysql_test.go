@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"reflect"
+	"testing"
+)
+
+func TestMysqlConsumerDomainImpl_BuildInsert(t *testing.T) {
+	var consumer = NewMysqlConsumerDomain()
+	var properties = `{"table":"app.events","columns":{"user_id":"user.id","tags":"tags","first":"items.0.name","queue":"@queue","missing":"a.b"},
+		"on_duplicate":"update","update_columns":["tags"],"batch_size":10}`
+	if err := consumer.Parse([]byte(properties)); err != nil {
+		t.Fatal(err)
+	}
+	values, err := consumer.Values(entity.NewQueueMessage("events", []byte(`{"user":{"id":12345678901},"tags":["a","b"],"items":[{"name":"x"}]}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 字段按名称排序: first, missing, queue, tags, user_id
+	var expect = []interface{}{"x", nil, "events", `["a","b"]`, int64(12345678901)}
+	if !reflect.DeepEqual(values, expect) {
+		t.Fatalf("expect %v, got %v", expect, values)
+	}
+	query, args := consumer.BuildInsert([][]interface{}{values, values})
+	if query != "INSERT INTO `app`.`events` (`first`,`missing`,`queue`,`tags`,`user_id`) VALUES (?,?,?,?,?),(?,?,?,?,?) ON DUPLICATE KEY UPDATE `tags`=VALUES(`tags`)" {
+		t.Fatalf("unexpected sql: %s", query)
+	}
+	if len(args) != 10 {
+		t.Fatalf("expect 10 args, got %d", len(args))
+	}
+	if err = NewMysqlConsumerDomain().Parse([]byte(`{"table":"events; drop table x","columns":{"a":"a"}}`)); err == nil {
+		t.Fatal("expect invalid table name error")
+	}
+}
@@ -0,0 +1,361 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// PipelineConsumerDomainImpl 流水线消费器 [按顺序执行多个消费器, 上一步输出作为下一步输入]
+	PipelineConsumerDomainImpl struct {
+		params entity.KvMap
+		name   string
+		steps  []*pipelineStep
+		entry  facede.QueueEntry
+	}
+
+	// 流水线步骤
+	pipelineStep struct {
+		name       string
+		consumer   facede.Consumer
+		attempts   int
+		retryWait  time.Duration
+		onFailure  string
+		maxRetries int
+		keepInput  bool
+	}
+
+	// 步骤配置
+	pipelineStepConfig struct {
+		Name       string          `json:"name"`
+		Consumer   string          `json:"consumer"`
+		Properties json.RawMessage `json:"properties"`
+		Attempts   int             `json:"attempts"`
+		RetryWait  string          `json:"retry_wait"`
+		OnFailure  string          `json:"on_failure"`
+		MaxRetries int             `json:"max_retries"`
+		KeepInput  bool            `json:"keep_input"`
+	}
+)
+
+const (
+	ParamPipelineName      = "name"
+	ParamPipelineSteps     = "steps"
+	ParamPipelineDriver    = "driver"
+	ParamPipelineNamespace = "namespace"
+	// PipelineRetry 从失败步骤重试 [中间结果重新入队, 未配置队列时整体重试]
+	PipelineRetry = "retry"
+	// PipelineRestart 整体重试 [消息重新入队 从第一步开始]
+	PipelineRestart = "restart"
+	// PipelineDrop 丢弃消息 [配置死信时进入死信队列]
+	PipelineDrop = "drop"
+	// PipelineSkip 跳过失败步骤 继续执行
+	PipelineSkip = "skip"
+	// PipelineAck 终止流水线并确认
+	PipelineAck = "ack"
+	// HeaderPipelineName 流水线名称
+	HeaderPipelineName = "x-pipeline-name"
+	// HeaderPipelineStep 重试起始步骤
+	HeaderPipelineStep = "x-pipeline-step"
+	// HeaderPipelineRetries 步骤重试次数
+	HeaderPipelineRetries     = "x-pipeline-retries"
+	defaultPipelineName       = "pipeline"
+	defaultPipelineMaxRetries = 5
+)
+
+func NewPipelineConsumerDomain() *PipelineConsumerDomainImpl {
+	var domain = new(PipelineConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *PipelineConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.name = defaultPipelineName
+}
+
+func (domain *PipelineConsumerDomainImpl) Type() string {
+	return entity.ConsumerPipeline
+}
+
+// Parse 解析配置
+// {"name":"orders","driver":"AMQP","namespace":"","steps":[{"name":"enrich","consumer":"Plugins","properties":{"script":"enrich.lua"},
+// "attempts":1,"retry_wait":"1s","on_failure":"retry|restart|drop|skip|ack","max_retries":5,"keep_input":false}]}
+func (domain *PipelineConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	var config struct {
+		Steps []pipelineStepConfig `json:"steps"`
+	}
+	if err := utils.JsonDecode(properties, &config); err != nil {
+		return err
+	}
+	if len(config.Steps) == 0 {
+		return errors.New("miss param: " + ParamPipelineSteps)
+	}
+	domain.name = domain.params.GetStr(ParamPipelineName, defaultPipelineName)
+	domain.steps = domain.steps[:0]
+	for i, cfg := range config.Steps {
+		step, err := domain.createStep(i, cfg)
+		if err != nil {
+			return fmt.Errorf("pipeline step %d: %v", i, err)
+		}
+		domain.steps = append(domain.steps, step)
+	}
+	var driver = strings.ToUpper(domain.params.GetStr(ParamPipelineDriver, repo.DriverAmqp))
+	entry, err := repo.GetQueueDriverRepo().Get(driver, domain.params.GetStr(ParamPipelineNamespace))
+	if err != nil {
+		return err
+	}
+	domain.entry = entry
+	return nil
+}
+
+// 创建步骤消费器
+func (domain *PipelineConsumerDomainImpl) createStep(index int, cfg pipelineStepConfig) (*pipelineStep, error) {
+	if cfg.Consumer == "" {
+		return nil, errors.New("miss param: consumer")
+	}
+	var properties = []byte(cfg.Properties)
+	// 配置为 json 字符串
+	if len(properties) > 0 && properties[0] == '"' {
+		var text string
+		if err := json.Unmarshal(properties, &text); err != nil {
+			return nil, err
+		}
+		properties = []byte(text)
+	}
+	consumer, err := GetConsumerDomain().Create(cfg.Consumer, properties)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.Consumer, err)
+	}
+	var step = &pipelineStep{
+		name:       cfg.Name,
+		consumer:   consumer,
+		attempts:   cfg.Attempts,
+		onFailure:  strings.ToLower(cfg.OnFailure),
+		maxRetries: cfg.MaxRetries,
+		keepInput:  cfg.KeepInput,
+	}
+	if step.name == "" {
+		step.name = fmt.Sprintf("%d.%s", index, strings.ToLower(cfg.Consumer))
+	}
+	if step.attempts <= 0 {
+		step.attempts = 1
+	}
+	if step.maxRetries <= 0 {
+		step.maxRetries = defaultPipelineMaxRetries
+	}
+	if cfg.RetryWait != "" {
+		if step.retryWait, err = time.ParseDuration(cfg.RetryWait); err != nil {
+			return nil, err
+		}
+	}
+	switch step.onFailure {
+	case "":
+		step.onFailure = PipelineRetry
+	case PipelineRetry, PipelineRestart, PipelineDrop, PipelineSkip, PipelineAck:
+	default:
+		return nil, errors.New("unknown on_failure: " + step.onFailure)
+	}
+	return step, nil
+}
+
+// SetEntry 指定步骤重试入队实例
+func (domain *PipelineConsumerDomainImpl) SetEntry(entry facede.QueueEntry) *PipelineConsumerDomainImpl {
+	domain.entry = entry
+	return domain
+}
+
+// Handle 执行流水线 [全部步骤成功:确认, 失败按步骤 on_failure 处理]
+func (domain *PipelineConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var _, action, err = domain.ProcessContext(msg.Context(), msg)
+	return action, err
+}
+
+// HandleContext 执行流水线 [ctx 传递给各步骤]
+func (domain *PipelineConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var _, action, err = domain.ProcessContext(ctx, msg)
+	return action, err
+}
+
+// Process 执行流水线 [最后一步输出作为输出消息]
+func (domain *PipelineConsumerDomainImpl) Process(msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	return domain.ProcessContext(msg.Context(), msg)
+}
+
+// ProcessContext 执行流水线 [超时或停止消费时不按 on_failure 处理, 从中断步骤重试]
+func (domain *PipelineConsumerDomainImpl) ProcessContext(ctx context.Context, msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	var current = msg
+	for i := domain.startOf(msg); i < len(domain.steps); i++ {
+		var (
+			step                = domain.steps[i]
+			output, action, err = domain.run(ctx, step, current)
+		)
+		if action == entity.ConsumeAck {
+			if output != nil && !step.keepInput {
+				current = output
+			}
+			continue
+		}
+		err = fmt.Errorf("pipeline step %s: %v", step.name, errorOf(err, action))
+		if ctx.Err() != nil {
+			err = entity.ContextError(ctx, err)
+		} else {
+			switch step.onFailure {
+			case PipelineSkip:
+				continue
+			case PipelineAck:
+				return current, entity.ConsumeAck, err
+			case PipelineDrop:
+				return current, entity.ConsumeDrop, err
+			}
+			// 步骤明确丢弃 重试无效
+			if action == entity.ConsumeDrop {
+				return current, entity.ConsumeDrop, err
+			}
+		}
+		if step.onFailure == PipelineRestart {
+			return current, entity.ConsumeRetry, err
+		}
+		action, err = domain.resume(ctx, msg, current, i, err)
+		return current, action, err
+	}
+	return current, entity.ConsumeAck, nil
+}
+
+// 执行步骤 [attempts 次内重试, 记录耗时及结果]
+func (domain *PipelineConsumerDomainImpl) run(ctx context.Context, step *pipelineStep, msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	var (
+		output *entity.QueueMessage
+		action entity.ConsumeAction
+		err    error
+	)
+	for attempt := 1; attempt <= step.attempts; attempt++ {
+		var start = time.Now()
+		output, action, err = domain.invoke(ctx, step, msg)
+		repo.GetPrometheusRepo().ObservePipelineStep(domain.name, step.name, action.String(), time.Since(start))
+		if action != entity.ConsumeRetry || attempt == step.attempts || ctx.Err() != nil {
+			break
+		}
+		if step.retryWait > 0 && !sleepContext(ctx, step.retryWait) {
+			break
+		}
+	}
+	return output, action, err
+}
+
+// 调用步骤消费器 [支持上下文时传递 ctx]
+func (domain *PipelineConsumerDomainImpl) invoke(ctx context.Context, step *pipelineStep, msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	switch processor := step.consumer.(type) {
+	case facede.ContextProcessor:
+		return processor.ProcessContext(ctx, msg)
+	case facede.Processor:
+		return processor.Process(msg.WithContext(ctx))
+	}
+	var action, err = repo.HandleContext(ctx, step.consumer, msg, 0)
+	return nil, action, err
+}
+
+// 从失败步骤重试 [中间结果带步骤信息延迟重新入队, 原消息确认]
+// 延迟自 retry_wait (默认 1s) 起按重试次数递增, 绑定分发时只投递给当前绑定
+func (domain *PipelineConsumerDomainImpl) resume(ctx context.Context, source, current *entity.QueueMessage, index int, err error) (entity.ConsumeAction, error) {
+	if domain.entry == nil {
+		return entity.ConsumeRetry, err
+	}
+	var (
+		step    = domain.steps[index]
+		retries = 0
+	)
+	if domain.startOf(source) == index {
+		retries = headerInt(source.Headers, HeaderPipelineRetries)
+	}
+	if retries >= step.maxRetries {
+		return entity.ConsumeDrop, fmt.Errorf("%v; retries exhausted %d", err, retries)
+	}
+	var forward = entity.NewQueueMessage(source.Queue, current.Body, current.Headers.Copy())
+	forward.ID = source.ID
+	forward.ContentType = current.ContentType
+	forward.Timestamp = source.Timestamp
+	forward.Headers[HeaderPipelineName] = domain.name
+	forward.Headers[HeaderPipelineStep] = index
+	forward.Headers[HeaderPipelineRetries] = retries + 1
+	if binding := repo.BindingOf(ctx); binding != "" {
+		forward.Headers[repo.HeaderFanoutTarget] = binding
+	}
+	var backoff = entity.NewRetryPolicy()
+	if step.retryWait > 0 {
+		backoff.InitialDelay = step.retryWait
+	}
+	if e := repo.DelayPush(domain.entry, forward, backoff.Delay(retries+1), source.Queue); e != nil {
+		return entity.ConsumeRetry, fmt.Errorf("%v; resume: %v", err, e)
+	}
+	return entity.ConsumeAck, err
+}
+
+// 起始步骤 [同名流水线重试消息从记录步骤开始]
+func (domain *PipelineConsumerDomainImpl) startOf(msg *entity.QueueMessage) int {
+	if msg.Headers.GetStr(HeaderPipelineName) != domain.name {
+		return 0
+	}
+	var index = headerInt(msg.Headers, HeaderPipelineStep)
+	if index < 0 || index >= len(domain.steps) {
+		return 0
+	}
+	return index
+}
+
+// Steps 步骤名称
+func (domain *PipelineConsumerDomainImpl) Steps() []string {
+	var names = make([]string, 0, len(domain.steps))
+	for _, step := range domain.steps {
+		names = append(names, step.name)
+	}
+	return names
+}
+
+// 整数消息头 [amqp 消息头数值类型不固定]
+func headerInt(headers entity.KvMap, key string) int {
+	var v, ok = headers[key]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(fmt.Sprintf("%v", v))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// 等待 d [ctx 取消时提前返回 false]
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	var timer = time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 失败原因 [步骤未返回错误时以处理动作描述]
+func errorOf(err error, action entity.ConsumeAction) error {
+	if err != nil {
+		return err
+	}
+	return errors.New("step result " + action.String())
+}
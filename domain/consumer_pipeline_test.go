@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"testing"
+	"time"
+)
+
+// 记录延迟投递
+type pipelineTestEntry struct {
+	proxyTestEntry
+	delay time.Duration
+}
+
+func (entry *pipelineTestEntry) PushDelay(msg *entity.QueueMessage, delay time.Duration, queue string) error {
+	entry.delay = delay
+	return entry.Push(msg, queue)
+}
+
+// 记录输入并按顺序返回处理结果
+type pipelineTestConsumer struct {
+	actions []entity.ConsumeAction
+	seen    []*entity.QueueMessage
+}
+
+func (consumer *pipelineTestConsumer) Type() string {
+	return "PipelineTest"
+}
+
+func (consumer *pipelineTestConsumer) Parse([]byte) error {
+	return nil
+}
+
+func (consumer *pipelineTestConsumer) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	consumer.seen = append(consumer.seen, msg)
+	var action = consumer.actions[0]
+	consumer.actions = consumer.actions[1:]
+	return action, nil
+}
+
+func TestPipelineConsumerDomainImpl_Handle(t *testing.T) {
+	var record = new(pipelineTestConsumer)
+	GetConsumerDomain().Register(record.Type(), func() facede.Consumer {
+		return record
+	})
+	var (
+		entry    = new(pipelineTestEntry)
+		pipeline = NewPipelineConsumerDomain()
+	)
+	var properties = `{"name":"orders","steps":[
+		{"name":"enrich","consumer":"Plugins","properties":{"source":"function handle(msg) msg.body = msg.body .. '+lua'; msg.headers['x-enriched'] = 'yes' end"}},
+		{"name":"record","consumer":"PipelineTest","properties":{},"max_retries":1,"retry_wait":"2s"}]}`
+	if err := pipeline.Parse([]byte(properties)); err != nil {
+		t.Fatal(err)
+	}
+	pipeline.SetEntry(entry)
+
+	// 上一步输出作为下一步输入
+	record.actions = []entity.ConsumeAction{entity.ConsumeAck, entity.ConsumeRetry, entity.ConsumeAck, entity.ConsumeRetry}
+	if action, err := pipeline.Handle(entity.NewQueueMessage("orders", []byte("x"))); action != entity.ConsumeAck || err != nil {
+		t.Fatalf("expect ack, got %s %v", action, err)
+	}
+	if seen := record.seen[0]; string(seen.Body) != "x+lua" || seen.Headers.GetStr("x-enriched") != "yes" {
+		t.Fatalf("expect enriched input, got %s %v", seen.Body, seen.Headers)
+	}
+
+	// 失败步骤的中间结果延迟重新入队 [只投递给当前绑定]
+	var (
+		fanout  = repo.NewQueueFanout("orders", entry)
+		replies = make(chan entity.ConsumeAction, 1)
+	)
+	defer fanout.Close()
+	binding, err := CreateBinding(entity.QueueParams{Name: "orders"}, "pipeline", "", pipeline, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fanout.Bind(binding); err != nil {
+		t.Fatal(err)
+	}
+	fanout.Dispatch(entity.NewQueueMessage("orders", []byte("y")), func(action entity.ConsumeAction) error {
+		replies <- action
+		return nil
+	})
+	select {
+	case action := <-replies:
+		if action != entity.ConsumeAck {
+			t.Fatalf("expect ack after resume published, got %s", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect fanout reply")
+	}
+	var resumed = entry.pushed
+	if entry.queue != "orders" || string(resumed.Body) != "y+lua" || resumed.Headers[HeaderPipelineStep] != 1 || resumed.Headers[HeaderPipelineRetries] != 1 {
+		t.Fatalf("unexpected resume %s %s %v", entry.queue, resumed.Body, resumed.Headers)
+	}
+	if resumed.Headers.GetStr(repo.HeaderFanoutTarget) != "pipeline" {
+		t.Fatalf("expect resume targeted to binding, got %v", resumed.Headers)
+	}
+	// 2s 起 20% 抖动
+	if entry.delay < 1600*time.Millisecond || entry.delay > 2400*time.Millisecond {
+		t.Fatalf("expect resume delay about 2s, got %s", entry.delay)
+	}
+	delete(resumed.Headers, repo.HeaderFanoutTarget)
+
+	// 从失败步骤继续 不重复执行已完成步骤
+	if action, _ := pipeline.Handle(resumed); action != entity.ConsumeAck || string(record.seen[2].Body) != "y+lua" {
+		t.Fatalf("expect resumed from record step, got %s %s", action, record.seen[2].Body)
+	}
+
+	// 超过重试次数丢弃
+	if action, _ := pipeline.Handle(resumed); action != entity.ConsumeDrop {
+		t.Fatalf("expect drop when retries exhausted, got %s", action)
+	}
+}
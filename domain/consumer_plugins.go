@@ -0,0 +1,231 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/plugins"
+	"github.com/weblfe/queue_mgr/utils"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginsConsumerDomainImpl lua 脚本消费器 [脚本定义 handle(msg) 返回 ack|retry|drop]
+type PluginsConsumerDomainImpl struct {
+	locker   sync.Mutex
+	params   entity.KvMap
+	script   string
+	function string
+	timeout  time.Duration
+	plugin   facede.LuaPlugin
+	handler  *lua.LFunction
+}
+
+const (
+	ParamPluginsScript   = "script"
+	ParamPluginsSource   = "source"
+	ParamPluginsFunction = "function"
+	ParamPluginsTimeout  = "timeout"
+	defaultPluginsFunc   = "handle"
+	defaultPluginsTime   = 30 * time.Second
+)
+
+func NewPluginsConsumerDomain() *PluginsConsumerDomainImpl {
+	var domain = new(PluginsConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *PluginsConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.function = defaultPluginsFunc
+	domain.timeout = defaultPluginsTime
+}
+
+func (domain *PluginsConsumerDomainImpl) Type() string {
+	return entity.ConsumerPlugins
+}
+
+// Parse 解析配置 并加载脚本
+// {"script":"/app/plugins/job.lua","function":"handle","timeout":"10s"} 或 {"source":"function handle(msg) return 'ack' end"}
+func (domain *PluginsConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.script = domain.params.GetStr(ParamPluginsScript)
+	var source = domain.params.GetStr(ParamPluginsSource)
+	if domain.script == "" && source == "" {
+		return errors.New("miss param: " + ParamPluginsScript)
+	}
+	domain.function = domain.params.GetStr(ParamPluginsFunction, defaultPluginsFunc)
+	domain.timeout = domain.params.GetDuration(ParamPluginsTimeout, defaultPluginsTime)
+
+	var plugin = plugins.NewLuaPlugin()
+	plugin.SetLoader(plugins.CreateExtendsLoader).Boot()
+	var (
+		chunk *lua.LFunction
+		err   error
+	)
+	if domain.script != "" {
+		chunk, err = plugin.LoadFile(domain.script)
+	} else {
+		chunk, err = plugin.LoadByIo(ioutil.NopCloser(strings.NewReader(source)), ParamPluginsSource)
+	}
+	if err != nil {
+		return err
+	}
+	var state = plugin.GetLState()
+	// 执行脚本主体 定义处理函数
+	if err = state.CallByParam(lua.P{Fn: chunk, NRet: 0, Protect: true}); err != nil {
+		return err
+	}
+	handler, ok := state.GetGlobal(domain.function).(*lua.LFunction)
+	if !ok {
+		return fmt.Errorf("lua function %s not defined", domain.function)
+	}
+	domain.plugin = plugin
+	domain.handler = handler
+	return nil
+}
+
+// Handle 调用脚本处理函数
+// [返回 nil|true|"ack":确认, false|"retry":重试, "drop":丢弃, 第二返回值为错误信息, 脚本异常|超时:重试]
+func (domain *PluginsConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var _, action, err = domain.ProcessContext(msg.Context(), msg)
+	return action, err
+}
+
+// HandleContext 调用脚本处理函数 [ctx 取消时中断脚本]
+func (domain *PluginsConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var _, action, err = domain.ProcessContext(ctx, msg)
+	return action, err
+}
+
+// Process 调用脚本处理函数 [脚本修改后的 msg.body|headers|content_type 作为输出消息]
+func (domain *PluginsConsumerDomainImpl) Process(msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	return domain.ProcessContext(msg.Context(), msg)
+}
+
+// ProcessContext 调用脚本处理函数 [ctx 取消时中断脚本]
+func (domain *PluginsConsumerDomainImpl) ProcessContext(ctx context.Context, msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	domain.locker.Lock()
+	defer domain.locker.Unlock()
+	var (
+		state = domain.plugin.GetLState()
+		table = domain.toTable(state, msg)
+	)
+	ctx, cancel := context.WithTimeout(ctx, domain.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	defer state.RemoveContext()
+	if err := state.CallByParam(lua.P{Fn: domain.handler, NRet: 2, Protect: true}, table); err != nil {
+		return nil, entity.ConsumeRetry, err
+	}
+	var (
+		ret    = state.Get(-2)
+		reason = state.Get(-1)
+	)
+	state.Pop(2)
+	var err error
+	if reason != lua.LNil {
+		err = errors.New(reason.String())
+	}
+	var output = domain.fromTable(table, msg)
+	switch ret.Type() {
+	case lua.LTNil:
+		return output, entity.ConsumeAck, err
+	case lua.LTBool:
+		if lua.LVAsBool(ret) {
+			return output, entity.ConsumeAck, err
+		}
+		return output, entity.ConsumeRetry, err
+	case lua.LTString:
+		switch strings.ToLower(ret.String()) {
+		case entity.ConsumeAck.String():
+			return output, entity.ConsumeAck, err
+		case entity.ConsumeRetry.String():
+			return output, entity.ConsumeRetry, err
+		case entity.ConsumeDrop.String():
+			return output, entity.ConsumeDrop, err
+		}
+	}
+	return output, entity.ConsumeRetry, fmt.Errorf("unknown lua return: %s", ret.String())
+}
+
+// 消息转换 lua table {id, queue, body, headers, attempts, content_type, timestamp}
+func (domain *PluginsConsumerDomainImpl) toTable(state *lua.LState, msg *entity.QueueMessage) *lua.LTable {
+	var (
+		table   = state.NewTable()
+		headers = state.NewTable()
+	)
+	for k, v := range msg.Headers {
+		headers.RawSetString(k, toLuaValue(v))
+	}
+	table.RawSetString("id", lua.LString(msg.ID))
+	table.RawSetString("queue", lua.LString(msg.Queue))
+	table.RawSetString("body", lua.LString(msg.Body))
+	table.RawSetString("headers", headers)
+	table.RawSetString("attempts", lua.LNumber(msg.Attempts))
+	table.RawSetString("content_type", lua.LString(msg.ContentType))
+	table.RawSetString("timestamp", lua.LNumber(msg.Timestamp.Unix()))
+	return table
+}
+
+// 脚本处理后的消息 [复制原消息, 取回 body|headers|content_type]
+func (domain *PluginsConsumerDomainImpl) fromTable(table *lua.LTable, msg *entity.QueueMessage) *entity.QueueMessage {
+	var output = entity.NewQueueMessage(msg.Queue, msg.Body, msg.Headers.Copy())
+	output.ID = msg.ID
+	output.Attempts = msg.Attempts
+	output.ContentType = msg.ContentType
+	output.Timestamp = msg.Timestamp
+	if body, ok := table.RawGetString("body").(lua.LString); ok {
+		output.Body = []byte(body)
+	}
+	if contentType, ok := table.RawGetString("content_type").(lua.LString); ok {
+		output.ContentType = string(contentType)
+	}
+	if headers, ok := table.RawGetString("headers").(*lua.LTable); ok {
+		output.Headers = entity.KvMap{}
+		headers.ForEach(func(k, v lua.LValue) {
+			output.Headers[k.String()] = fromLuaValue(v)
+		})
+	}
+	return output
+}
+
+func toLuaValue(v interface{}) lua.LValue {
+	switch value := v.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(value)
+	case bool:
+		return lua.LBool(value)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return lua.LNumber(entity.NewValue(value).Float())
+	}
+	return lua.LString(fmt.Sprintf("%v", v))
+}
+
+func fromLuaValue(v lua.LValue) interface{} {
+	switch value := v.(type) {
+	case lua.LBool:
+		return bool(value)
+	case lua.LNumber:
+		if float64(value) == float64(int64(value)) {
+			return int64(value)
+		}
+		return float64(value)
+	case lua.LString:
+		return string(value)
+	}
+	return v.String()
+}
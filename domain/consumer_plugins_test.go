@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/utils"
+	"testing"
+)
+
+func TestPluginsConsumerDomainImpl_Handle(t *testing.T) {
+	var (
+		consumer = NewPluginsConsumerDomain()
+		source   = `
+log = require("logger")
+function handle(msg)
+	if msg.headers.kind == "bad" then
+		return "drop", "bad message"
+	end
+	if msg.attempts > 0 then
+		return false
+	end
+	log.logInfoLn("lua consume:", msg.id, msg.body)
+	return "ack"
+end`
+	)
+	if err := consumer.Parse([]byte(`{"source":` + utils.JsonEncode(source).String() + `,"timeout":"1s"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		msg    *entity.QueueMessage
+		expect entity.ConsumeAction
+	}{
+		{entity.NewQueueMessage("test", []byte(`{"id":1}`)), entity.ConsumeAck},
+		{entity.NewQueueMessage("test", []byte(`{}`), entity.KvMap{"kind": "bad"}), entity.ConsumeDrop},
+		{&entity.QueueMessage{Queue: "test", Attempts: 1}, entity.ConsumeRetry},
+	}
+	for i, c := range cases {
+		if action, _ := consumer.Handle(c.msg); action != c.expect {
+			t.Errorf("case %d: expect %s, got %s", i, c.expect, action)
+		}
+	}
+
+	// 脚本超时
+	if err := consumer.Parse([]byte(`{"source":"function handle(msg) while true do end end","timeout":"50ms"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if action, err := consumer.Handle(cases[0].msg); action != entity.ConsumeRetry || err == nil {
+		t.Fatalf("expect timeout retry, got %s %v", action, err)
+	}
+}
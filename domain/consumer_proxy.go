@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"text/template"
+)
+
+// ProxyConsumerDomainImpl 转发消费器 [消息转投其他队列|驱动|连接, 目标发布成功后确认]
+type ProxyConsumerDomainImpl struct {
+	params    entity.KvMap
+	driver    string
+	namespace string
+	target    string
+	route     *template.Template
+	headers   entity.KvMap
+	removes   []string
+	entry     facede.QueueEntry
+}
+
+const (
+	ParamProxyDriver        = "driver"
+	ParamProxyNamespace     = "namespace"
+	ParamProxyTarget        = "target"
+	ParamProxyRoutingKey    = "routing_key"
+	ParamProxyHeaders       = "headers"
+	ParamProxyRemoveHeaders = "remove_headers"
+	// HeaderForwardedFrom 转发来源队列
+	HeaderForwardedFrom = "x-forwarded-from"
+)
+
+func NewProxyConsumerDomain() *ProxyConsumerDomainImpl {
+	var domain = new(ProxyConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *ProxyConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.driver = repo.DriverAmqp
+	domain.headers = entity.KvMap{}
+}
+
+func (domain *ProxyConsumerDomainImpl) Type() string {
+	return entity.ConsumerProxy
+}
+
+// Parse 解析配置
+// {"driver":"AMQP|REDIS","namespace":"backup","target":"orders","routing_key":"{{.Queue}}.{{index .Headers \"region\"}}","headers":{"k":"v"},"remove_headers":["x-trace"]}
+func (domain *ProxyConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.driver = strings.ToUpper(domain.params.GetStr(ParamProxyDriver, repo.DriverAmqp))
+	domain.namespace = domain.params.GetStr(ParamProxyNamespace)
+	domain.target = domain.params.GetStr(ParamProxyTarget)
+	if text := domain.params.GetStr(ParamProxyRoutingKey); text != "" {
+		tpl, err := template.New(ParamProxyRoutingKey).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return err
+		}
+		domain.route = tpl
+	}
+	if domain.target == "" && domain.route == nil {
+		return errors.New("miss param: " + ParamProxyTarget)
+	}
+	domain.headers = domain.params.GetKvMap(ParamProxyHeaders, entity.KvMap{})
+	domain.removes = domain.params.GetArr(ParamProxyRemoveHeaders)
+	entry, err := repo.GetQueueDriverRepo().Get(domain.driver, domain.namespace)
+	if err != nil {
+		return err
+	}
+	domain.entry = entry
+	return nil
+}
+
+// SetEntry 指定目标队列实例
+func (domain *ProxyConsumerDomainImpl) SetEntry(entry facede.QueueEntry) *ProxyConsumerDomainImpl {
+	domain.entry = entry
+	return domain
+}
+
+// Handle 转发消息 [发布成功:确认, 路由计算失败:丢弃, 发布失败:重试]
+func (domain *ProxyConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	target, err := domain.Target(msg)
+	if err != nil {
+		return entity.ConsumeDrop, err
+	}
+	if target == msg.Queue && domain.namespace == "" && domain.driver == repo.DriverAmqp {
+		return entity.ConsumeDrop, fmt.Errorf("proxy target %s is the source queue", target)
+	}
+	if err = domain.entry.Push(domain.forward(msg), target); err != nil {
+		return entity.ConsumeRetry, err
+	}
+	return entity.ConsumeAck, nil
+}
+
+// Target 目标队列 [配置 routing_key 模板时按消息计算]
+func (domain *ProxyConsumerDomainImpl) Target(msg *entity.QueueMessage) (string, error) {
+	if domain.route == nil {
+		return domain.target, nil
+	}
+	var buf = bytes.NewBuffer(nil)
+	if err := domain.route.Execute(buf, map[string]interface{}{
+		"ID":      msg.ID,
+		"Queue":   msg.Queue,
+		"Target":  domain.target,
+		"Headers": msg.Headers,
+	}); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", errors.New("proxy routing key is empty")
+	}
+	return buf.String(), nil
+}
+
+// 转发消息 [复制消息体及消息头, 按配置改写消息头]
+func (domain *ProxyConsumerDomainImpl) forward(msg *entity.QueueMessage) *entity.QueueMessage {
+	var headers = msg.Headers.Copy()
+	for _, k := range domain.removes {
+		delete(headers, k)
+	}
+	for k, v := range domain.headers {
+		headers[k] = v
+	}
+	headers[HeaderForwardedFrom] = msg.Queue
+	var forward = entity.NewQueueMessage(msg.Queue, msg.Body, headers)
+	forward.ID = msg.ID
+	forward.ContentType = msg.ContentType
+	forward.Timestamp = msg.Timestamp
+	return forward
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"errors"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"testing"
+)
+
+type proxyTestEntry struct {
+	facede.QueueEntry
+	fail   bool
+	queue  string
+	pushed *entity.QueueMessage
+}
+
+func (entry *proxyTestEntry) Push(data interface{}, queue ...string) error {
+	if entry.fail {
+		return errors.New("publish failed")
+	}
+	entry.pushed, entry.queue = data.(*entity.QueueMessage), queue[0]
+	return nil
+}
+
+func TestProxyConsumerDomainImpl_Handle(t *testing.T) {
+	var (
+		consumer = NewProxyConsumerDomain()
+		entry    = new(proxyTestEntry)
+	)
+	if err := consumer.Parse([]byte(`{"namespace":"backup","routing_key":"{{.Queue}}.{{.Headers.region}}","headers":{"x-bridge":"on"},"remove_headers":["x-trace"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	consumer.SetEntry(entry)
+	var msg = entity.NewQueueMessage("orders", []byte(`{}`), entity.KvMap{"region": "eu", "x-trace": "1"})
+	msg.ID = "m1"
+	if action, err := consumer.Handle(msg); action != entity.ConsumeAck || err != nil {
+		t.Fatalf("expect ack, got %s %v", action, err)
+	}
+	if entry.queue != "orders.eu" || entry.pushed.ID != "m1" || entry.pushed.Headers["x-bridge"] != "on" ||
+		entry.pushed.Headers.Exists("x-trace") || entry.pushed.Headers[HeaderForwardedFrom] != "orders" {
+		t.Fatalf("unexpected forward %s %+v", entry.queue, entry.pushed)
+	}
+	if msg.Headers["x-trace"] != "1" {
+		t.Fatal("source headers must not be modified")
+	}
+	entry.fail = true
+	if action, _ := consumer.Handle(msg); action != entity.ConsumeRetry {
+		t.Fatalf("expect retry when publish failed, got %s", action)
+	}
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+)
+
+// RetryConsumerDomainImpl 重试消费器 [不支持绑定分发的驱动使用, 消费结果为重试时按策略延迟重投, 次数用尽或丢弃时投递死信队列]
+type RetryConsumerDomainImpl struct {
+	facede.Consumer
+	entry   facede.QueueEntry
+	retrier *repo.Retrier
+}
+
+// WithRetryPolicy 包装消费器 [entry: 延迟重投及死信投递的队列实例, policy 为空时不延迟重投 仅投递死信队列]
+func WithRetryPolicy(consumer facede.Consumer, entry facede.QueueEntry, policy *entity.RetryPolicy) *RetryConsumerDomainImpl {
+	var domain = &RetryConsumerDomainImpl{Consumer: consumer, entry: entry}
+	if policy != nil {
+		domain.retrier = repo.NewRetrier(entry, policy)
+	}
+	return domain
+}
+
+// Handle 处理消息 [异常视为重试]
+func (domain *RetryConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	return domain.HandleContext(msg.Context(), msg)
+}
+
+// HandleContext 处理消息 [上下文传递给被包装的消费器, 超时视为重试]
+func (domain *RetryConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (action entity.ConsumeAction, err error) {
+	action, err = domain.invoke(ctx, msg)
+	if action == entity.ConsumeRetry && domain.retrier != nil {
+		var e error
+		if action, e = domain.retrier.Retry(msg, msg.Queue, nil); e != nil {
+			err = joinError(err, e)
+		}
+	}
+	// 重试用尽或丢弃 投递死信队列
+	if action == entity.ConsumeDrop {
+		var e error
+		if action, e = repo.DeadLetter(domain.entry, msg, msg.Queue, err); e != nil {
+			err = joinError(err, e)
+		}
+	}
+	return action, err
+}
+
+func joinError(err, next error) error {
+	if err == nil {
+		return next
+	}
+	return fmt.Errorf("%v; %w", err, next)
+}
+
+func (domain *RetryConsumerDomainImpl) invoke(ctx context.Context, msg *entity.QueueMessage) (action entity.ConsumeAction, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			action, err = entity.ConsumeRetry, fmt.Errorf("panic: %v", e)
+		}
+	}()
+	return repo.HandleContext(ctx, domain.Consumer, msg, 0)
+}
+
+// Policy 重试策略 [未配置返回 nil]
+func (domain *RetryConsumerDomainImpl) Policy() *entity.RetryPolicy {
+	if domain.retrier == nil {
+		return nil
+	}
+	return domain.retrier.Policy()
+}
@@ -0,0 +1,363 @@
+package domain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// ShellConsumerDomainImpl 命令行消费器
+	ShellConsumerDomainImpl struct {
+		locker     sync.Mutex
+		params     entity.KvMap
+		command    string
+		args       []string
+		dir        string
+		env        []string
+		mode       string
+		timeout    time.Duration
+		retryCodes map[int]bool
+		dropCodes  map[int]bool
+		worker     *shellWorker
+		logger     *logrus.Logger
+	}
+
+	// 常驻进程 [stdin 逐行写入消息json, stdout 逐行读取处理结果]
+	shellWorker struct {
+		cmd    *exec.Cmd
+		stdin  io.WriteCloser
+		stdout *bufio.Reader
+	}
+
+	// 常驻进程 处理结果
+	shellReply struct {
+		Action string `json:"action"`
+		Code   *int   `json:"code"`
+		Error  string `json:"error"`
+	}
+
+	// 按行写入日志
+	logWriter struct {
+		buf    bytes.Buffer
+		level  logrus.Level
+		logger *logrus.Entry
+	}
+)
+
+const (
+	ParamShellCommand    = "command"
+	ParamShellArgs       = "args"
+	ParamShellDir        = "dir"
+	ParamShellEnv        = "env"
+	ParamShellMode       = "mode"
+	ParamShellTimeout    = "timeout"
+	ParamShellRetryCodes = "retry_codes"
+	ParamShellDropCodes  = "drop_codes"
+	ShellModeOnce        = "once"
+	ShellModeWorker      = "worker"
+	defaultShellTimeout  = time.Minute
+)
+
+var (
+	ErrorShellTimeout = errors.New("shell consumer timeout")
+)
+
+func NewShellConsumerDomain() *ShellConsumerDomainImpl {
+	var domain = new(ShellConsumerDomainImpl)
+	domain.init()
+	return domain
+}
+
+func (domain *ShellConsumerDomainImpl) init() {
+	domain.params = entity.KvMap{}
+	domain.mode = ShellModeOnce
+	domain.timeout = defaultShellTimeout
+	domain.retryCodes = map[int]bool{}
+	domain.dropCodes = map[int]bool{}
+}
+
+func (domain *ShellConsumerDomainImpl) Type() string {
+	return entity.ConsumerShell
+}
+
+// Parse 解析配置
+// {"command":"/usr/bin/php","args":["job.php"],"dir":"/app","env":{"K":"V"},"mode":"once|worker","timeout":"30s","retry_codes":[75],"drop_codes":[65]}
+func (domain *ShellConsumerDomainImpl) Parse(properties []byte) error {
+	if len(properties) <= 0 {
+		return errors.New("empty properties")
+	}
+	if err := utils.JsonDecode(properties, &domain.params); err != nil {
+		return err
+	}
+	domain.command = domain.params.GetStr(ParamShellCommand)
+	if domain.command == "" {
+		return errors.New("miss param: " + ParamShellCommand)
+	}
+	domain.args = domain.params.GetArr(ParamShellArgs)
+	domain.dir = domain.params.GetStr(ParamShellDir)
+	domain.timeout = domain.params.GetDuration(ParamShellTimeout, defaultShellTimeout)
+	domain.mode = strings.ToLower(domain.params.GetStr(ParamShellMode, ShellModeOnce))
+	if domain.mode != ShellModeOnce && domain.mode != ShellModeWorker {
+		return errors.New("unknown shell mode: " + domain.mode)
+	}
+	domain.env = os.Environ()
+	for k, v := range domain.params.GetKvMap(ParamShellEnv) {
+		domain.env = append(domain.env, fmt.Sprintf("%s=%v", k, v))
+	}
+	for _, code := range domain.params.GetArrAny(ParamShellRetryCodes) {
+		domain.retryCodes[entity.NewValue(code).Int()] = true
+	}
+	for _, code := range domain.params.GetArrAny(ParamShellDropCodes) {
+		domain.dropCodes[entity.NewValue(code).Int()] = true
+	}
+	return nil
+}
+
+// SetLogger 设置输出日志
+func (domain *ShellConsumerDomainImpl) SetLogger(logger *logrus.Logger) *ShellConsumerDomainImpl {
+	domain.logger = logger
+	return domain
+}
+
+// Handle 执行命令处理消息 [退出码 0:确认, retry_codes:重试, drop_codes:丢弃]
+func (domain *ShellConsumerDomainImpl) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	return domain.HandleContext(msg.Context(), msg)
+}
+
+// HandleContext 执行命令处理消息 [ctx 取消时结束进程, 视为重试]
+func (domain *ShellConsumerDomainImpl) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	if domain.mode == ShellModeWorker {
+		return domain.send(ctx, msg)
+	}
+	return domain.exec(ctx, msg)
+}
+
+// Close 停止常驻进程
+func (domain *ShellConsumerDomainImpl) Close() {
+	domain.locker.Lock()
+	defer domain.locker.Unlock()
+	domain.stop()
+}
+
+// 单次执行 消息体写入 stdin
+func (domain *ShellConsumerDomainImpl) exec(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var (
+		cmd    = domain.createCmd()
+		stdout = domain.getWriter(logrus.InfoLevel, msg)
+		stderr = domain.getWriter(logrus.WarnLevel, msg)
+	)
+	for k, v := range messageEnv(msg) {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin = bytes.NewReader(msg.Body)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	defer stdout.Flush()
+	defer stderr.Flush()
+	if err := cmd.Start(); err != nil {
+		return entity.ConsumeRetry, err
+	}
+	var done = make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timer = time.NewTimer(domain.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return domain.actionOf(err)
+	case <-timer.C:
+		killProcessGroup(cmd)
+		<-done
+		return entity.ConsumeRetry, ErrorShellTimeout
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return entity.ConsumeRetry, entity.ContextError(ctx, nil)
+	}
+}
+
+// 常驻进程 发送消息并等待一行结果
+func (domain *ShellConsumerDomainImpl) send(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	domain.locker.Lock()
+	defer domain.locker.Unlock()
+	var worker, err = domain.getWorker()
+	if err != nil {
+		return entity.ConsumeRetry, err
+	}
+	line, err := json.Marshal(msg.KvMap())
+	if err != nil {
+		return entity.ConsumeDrop, err
+	}
+	if _, err = worker.stdin.Write(append(line, '\n')); err != nil {
+		domain.stop()
+		return entity.ConsumeRetry, err
+	}
+	var reply = make(chan string, 1)
+	go func() {
+		text, _ := worker.stdout.ReadString('\n')
+		reply <- text
+	}()
+	var timer = time.NewTimer(domain.timeout)
+	defer timer.Stop()
+	select {
+	case text := <-reply:
+		if text == "" {
+			domain.stop()
+			return entity.ConsumeRetry, errors.New("shell worker exited")
+		}
+		return domain.parseReply(text)
+	case <-timer.C:
+		domain.stop()
+		return entity.ConsumeRetry, ErrorShellTimeout
+	case <-ctx.Done():
+		domain.stop()
+		return entity.ConsumeRetry, entity.ContextError(ctx, nil)
+	}
+}
+
+// 获取常驻进程 [未启动|已退出时启动]
+func (domain *ShellConsumerDomainImpl) getWorker() (*shellWorker, error) {
+	if domain.worker != nil {
+		return domain.worker, nil
+	}
+	var cmd = domain.createCmd()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = domain.getWriter(logrus.WarnLevel, nil)
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	domain.worker = &shellWorker{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	return domain.worker, nil
+}
+
+// 停止常驻进程 [调用方持有锁]
+func (domain *ShellConsumerDomainImpl) stop() {
+	if domain.worker == nil {
+		return
+	}
+	_ = domain.worker.stdin.Close()
+	killProcessGroup(domain.worker.cmd)
+	_ = domain.worker.cmd.Wait()
+	domain.worker = nil
+}
+
+func (domain *ShellConsumerDomainImpl) createCmd() *exec.Cmd {
+	var cmd = exec.Command(domain.command, domain.args...)
+	cmd.Dir = domain.dir
+	cmd.Env = append([]string{}, domain.env...)
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// 退出码转换处理动作
+func (domain *ShellConsumerDomainImpl) actionOf(err error) (entity.ConsumeAction, error) {
+	if err == nil {
+		return entity.ConsumeAck, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return entity.ConsumeRetry, err
+	}
+	return domain.codeOf(exitErr.ExitCode()), err
+}
+
+// 退出码 [信号结束(含超时、OOM 被杀, 退出码 -1)重试, 配置 retry_codes 时其他非0退出码丢弃, 否则重试]
+func (domain *ShellConsumerDomainImpl) codeOf(code int) entity.ConsumeAction {
+	switch {
+	case code == 0:
+		return entity.ConsumeAck
+	case code < 0, domain.retryCodes[code]:
+		return entity.ConsumeRetry
+	case domain.dropCodes[code], len(domain.retryCodes) > 0:
+		return entity.ConsumeDrop
+	}
+	return entity.ConsumeRetry
+}
+
+// 解析常驻进程结果 [json {"action":"ack|retry|drop"} | {"code":0} 或 纯文本 ack|retry|drop]
+func (domain *ShellConsumerDomainImpl) parseReply(text string) (entity.ConsumeAction, error) {
+	var reply = shellReply{Action: strings.TrimSpace(text)}
+	if strings.HasPrefix(reply.Action, "{") {
+		if err := json.Unmarshal([]byte(reply.Action), &reply); err != nil {
+			return entity.ConsumeRetry, err
+		}
+	}
+	var err error
+	if reply.Error != "" {
+		err = errors.New(reply.Error)
+	}
+	switch strings.ToLower(reply.Action) {
+	case entity.ConsumeAck.String():
+		return entity.ConsumeAck, err
+	case entity.ConsumeRetry.String():
+		return entity.ConsumeRetry, err
+	case entity.ConsumeDrop.String():
+		return entity.ConsumeDrop, err
+	}
+	if reply.Code != nil {
+		if err == nil && *reply.Code != 0 {
+			err = fmt.Errorf("shell worker reply code %d", *reply.Code)
+		}
+		return domain.codeOf(*reply.Code), err
+	}
+	return entity.ConsumeRetry, errors.New("unknown shell worker reply: " + reply.Action)
+}
+
+func (domain *ShellConsumerDomainImpl) getLogger() *logrus.Logger {
+	if domain.logger == nil {
+		domain.logger = repo.GetLogger("consumer")
+	}
+	return domain.logger
+}
+
+func (domain *ShellConsumerDomainImpl) getWriter(level logrus.Level, msg *entity.QueueMessage) *logWriter {
+	var logger = domain.getLogger().WithField("command", domain.command)
+	if msg != nil {
+		logger = logger.WithField("id", msg.ID)
+	}
+	return &logWriter{level: level, logger: logger}
+}
+
+func (writer *logWriter) Write(p []byte) (int, error) {
+	writer.buf.Write(p)
+	for {
+		line, err := writer.buf.ReadString('\n')
+		if err != nil {
+			// 不完整行 放回缓冲
+			writer.buf.Reset()
+			writer.buf.WriteString(line)
+			return len(p), nil
+		}
+		writer.logger.Logln(writer.level, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// Flush 输出剩余内容
+func (writer *logWriter) Flush() {
+	if writer.buf.Len() > 0 {
+		writer.logger.Logln(writer.level, writer.buf.String())
+		writer.buf.Reset()
+	}
+}
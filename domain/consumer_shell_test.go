@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package domain
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+	"time"
+)
+
+func TestShellConsumerDomainImpl_Handle(t *testing.T) {
+	var cases = map[string]entity.ConsumeAction{
+		`{"command":"sh","args":["-c","cat >/dev/null; [ \"$QUEUE_NAME\" = test ]"]}`:                                    entity.ConsumeAck,
+		`{"command":"sh","args":["-c","exit 65"],"drop_codes":[65]}`:                                                     entity.ConsumeDrop,
+		`{"command":"sh","args":["-c","exit 75"],"retry_codes":[75]}`:                                                    entity.ConsumeRetry,
+		`{"command":"sh","args":["-c","kill -9 $$"],"retry_codes":[75]}`:                                                 entity.ConsumeRetry,
+		`{"command":"sh","args":["-c","sleep 5 & sleep 5"],"timeout":"200ms"}`:                                           entity.ConsumeRetry,
+		`{"command":"sh","args":["-c","while read l; do echo ack; done"],"mode":"worker"}`:                               entity.ConsumeAck,
+		`{"command":"sh","args":["-c","while read l; do echo '{\"code\":65}'; done"],"mode":"worker","drop_codes":[65]}`: entity.ConsumeDrop,
+	}
+	for properties, expect := range cases {
+		var consumer = NewShellConsumerDomain()
+		if err := consumer.Parse([]byte(properties)); err != nil {
+			t.Fatal(err)
+		}
+		var start = time.Now()
+		action, _ := consumer.Handle(entity.NewQueueMessage("test", []byte(`{"id":1}`)))
+		consumer.Close()
+		if action != expect {
+			t.Errorf("ShellConsumer %s expect %s, got %s", properties, expect, action)
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Errorf("ShellConsumer %s timeout not killed", properties)
+		}
+	}
+}
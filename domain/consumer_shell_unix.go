@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package domain
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// 独立进程组 超时时整组结束
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// 内存去重存储
+	dedupTestStore struct {
+		locker sync.Mutex
+		keys   map[string]time.Duration
+	}
+
+	// 依次返回预设结果
+	sequenceTestConsumer struct {
+		ShellConsumerDomainImpl
+		actions []entity.ConsumeAction
+		handled int
+	}
+)
+
+func (store *dedupTestStore) Reserve(key string, ttl time.Duration) (bool, error) {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	if _, ok := store.keys[key]; ok {
+		return false, nil
+	}
+	store.keys[key] = ttl
+	return true, nil
+}
+
+func (store *dedupTestStore) Commit(key string, ttl time.Duration) error {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	store.keys[key] = ttl
+	return nil
+}
+
+func (store *dedupTestStore) Release(key string) error {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	delete(store.keys, key)
+	return nil
+}
+
+func (consumer *sequenceTestConsumer) HandleContext(_ context.Context, _ *entity.QueueMessage) (entity.ConsumeAction, error) {
+	var action = consumer.actions[consumer.handled]
+	consumer.handled++
+	return action, nil
+}
+
+// 阻塞至上下文结束
+type blockingTestConsumer struct {
+	ShellConsumerDomainImpl
+}
+
+func (consumer *blockingTestConsumer) HandleContext(ctx context.Context, _ *entity.QueueMessage) (entity.ConsumeAction, error) {
+	select {
+	case <-ctx.Done():
+		return entity.ConsumeRetry, ctx.Err()
+	case <-time.After(3 * time.Second):
+		return entity.ConsumeAck, nil
+	}
+}
+
+func TestCreateConsumeHandler_Timeout(t *testing.T) {
+	var (
+		binding = &repo.QueueBinding{Name: "slow", Consumer: new(blockingTestConsumer), Timeout: 20 * time.Millisecond}
+		msg     = entity.NewQueueMessage("orders", []byte(`{}`))
+		start   = time.Now()
+	)
+	action, err := handleSafely(binding, msg)
+	if action != entity.ConsumeRetry || err == nil || time.Since(start) > time.Second {
+		t.Fatalf("expect binding timeout retry, got %s %v after %s", action, err, time.Since(start))
+	}
+	// 回调按绑定超时返回
+	start = time.Now()
+	CreateConsumeHandler(binding)(entity.NewQueueMessage("orders", []byte(`{}`)))
+	if time.Since(start) > time.Second {
+		t.Fatalf("expect handler to honour binding timeout, took %s", time.Since(start))
+	}
+}
+
+func TestWithDedup(t *testing.T) {
+	var (
+		store      = &dedupTestStore{keys: make(map[string]time.Duration)}
+		consumer   = &sequenceTestConsumer{actions: []entity.ConsumeAction{entity.ConsumeRetry, entity.ConsumeAck}}
+		dedup, err = repo.NewDedup(time.Hour, repo.DedupByHash, store)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		handle = CreateConsumeHandler(&repo.QueueBinding{Name: "php", Consumer: WithDedup(consumer, dedup, "php")})
+		key    = dedup.Key("orders", "php", entity.NewQueueMessage("orders", []byte(`{"id":1}`)))
+	)
+	// 处理失败释放 重投时再次处理; 成功后窗口内重复消息直接确认
+	for i := 0; i < 3; i++ {
+		handle(entity.NewQueueMessage("orders", []byte(`{"id":1}`)))
+	}
+	if consumer.handled != 2 || store.keys[key] != time.Hour {
+		t.Fatalf("expect duplicate skipped after ack, handled %d, key ttl %s", consumer.handled, store.keys[key])
+	}
+}
@@ -0,0 +1,263 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/models"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// 消费失败记录 [异步写入 app_queue_fails, 敏感字段脱敏]
+	failureDomainImpl struct {
+		safe    sync.RWMutex
+		fields  map[string]bool
+		mask    string
+		records chan *entity.ConsumeFailure
+		writer  func(record *models.QueueFails) error
+		start   sync.Once
+		logger  *logrus.Logger
+	}
+
+	// 失败消息详情
+	failurePayload struct {
+		ID          string       `json:"id"`
+		Binding     string       `json:"binding,omitempty"`
+		Action      string       `json:"action"`
+		ContentType string       `json:"content_type,omitempty"`
+		Timestamp   time.Time    `json:"timestamp"`
+		Headers     entity.KvMap `json:"headers"`
+		Body        interface{}  `json:"body"`
+		Encoding    string       `json:"body_encoding,omitempty"`
+		Errors      []string     `json:"errors"`
+		Stack       string       `json:"stack,omitempty"`
+	}
+)
+
+const (
+	// EnvFailRedactFields 脱敏字段 [逗号分隔, 匹配消息头及 json 消息体字段名, 忽略大小写]
+	EnvFailRedactFields = "QUEUE_FAILS_REDACT_FIELDS"
+	// EnvFailRedactMask 脱敏替换值
+	EnvFailRedactMask = "QUEUE_FAILS_REDACT_MASK"
+	// EnvFailBuffer 待写入记录缓冲数
+	EnvFailBuffer         = "QUEUE_FAILS_BUFFER"
+	defaultFailRedactMask = "******"
+	defaultFailBuffer     = 1000
+)
+
+var (
+	failureDefault      *failureDomainImpl
+	failureDefaultMutex sync.Mutex
+)
+
+func NewFailureDomain() *failureDomainImpl {
+	var domain = new(failureDomainImpl)
+	return domain.init()
+}
+
+// GetFailureDomain 获取消费失败记录
+func GetFailureDomain() *failureDomainImpl {
+	failureDefaultMutex.Lock()
+	defer failureDefaultMutex.Unlock()
+	if failureDefault == nil {
+		failureDefault = NewFailureDomain()
+	}
+	return failureDefault
+}
+
+func (domain *failureDomainImpl) init() *failureDomainImpl {
+	domain.safe = sync.RWMutex{}
+	domain.fields = make(map[string]bool)
+	domain.mask = utils.GetEnvVal(EnvFailRedactMask, defaultFailRedactMask)
+	domain.records = make(chan *entity.ConsumeFailure, utils.GetEnvInt(EnvFailBuffer, defaultFailBuffer))
+	domain.writer = func(record *models.QueueFails) error {
+		return record.Save()
+	}
+	domain.logger = repo.GetLogger("consumer")
+	domain.SetRedactFields(utils.GetEnvArr(EnvFailRedactFields)...)
+	return domain
+}
+
+// SetRedactFields 设置脱敏字段
+func (domain *failureDomainImpl) SetRedactFields(fields ...string) *failureDomainImpl {
+	domain.safe.Lock()
+	defer domain.safe.Unlock()
+	domain.fields = make(map[string]bool)
+	for _, field := range fields {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			domain.fields[field] = true
+		}
+	}
+	return domain
+}
+
+// SetWriter 设置记录写入方式 [默认写入数据库]
+func (domain *failureDomainImpl) SetWriter(writer func(record *models.QueueFails) error) *failureDomainImpl {
+	domain.safe.Lock()
+	defer domain.safe.Unlock()
+	if writer != nil {
+		domain.writer = writer
+	}
+	return domain
+}
+
+// Record 记录消费失败 [缓冲已满时只记录日志]
+func (domain *failureDomainImpl) Record(failure *entity.ConsumeFailure) {
+	if failure == nil {
+		return
+	}
+	domain.start.Do(func() {
+		go domain.loop()
+	})
+	select {
+	case domain.records <- failure:
+	default:
+		domain.logger.WithFields(domain.fieldsOf(failure)).Errorln("queue fails buffer full:", failure.Errors())
+	}
+}
+
+// 逐条写入
+func (domain *failureDomainImpl) loop() {
+	for failure := range domain.records {
+		domain.write(failure)
+	}
+}
+
+func (domain *failureDomainImpl) write(failure *entity.ConsumeFailure) {
+	// 数据库未配置时 GetDb 会 panic
+	defer func() {
+		if e := recover(); e != nil {
+			domain.logger.WithFields(domain.fieldsOf(failure)).Errorln("queue fails write panic:", e)
+		}
+	}()
+	domain.safe.RLock()
+	var writer = domain.writer
+	domain.safe.RUnlock()
+	if err := writer(domain.Build(failure)); err != nil {
+		domain.logger.WithFields(domain.fieldsOf(failure)).Errorln("queue fails write error:", err)
+	}
+}
+
+// Build 转换失败记录 [消息头及消息体脱敏]
+func (domain *failureDomainImpl) Build(failure *entity.ConsumeFailure) *models.QueueFails {
+	var (
+		chain  = failure.Errors()
+		record = &models.QueueFails{
+			AppID:    failure.AppID,
+			Status:   models.QueueFailStatusFailed,
+			Error:    strings.Join(chain, "; "),
+			Type:     failure.Driver,
+			Queue:    failure.Queue,
+			Consumer: failure.Consumer,
+		}
+		payload = failurePayload{
+			Binding: failure.Binding,
+			Action:  failure.Action.String(),
+			Headers: entity.KvMap{},
+			Errors:  chain,
+			Stack:   failure.Stack,
+		}
+	)
+	if failure.Panic {
+		record.Status = models.QueueFailStatusPanic
+	}
+	if msg := failure.Message; msg != nil {
+		if record.Queue == "" {
+			record.Queue = msg.Queue
+		}
+		if record.Type == "" {
+			if _, ok := msg.GetRowMessage().(*amqp.Delivery); ok {
+				record.Type = strings.ToLower(repo.DriverAmqp)
+			}
+		}
+		if msg.Attempts > 0 {
+			record.TryTimes = uint(msg.Attempts)
+		}
+		payload.ID = msg.ID
+		payload.ContentType = msg.ContentType
+		payload.Timestamp = msg.Timestamp
+		payload.Headers = domain.redactHeaders(msg.Headers)
+		payload.Body, payload.Encoding = domain.redactBody(msg.Body)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"id": payload.ID, "errors": append(chain, err.Error())})
+	}
+	record.Payloads = string(data)
+	return record
+}
+
+func (domain *failureDomainImpl) redactHeaders(headers entity.KvMap) entity.KvMap {
+	var redacted = entity.KvMap{}
+	for k, v := range headers {
+		if domain.sensitive(k) {
+			v = domain.mask
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// 消息体 [json 按字段脱敏, 非 utf8 内容 base64 编码]
+func (domain *failureDomainImpl) redactBody(body []byte) (interface{}, string) {
+	var (
+		value   interface{}
+		decoder = json.NewDecoder(bytes.NewReader(body))
+	)
+	decoder.UseNumber()
+	if len(bytes.TrimSpace(body)) > 0 && decoder.Decode(&value) == nil && !decoder.More() {
+		return domain.redactValue(value), ""
+	}
+	if !utf8.Valid(body) {
+		return base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return string(body), ""
+}
+
+func (domain *failureDomainImpl) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if domain.sensitive(key) {
+				v[key] = domain.mask
+				continue
+			}
+			v[key] = domain.redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = domain.redactValue(item)
+		}
+	}
+	return value
+}
+
+func (domain *failureDomainImpl) sensitive(field string) bool {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
+	return domain.fields[strings.ToLower(field)]
+}
+
+func (domain *failureDomainImpl) fieldsOf(failure *entity.ConsumeFailure) logrus.Fields {
+	var fields = logrus.Fields{
+		"queue":    failure.Queue,
+		"consumer": failure.Consumer,
+		"binding":  failure.Binding,
+		"action":   failure.Action.String(),
+	}
+	if failure.Message != nil {
+		fields["id"] = failure.Message.ID
+		fields["attempts"] = fmt.Sprintf("%d", failure.Message.Attempts)
+	}
+	return fields
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/models"
+	"github.com/weblfe/queue_mgr/repo"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failureTestConsumer struct {
+	ShellConsumerDomainImpl
+}
+
+func (consumer *failureTestConsumer) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	panic("boom " + msg.ID)
+}
+
+// 覆盖内嵌消费器的 HandleContext
+func (consumer *failureTestConsumer) HandleContext(_ context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	return consumer.Handle(msg)
+}
+
+func TestFailureDomainImpl_Record(t *testing.T) {
+	var (
+		records = make(chan *models.QueueFails, 1)
+		domain  = NewFailureDomain().SetRedactFields("password", " Authorization ").SetWriter(func(record *models.QueueFails) error {
+			records <- record
+			return nil
+		})
+	)
+	repo.SetFailureRecorder(domain)
+	defer repo.SetFailureRecorder(nil)
+
+	var msg = entity.NewQueueMessage("orders", []byte(`{"user":{"name":"a","password":"secret"},"items":[{"password":"x"}]}`),
+		entity.KvMap{"authorization": "Bearer t", "x-delivery-count": 3})
+	msg.ID = "m1"
+	msg.Attempts = 3
+	action, err := handleSafely(&repo.QueueBinding{Consumer: new(failureTestConsumer)}, msg)
+	if action != entity.ConsumeRetry || err == nil {
+		t.Fatalf("panic expect retry, got %s %v", action, err)
+	}
+	var record *models.QueueFails
+	select {
+	case record = <-records:
+	case <-time.After(time.Second):
+		t.Fatal("failure not recorded")
+	}
+	if record.Status != models.QueueFailStatusPanic || record.TryTimes != 3 || record.Queue != "orders" ||
+		record.Consumer != entity.ConsumerShell || !strings.Contains(record.Error, "boom m1") {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if strings.Contains(record.Payloads, "secret") || strings.Contains(record.Payloads, "Bearer") {
+		t.Fatalf("payload not redacted %s", record.Payloads)
+	}
+	var payload failurePayload
+	if err = json.Unmarshal([]byte(record.Payloads), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != "m1" || payload.Action != "retry" || payload.Stack == "" || payload.Headers["authorization"] != defaultFailRedactMask {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+
+	// 错误链
+	var cause = errors.New("connection refused")
+	record = domain.Build(&entity.ConsumeFailure{Queue: "orders", Action: entity.ConsumeDrop, Err: fmt.Errorf("call api: %w", cause),
+		Message: entity.NewQueueMessage("orders", []byte{0xff, 0xfe})})
+	if record.Status != models.QueueFailStatusFailed || record.Error != "call api: connection refused; connection refused" ||
+		!strings.Contains(record.Payloads, `"body_encoding":"base64"`) {
+		t.Fatalf("unexpected record %+v", record)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/weblfe/queue_mgr/entity"
 	"github.com/weblfe/queue_mgr/facede"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -48,9 +50,36 @@ func (domain *fastCgiMgrDomainImpl) init() *fastCgiMgrDomainImpl {
 	return domain
 }
 
+// Resolve 按请求头 Queue-Consumer-Type 选择 upstream [名称忽略大小写]
 func (domain *fastCgiMgrDomainImpl) Resolve(ctx *fiber.Ctx) (facede.Handler, error) {
 	var tyName = ctx.Get(HeaderQueueType, FastCGIQueueType)
-	return domain.get(entity.FastCgiType(tyName), ctx)
+	return domain.get(entity.FastCgiType(strings.ToLower(tyName)), ctx)
+}
+
+// RegisterUpstream 注册命名 FastCGI upstream [配置解析一次, 请求共享配置及连接池, 每次请求独立处理器]
+func (domain *fastCgiMgrDomainImpl) RegisterUpstream(name string, properties []byte) error {
+	var handler = NewPHPFastCgiDomain()
+	if err := handler.Parse(properties); err != nil {
+		return err
+	}
+	var ty = entity.FastCgiType(strings.ToLower(name))
+	handler.typeClass = ty
+	domain.AddPool(ty, func(ctx *fiber.Ctx) (facede.Handler, error) {
+		return handler.fork(), nil
+	})
+	return nil
+}
+
+// Upstreams 已注册 upstream 名称
+func (domain *fastCgiMgrDomainImpl) Upstreams() []string {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
+	var names = make([]string, 0, len(domain.factories))
+	for name := range domain.factories {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+	return names
 }
 
 func (domain *fastCgiMgrDomainImpl) get(name entity.FastCgiType, ctx *fiber.Ctx) (facede.Handler, error) {
@@ -76,6 +105,8 @@ func (domain *fastCgiMgrDomainImpl) get(name entity.FastCgiType, ctx *fiber.Ctx)
 }
 
 func (domain *fastCgiMgrDomainImpl) GetPool(name entity.FastCgiType) *sync.Pool {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
 	if pool, ok := domain.fastCgiPool[name]; ok {
 		return &pool
 	}
@@ -83,6 +114,8 @@ func (domain *fastCgiMgrDomainImpl) GetPool(name entity.FastCgiType) *sync.Pool
 }
 
 func (domain *fastCgiMgrDomainImpl) GetFactory(name entity.FastCgiType) FastCgiCreator {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
 	if factory, ok := domain.factories[name]; ok && factory != nil {
 		return factory
 	}
@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFastCgiMgrDomainImpl_Resolve(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Script", fcgi.ProcessEnv(r)["SCRIPT_FILENAME"])
+			_, _ = fmt.Fprintf(w, "%s %s", r.Header.Get("X-Client"), r.Header.Get("X-Gateway"))
+		}))
+	}()
+
+	var (
+		manager    = NewFastCgiDomain()
+		properties = fmt.Sprintf(`{"fastcgi_pass":"%s","root":"/app","fastcgi_add_headers":["X-Gateway:queue_mgr"]}`, listener.Addr())
+	)
+	if err = manager.RegisterUpstream("Legacy", []byte(properties)); err != nil {
+		t.Fatal(err)
+	}
+	var app = fiber.New()
+	app.All("/fastcgi/*", func(ctx *fiber.Ctx) error {
+		handler, err := manager.Resolve(ctx)
+		if err != nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+		ctx.Request().URI().SetPath("/" + ctx.Params("*"))
+		return handler.Call(ctx)
+	})
+
+	var req = httptest.NewRequest(http.MethodGet, "/fastcgi/index.php", nil)
+	req.Header.Set(HeaderQueueType, "legacy")
+	req.Header.Set("X-Client", "php")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "php queue_mgr" || resp.Header.Get("X-Script") != "/app/index.php" {
+		t.Fatalf("unexpected response %d %q %q", resp.StatusCode, body, resp.Header.Get("X-Script"))
+	}
+
+	// 每次请求独立处理器 取消不影响其他请求
+	var handlers [2]*PHPFastCgiDomainImpl
+	for i := range handlers {
+		var fctx = app.AcquireCtx(&fasthttp.RequestCtx{})
+		fctx.Request().Header.Set(HeaderQueueType, "legacy")
+		handler, err := manager.Resolve(fctx)
+		app.ReleaseCtx(fctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handlers[i] = handler.(*PHPFastCgiDomainImpl)
+	}
+	handlers[0].Cancel()
+	if handlers[0] == handlers[1] || handlers[1].ctx.Err() != nil || handlers[0].upstream != handlers[1].upstream {
+		t.Fatal("expect per request handler sharing upstream")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/fastcgi/index.php", nil)
+	req.Header.Set(HeaderQueueType, "unknown")
+	if resp, err = app.Test(req); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect unknown upstream not found, got %v", err)
+	}
+}
@@ -6,27 +6,29 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
 	"github.com/weblfe/queue_mgr/utils"
 	"github.com/yookoala/gofast"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 type PHPFastCgiDomainImpl struct {
-	params      entity.KvMap
-	ctx         context.Context
-	cancel      context.CancelFunc
-	connFactory gofast.ConnFactory
-	clientPool  *gofast.ClientPool
-	caller      gofast.Handler
-	logger      *logrus.Logger
-	timeout     time.Duration
-	addr        string
-	typeClass   entity.FastCgiType
+	params    entity.KvMap
+	ctx       context.Context
+	cancel    context.CancelFunc
+	upstream  facede.FastCgiUpstream
+	caller    gofast.Handler
+	logger    *logrus.Logger
+	timeout   time.Duration
+	addr      string
+	typeClass entity.FastCgiType
 }
 
 var (
@@ -43,6 +45,15 @@ const (
 	ParamFastCgiLogFile    = "fastcgi_log"
 	ParamFastCgiName       = "fastcgi_stream"
 	ParamFastCgiAddHeaders = "fastcgi_add_headers"
+	ParamFastCgiTimeout    = "fastcgi_timeout"
+	ParamFastCgiMaxIdle    = "fastcgi_max_idle"
+	ParamFastCgiMaxOpen    = "fastcgi_max_open"
+	ParamFastCgiIdleTime   = "fastcgi_idle_timeout"
+	ParamFastCgiPingPath   = "ping.path"
+	ParamFastCgiPingTime   = "ping.interval"
+	ParamFastCgiBalance    = "fastcgi_balance"
+	ParamFastCgiMaxFails   = "fastcgi_max_fails"
+	ParamFastCgiFailTime   = "fastcgi_fail_timeout"
 	PHPFastCGIType         = entity.FastCgiType("PHP-FastCGI")
 )
 
@@ -55,7 +66,8 @@ func NewPHPFastCgiDomain() *PHPFastCgiDomainImpl {
 func (domain *PHPFastCgiDomainImpl) init() {
 	domain.params = entity.KvMap{}
 	domain.typeClass = PHPFastCGIType
-	domain.ctx, domain.cancel = context.WithTimeout(context.Background(), defaultTimeout)
+	domain.timeout = defaultTimeout
+	domain.ctx, domain.cancel = context.WithCancel(context.Background())
 }
 
 func (domain *PHPFastCgiDomainImpl) Parsed() bool {
@@ -72,47 +84,51 @@ func (domain *PHPFastCgiDomainImpl) Parse(properties []byte) error {
 	if err := utils.JsonDecode(properties, &domain.params); err != nil {
 		return err
 	}
-	var addr = domain.params.GetStr(ParamFastCgiPass)
-	if addr == "" {
-		return errors.New("miss param: " + ParamFastCgiPass)
-	}
 	var (
 		root     = domain.params.GetStr(ParamFastCgiRoot, defaultFastCgiRoot)
 		endpoint = domain.params.GetStr(ParamFastCgiFile, defaultFastCgiIndex)
 	)
-	if d := domain.params.GetDuration("fastcgi_timeout", 0); d > 0 {
+	if d := domain.params.GetDuration(ParamFastCgiTimeout, 0); d > 0 {
 		domain.SetTimeout(d)
 	}
+	// 1. 连接池 [同一 upstream 共享, 多地址负载均衡]
+	upstream, err := getFastCgiUpstream(domain.params)
+	if err != nil {
+		return err
+	}
+	domain.upstream = upstream
+	domain.addr = upstream.Addr()
 	if root == "" {
 		if endpoint == "" {
 			return errors.New("miss param: " + ParamFastCgiRoot)
 		}
-		// 1. conn addr
-		domain.connFactory = gofast.SimpleConnFactory(defaultNetwork, addr)
-		// domain.clientPool = gofast.NewClientPool(gofast.SimpleClientFactory(domain.connFactory), 3, domain.timeout)
 		// 2. root file
 		domain.caller = gofast.NewHandler(
 			gofast.NewFileEndpoint(endpoint)(gofast.BasicSession),
-			gofast.SimpleClientFactory(domain.connFactory),
+			domain.upstream.CreateClient,
 		)
 	} else {
-		// 1. conn addr
-		domain.connFactory = gofast.SimpleConnFactory(defaultNetwork, addr)
-		// domain.clientPool = gofast.NewClientPool(gofast.SimpleClientFactory(domain.connFactory), 3, domain.timeout)
 		// 2. root path dir
 		domain.caller = gofast.NewHandler(
 			gofast.NewPHPFS(root)(gofast.BasicSession),
-			gofast.SimpleClientFactory(domain.connFactory),
+			domain.upstream.CreateClient,
 		)
 	}
 	domain.caller.SetLogger(domain.getLogger())
-	// 设置超时时长
-
 	return nil
 }
 
+// 复制处理器 [共享配置及连接池, 独立处理上下文 Cancel 不影响其他请求]
+func (domain *PHPFastCgiDomainImpl) fork() *PHPFastCgiDomainImpl {
+	var handler = *domain
+	handler.ctx, handler.cancel = context.WithCancel(context.Background())
+	return &handler
+}
+
+// 归还连接池前重建处理上下文 [已 Cancel 的处理器可复用]
 func (domain *PHPFastCgiDomainImpl) reset() *PHPFastCgiDomainImpl {
-	domain.ctx, domain.cancel = context.WithTimeout(context.Background(), defaultTimeout)
+	domain.cancel()
+	domain.ctx, domain.cancel = context.WithCancel(context.Background())
 	return domain
 }
 
@@ -123,6 +139,7 @@ func (domain *PHPFastCgiDomainImpl) Register(pool *sync.Pool) {
 	pool.Put(domain.reset())
 }
 
+// Cancel 取消进行中的请求
 func (domain *PHPFastCgiDomainImpl) Cancel() {
 	domain.cancel()
 }
@@ -186,6 +203,8 @@ func (domain *PHPFastCgiDomainImpl) fastCgiPass(res http.ResponseWriter, req *ht
 	if domain.caller == nil {
 		return errors.New("fastcgi provider missed")
 	}
+	var cancel = domain.withDeadline(&req)
+	defer cancel()
 	domain.caller.ServeHTTP(res, req)
 	return nil
 }
@@ -194,10 +213,35 @@ func (domain *PHPFastCgiDomainImpl) Proxy(res http.ResponseWriter, req *http.Req
 	if !domain.Parsed() {
 		return errors.New("not parsed fastcgi handler")
 	}
+	var cancel = domain.withDeadline(&req)
+	defer cancel()
 	domain.caller.ServeHTTP(res, req)
 	return nil
 }
 
+// 单次请求超时 [超时或 Cancel 时中断读取, 连接不再复用]
+func (domain *PHPFastCgiDomainImpl) withDeadline(req **http.Request) context.CancelFunc {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		parent = domain.ctx
+	)
+	if domain.timeout > 0 {
+		ctx, cancel = context.WithTimeout((*req).Context(), domain.timeout)
+	} else {
+		ctx, cancel = context.WithCancel((*req).Context())
+	}
+	go func() {
+		select {
+		case <-parent.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	*req = (*req).WithContext(ctx)
+	return cancel
+}
+
 func (domain *PHPFastCgiDomainImpl) getLogger() *log.Logger {
 	if domain.logger != nil {
 		return log.New(domain.logger.Out, domain.getName(), log.LstdFlags)
@@ -217,7 +261,66 @@ func (domain *PHPFastCgiDomainImpl) getName() string {
 	return domain.addr
 }
 
+// SetTimeout 设置单次请求超时
 func (domain *PHPFastCgiDomainImpl) SetTimeout(duration time.Duration) {
 	domain.timeout = duration
-	domain.ctx, domain.cancel = context.WithTimeout(context.Background(), duration)
+}
+
+// 解析 upstream 地址 [unix:/path|/path 为 unix socket]
+func parseFastCgiAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, "unix:") || strings.HasPrefix(addr, "/") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	return defaultNetwork, addr
+}
+
+// 获取 upstream [单地址连接池 或 多地址负载均衡组]
+// {"fastcgi_pass":["10.0.0.1:9000|3","10.0.0.2:9000|1"],"fastcgi_balance":"round_robin|least_conn","fastcgi_max_fails":1,"fastcgi_fail_timeout":"10s"}
+// {"fastcgi_max_idle":4,"fastcgi_max_open":32,"fastcgi_idle_timeout":"1m","ping.path":"/ping","ping.interval":"10s"}
+func getFastCgiUpstream(params entity.KvMap) (facede.FastCgiUpstream, error) {
+	var (
+		passes  = params.GetArr(ParamFastCgiPass)
+		ping    = params.GetKvMap("ping")
+		options = repo.FastCgiPoolOptions{
+			MaxIdle:      params.GetInt(ParamFastCgiMaxIdle),
+			MaxOpen:      params.GetInt(ParamFastCgiMaxOpen),
+			IdleTimeout:  params.GetDuration(ParamFastCgiIdleTime),
+			PingPath:     params.GetStr(ParamFastCgiPingPath, ping.GetStr("path")),
+			PingInterval: params.GetDuration(ParamFastCgiPingTime, ping.GetDuration("interval")),
+		}
+		members []repo.FastCgiMember
+	)
+	for _, pass := range passes {
+		var (
+			weight = 1
+			arr    = strings.SplitN(strings.TrimSpace(pass), "|", 2)
+		)
+		if arr[0] == "" {
+			continue
+		}
+		if len(arr) == 2 {
+			n, err := strconv.Atoi(strings.TrimSpace(arr[1]))
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid fastcgi_pass weight: " + pass)
+			}
+			weight = n
+		}
+		network, addr := parseFastCgiAddr(arr[0])
+		members = append(members, repo.FastCgiMember{Network: network, Addr: addr, Weight: weight})
+	}
+	if len(members) == 0 {
+		return nil, errors.New("miss param: " + ParamFastCgiPass)
+	}
+	if len(members) == 1 {
+		pool, err := repo.GetFastCgiPoolRepo().Get(members[0].Network, members[0].Addr, options)
+		if err != nil {
+			return nil, err
+		}
+		return pool, nil
+	}
+	return repo.GetFastCgiPoolRepo().Group(members, repo.FastCgiGroupOptions{
+		Balance:     params.GetStr(ParamFastCgiBalance),
+		MaxFails:    params.GetInt(ParamFastCgiMaxFails),
+		FailTimeout: params.GetDuration(ParamFastCgiFailTime),
+	}, options)
 }
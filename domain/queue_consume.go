@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+)
+
+// 支持绑定分发的队列驱动
+type queueBinder interface {
+	Consume(queue string, bindings ...*repo.QueueBinding) error
+}
+
+var (
+	_ queueBinder = (*repo.RabbitmqUtils)(nil)
+	_ queueBinder = (*repo.RedisStreamQueue)(nil)
+)
+
+// CreateQueueBindings 按队列消费配置创建绑定 [消费器取绑定 type 及 properties, 过滤、重试、去重取绑定配置]
+func CreateQueueBindings(consume *entity.QueueConsume) ([]*repo.QueueBinding, error) {
+	if len(consume.Bindings) == 0 {
+		return nil, entity.ErrorQueueBindingsEmpty
+	}
+	var bindings = make([]*repo.QueueBinding, 0, len(consume.Bindings))
+	for _, item := range consume.Bindings {
+		var name = item.Consumer.Name
+		consumer, err := GetConsumerDomain().Create(item.Consumer.Type, []byte(item.Consumer.Properties))
+		if err != nil {
+			return nil, fmt.Errorf("binding %s: %v", name, err)
+		}
+		policy, err := item.Bind.RetryPolicy()
+		if err != nil {
+			return nil, fmt.Errorf("binding %s: %v", name, err)
+		}
+		binding, err := CreateBinding(consume.Queue, name, item.Bind.Filter(), consumer, policy)
+		if err != nil {
+			return nil, err
+		}
+		if window, by := item.Bind.Dedup(); window > 0 {
+			if binding.Dedup, err = CreateDedup(window, by); err != nil {
+				return nil, fmt.Errorf("binding %s: %v", name, err)
+			}
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
+
+// ConsumeQueue 按队列消费配置消费 [阻塞至停止消费]
+// 先设置死信队列再声明队列; 支持绑定分发的驱动按绑定消费, 其余驱动仅支持单个绑定 [过滤、超时、去重、重试策略及死信同绑定分发]
+func ConsumeQueue(entry facede.QueueEntry, consume *entity.QueueConsume) error {
+	if err := SetDeadLetter(consume.Queue); err != nil {
+		return err
+	}
+	bindings, err := CreateQueueBindings(consume)
+	if err != nil {
+		return err
+	}
+	var queue = consume.Queue.Name
+	if binder, ok := entry.(queueBinder); ok {
+		return binder.Consume(queue, bindings...)
+	}
+	if len(bindings) != 1 {
+		return fmt.Errorf("queue %s: %w, driver supports single binding only", queue, entity.ErrorSupport)
+	}
+	if err = entry.QueueDeclare(queue); err != nil {
+		return err
+	}
+	// 配置去重窗口时 窗口内重复消息确认; 配置重试策略时 重试结果按策略延迟重投, 重试用尽或丢弃时投递死信队列
+	var binding = bindings[0]
+	if binding.Dedup != nil {
+		binding.Consumer = WithDedup(binding.Consumer, binding.Dedup, binding.Name)
+	}
+	binding.Consumer = WithRetryPolicy(binding.Consumer, entry, binding.Retry)
+	return entry.Pop(CreateConsumeHandler(binding), queue)
+}
@@ -0,0 +1,181 @@
+package domain
+
+import (
+	"errors"
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按绑定分发消费 [投递预置消息, 记录应答、投递及延迟投递]
+type consumeTestEntry struct {
+	facede.QueueEntry
+	locker   sync.Mutex
+	messages []*entity.QueueMessage
+	replies  []entity.ConsumeAction
+	pushed   map[string][]*entity.QueueMessage
+	delayed  []time.Duration
+	callback func(broker rabbitmq.MessageWrapper)
+}
+
+func (entry *consumeTestEntry) Consume(queue string, bindings ...*repo.QueueBinding) error {
+	var fanout = repo.NewQueueFanout(queue, entry)
+	defer fanout.Close()
+	if err := fanout.Bind(bindings...); err != nil {
+		return err
+	}
+	var done = make(chan entity.ConsumeAction, len(entry.messages))
+	for _, msg := range entry.messages {
+		fanout.Dispatch(msg, func(action entity.ConsumeAction) error {
+			done <- action
+			return nil
+		})
+	}
+	for range entry.messages {
+		select {
+		case action := <-done:
+			entry.replies = append(entry.replies, action)
+		case <-time.After(3 * time.Second):
+			return errors.New("consume timeout")
+		}
+	}
+	return nil
+}
+
+func (entry *consumeTestEntry) Push(data interface{}, queue ...string) error {
+	entry.locker.Lock()
+	defer entry.locker.Unlock()
+	entry.pushed[queue[0]] = append(entry.pushed[queue[0]], data.(*entity.QueueMessage))
+	return nil
+}
+
+func (entry *consumeTestEntry) PushDelay(msg *entity.QueueMessage, delay time.Duration, queue string) error {
+	entry.locker.Lock()
+	entry.delayed = append(entry.delayed, delay)
+	entry.locker.Unlock()
+	return entry.Push(msg, queue)
+}
+
+func (entry *consumeTestEntry) QueueDeclare(string, ...func(params interface{})) error {
+	return nil
+}
+
+func (entry *consumeTestEntry) Pop(callback func(broker rabbitmq.MessageWrapper), _ ...string) error {
+	entry.callback = callback
+	return nil
+}
+
+func TestConsumeQueue(t *testing.T) {
+	var (
+		ok = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		unavailable = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		queue = "consume_test_orders"
+		kv    = entity.KvMap{}
+	)
+	defer ok.Close()
+	defer unavailable.Close()
+	defer repo.SetDeadLetterQueue(queue, "")
+
+	// app.yml queues.<name> 配置
+	if err := utils.JsonDecode([]byte(`{
+		"type": "amqp",
+		"consumer_max_num": 2,
+		"properties": {"dead_letter_queue": "consume_test_orders.dead", "consume_timeout": "2s"},
+		"bindings": {
+			"orders": {"type": "api", "filter": "headers.type == \"order\"", "properties": {"url": "`+ok.URL+`"}},
+			"audit": {"type": "api", "retry": {"max_attempts": 1, "initial_delay": "2s", "jitter": 0}, "properties": {"url": "`+unavailable.URL+`"}}
+		}
+	}`), &kv); err != nil {
+		t.Fatal(err)
+	}
+	consume, err := entity.QueueConsumeOf(queue, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry = &consumeTestEntry{
+		pushed: make(map[string][]*entity.QueueMessage),
+		messages: []*entity.QueueMessage{
+			entity.NewQueueMessage(queue, []byte(`{}`), entity.KvMap{"type": "order"}),
+			entity.NewQueueMessage(queue, []byte(`{}`), entity.KvMap{"type": "refund", entity.HeaderRetryAttempt: 1}),
+		},
+	}
+	if err = ConsumeQueue(entry, consume); err != nil {
+		t.Fatal(err)
+	}
+	if repo.DeadLetterQueueOf(queue) != queue+".dead" {
+		t.Fatalf("dead letter queue %q not set", repo.DeadLetterQueueOf(queue))
+	}
+	for _, action := range entry.replies {
+		if action != entity.ConsumeAck {
+			t.Fatalf("expect ack replies, got %v", entry.replies)
+		}
+	}
+
+	// audit 首次失败 延迟重投, 订单绑定已确认
+	var retried = entry.pushed[queue]
+	if len(retried) != 1 || retried[0].Headers[repo.HeaderFanoutTarget] != "audit" || len(entry.delayed) != 1 || entry.delayed[0] != 2*time.Second {
+		t.Fatalf("expect one delayed retry to audit, got %d %v", len(retried), entry.delayed)
+	}
+	// 重试用尽 定向投递死信队列
+	var letters = entry.pushed[queue+".dead"]
+	if len(letters) != 1 || letters[0].Headers[repo.HeaderFanoutTarget] != "audit" || letters[0].Headers[entity.HeaderDeadLetterQueue] != queue {
+		t.Fatalf("expect one dead letter to audit, got %d", len(letters))
+	}
+
+	// 不支持绑定分发的驱动 仅单个绑定
+	var single = &struct{ facede.QueueEntry }{entry}
+	if err = ConsumeQueue(single, consume); !errors.Is(err, entity.ErrorSupport) {
+		t.Fatalf("expect unsupported multiple bindings, got %v", err)
+	}
+	consume.Bindings = consume.Bindings[:1]
+	if err = ConsumeQueue(single, consume); err != nil || entry.callback == nil {
+		t.Fatalf("expect single binding pop, got %v", err)
+	}
+	// 单个绑定 重试结果按策略延迟重投 [驱动不支持延迟投递 进入内部延迟队列]
+	entry.callback(entity.NewQueueMessage(queue, []byte(`{}`), entity.KvMap{"type": "refund"}))
+	if n := repo.GetDelayStore().Len(); n != 1 {
+		t.Fatalf("expect delayed retry on single binding, got %d", n)
+	}
+	repo.GetDelayStore().Flush()
+	retried = entry.pushed[queue]
+	if len(retried) != 2 || entity.RetryAttempt(retried[1]) != 1 || retried[1].Headers.GetInt(entity.HeaderRetryDelay) != 2000 {
+		t.Fatalf("expect retry pushed to %s, got %d", queue, len(retried))
+	}
+	// 重试用尽 投递死信队列 [按重新读取的消息处理]
+	entry.callback(entity.NewQueueMessage(queue, retried[1].Body, retried[1].Headers))
+	letters = entry.pushed[queue+".dead"]
+	if len(letters) != 2 || letters[1].Headers[entity.HeaderDeadLetterQueue] != queue || repo.GetDelayStore().Len() != 0 {
+		t.Fatalf("expect dead letter on single binding, got %d", len(letters))
+	}
+	// 未配置重试策略 丢弃的消息投递死信队列
+	var rejected = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejected.Close()
+	consume.Bindings[0].Consumer.Properties = `{"url":"` + rejected.URL + `"}`
+	consume.Bindings[0].Bind.Properties = ""
+	if err = ConsumeQueue(single, consume); err != nil {
+		t.Fatal(err)
+	}
+	entry.callback(entity.NewQueueMessage(queue, []byte(`{}`)))
+	if letters = entry.pushed[queue+".dead"]; len(letters) != 3 {
+		t.Fatalf("expect dropped message dead lettered, got %d", len(letters))
+	}
+
+	// 配置错误
+	consume.Bindings[0].Consumer.Type = "unknown"
+	if err = ConsumeQueue(entry, consume); err == nil {
+		t.Fatal("expect unknown consumer type error")
+	}
+}
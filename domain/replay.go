@@ -0,0 +1,416 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/models"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// 消费失败记录重放 [全局限速, 批量重放后台运行]
+	replayDomainImpl struct {
+		safe    sync.RWMutex
+		jobs    map[string]*replayJob
+		limiter *replayLimiter
+	}
+
+	// 批量重放任务
+	replayJob struct {
+		locker   sync.RWMutex
+		progress entity.ReplayProgress
+		records  []*models.QueueFails
+		ctx      context.Context
+		cancel   context.CancelFunc
+	}
+
+	// 重放限速 [按最小间隔放行]
+	replayLimiter struct {
+		locker   sync.Mutex
+		interval time.Duration
+		next     time.Time
+	}
+
+	// 失败记录中的消息
+	replayPayload struct {
+		Binding     string          `json:"binding"`
+		ContentType string          `json:"content_type"`
+		Headers     entity.KvMap    `json:"headers"`
+		Body        json.RawMessage `json:"body"`
+		Encoding    string          `json:"body_encoding"`
+	}
+)
+
+const (
+	// EnvFailReplayRate 全局每秒重放条数
+	EnvFailReplayRate = "QUEUE_FAILS_REPLAY_RATE"
+	// HeaderReplayOf 重放来源失败记录 ID
+	HeaderReplayOf        = "x-replay-of"
+	defaultFailReplayRate = 10
+	defaultReplayDriver   = repo.DriverAmqp
+)
+
+var (
+	replayDefault       *replayDomainImpl
+	replayDefaultMutex  sync.Mutex
+	ErrorReplayFinished = errors.New("replay job already finished")
+	// 重放时移除的消息头 [重新计算重试次数, 清除死信及定向信息]
+	replayDropHeaders = []string{
+		entity.HeaderRetryAttempt,
+		entity.HeaderRetryDelay,
+		entity.HeaderDeliveryCount,
+		entity.HeaderDeadLetterQueue,
+		entity.HeaderDeadLetterError,
+		entity.HeaderDeadLetterAttempts,
+		entity.HeaderDeadLetterTime,
+		repo.HeaderFanoutTarget,
+	}
+)
+
+func NewReplayDomain() *replayDomainImpl {
+	var domain = new(replayDomainImpl)
+	return domain.init()
+}
+
+// GetReplayDomain 获取消费失败记录重放
+func GetReplayDomain() *replayDomainImpl {
+	replayDefaultMutex.Lock()
+	defer replayDefaultMutex.Unlock()
+	if replayDefault == nil {
+		replayDefault = NewReplayDomain()
+	}
+	return replayDefault
+}
+
+func (domain *replayDomainImpl) init() *replayDomainImpl {
+	domain.safe = sync.RWMutex{}
+	domain.jobs = make(map[string]*replayJob)
+	domain.limiter = newReplayLimiter(utils.GetEnvInt(EnvFailReplayRate, defaultFailReplayRate))
+	return domain
+}
+
+// List 查询失败记录
+func (domain *replayDomainImpl) List(params *entity.FailQueryParams) ([]*models.QueueFails, int64, error) {
+	if params == nil {
+		return nil, 0, entity.ErrorRequired
+	}
+	return new(models.QueueFails).List(params)
+}
+
+// Mark 标记失败记录 [已解决|已忽略]
+func (domain *replayDomainImpl) Mark(params *entity.FailMarkParams) (int64, error) {
+	if params == nil {
+		return 0, entity.ErrorRequired
+	}
+	if err := params.Verify(); err != nil {
+		return 0, err
+	}
+	status, _ := models.QueueFailStatusOf(params.Status)
+	return new(models.QueueFails).MarkStatus(params.IDs, status)
+}
+
+// ReplayOne 重放单条记录 [同步投递, 结果写回记录]
+func (domain *replayDomainImpl) ReplayOne(id uint, params *entity.FailReplayParams) (*models.QueueFails, error) {
+	if params == nil {
+		params = new(entity.FailReplayParams)
+	}
+	var record = new(models.QueueFails)
+	if err := record.Get(id); err != nil {
+		return nil, err
+	}
+	if err := domain.limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+	return record, domain.replay(record, params)
+}
+
+// Replay 批量重放 [后台运行, 返回进度]
+func (domain *replayDomainImpl) Replay(params *entity.FailReplayParams) (*entity.ReplayProgress, error) {
+	if params == nil {
+		return nil, entity.ErrorRequired
+	}
+	if err := params.Verify(); err != nil {
+		return nil, err
+	}
+	records, err := domain.collect(params)
+	if err != nil {
+		return nil, err
+	}
+	var job = &replayJob{
+		records: records,
+		progress: entity.ReplayProgress{
+			ID:      fmt.Sprintf("replay-%d", time.Now().UnixNano()),
+			State:   entity.ReplayRunning,
+			Params:  params,
+			Total:   len(records),
+			StartAt: time.Now(),
+		},
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	if err = repo.GetPoolRepo().Add(func() {
+		job.run(domain)
+	}); err != nil {
+		job.cancel()
+		return nil, err
+	}
+	domain.safe.Lock()
+	domain.jobs[job.progress.ID] = job
+	domain.safe.Unlock()
+	return job.Progress(), nil
+}
+
+// Get 查询批量重放进度
+func (domain *replayDomainImpl) Get(id string) (*entity.ReplayProgress, bool) {
+	domain.safe.RLock()
+	defer domain.safe.RUnlock()
+	if job, ok := domain.jobs[id]; ok {
+		return job.Progress(), true
+	}
+	return nil, false
+}
+
+// Jobs 罗列批量重放任务 [按启动时间倒序]
+func (domain *replayDomainImpl) Jobs() []*entity.ReplayProgress {
+	domain.safe.RLock()
+	var items = make([]*entity.ReplayProgress, 0, len(domain.jobs))
+	for _, job := range domain.jobs {
+		items = append(items, job.Progress())
+	}
+	domain.safe.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartAt.After(items[j].StartAt)
+	})
+	return items
+}
+
+// Cancel 取消批量重放
+func (domain *replayDomainImpl) Cancel(id string) (*entity.ReplayProgress, error) {
+	domain.safe.RLock()
+	job, ok := domain.jobs[id]
+	domain.safe.RUnlock()
+	if !ok {
+		return nil, entity.ErrorEmpty
+	}
+	if job.Progress().Finished() {
+		return nil, ErrorReplayFinished
+	}
+	job.cancel()
+	return job.Progress(), nil
+}
+
+// 待重放记录 [按 ID 或过滤条件, 不超过 max]
+func (domain *replayDomainImpl) collect(params *entity.FailReplayParams) ([]*models.QueueFails, error) {
+	var records []*models.QueueFails
+	for _, id := range params.IDs {
+		if len(records) >= params.Max {
+			return records, nil
+		}
+		var record = new(models.QueueFails)
+		if err := record.Get(id); err != nil {
+			return nil, fmt.Errorf("fails %d: %w", id, err)
+		}
+		records = append(records, record)
+	}
+	if params.Filter == nil || len(records) >= params.Max {
+		return records, nil
+	}
+	var filter = *params.Filter
+	filter.Page, filter.Count = 1, params.Max-len(records)
+	items, _, err := new(models.QueueFails).List(&filter)
+	if err != nil {
+		return nil, err
+	}
+	return append(records, items...), nil
+}
+
+// 重放记录 [投递结果写回记录]
+func (domain *replayDomainImpl) replay(record *models.QueueFails, params *entity.FailReplayParams) error {
+	var (
+		queue  = params.Target
+		driver = params.Driver
+	)
+	if queue == "" {
+		queue = record.Queue
+	}
+	if driver == "" {
+		driver = strings.ToUpper(record.Type)
+	}
+	if driver == "" {
+		driver = defaultReplayDriver
+	}
+	var err = domain.push(record, driver, queue)
+	if e := record.SaveReplay(queue, err); e != nil {
+		repo.GetLogger("consumer").WithFields(log.Fields{"id": record.ID, "queue": queue}).
+			Errorln("queue fails replay save error:", e)
+		if err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (domain *replayDomainImpl) push(record *models.QueueFails, driver, queue string) error {
+	entry, err := repo.GetQueueDriverRepo().Get(driver)
+	if err != nil {
+		return err
+	}
+	msg, err := replayMessageOf(record, queue)
+	if err != nil {
+		return err
+	}
+	return entry.Push(msg, queue)
+}
+
+// 失败记录还原消息 [脱敏字段按脱敏后内容重放]
+func replayMessageOf(record *models.QueueFails, queue string) (*entity.QueueMessage, error) {
+	var payload replayPayload
+	if err := json.Unmarshal([]byte(record.Payloads), &payload); err != nil {
+		return nil, fmt.Errorf("fails %d payloads: %v", record.ID, err)
+	}
+	body, err := payload.body()
+	if err != nil {
+		return nil, fmt.Errorf("fails %d body: %v", record.ID, err)
+	}
+	var msg = entity.NewQueueMessage(queue, body, payload.Headers.Copy())
+	for _, key := range replayDropHeaders {
+		delete(msg.Headers, key)
+	}
+	// 原队列重放 只投递给失败的绑定
+	if payload.Binding != "" && queue == record.Queue {
+		msg.Headers[repo.HeaderFanoutTarget] = payload.Binding
+	}
+	msg.Headers[HeaderReplayOf] = int64(record.ID)
+	msg.ContentType = payload.ContentType
+	return msg, nil
+}
+
+func (payload *replayPayload) body() ([]byte, error) {
+	var raw = bytes.TrimSpace(payload.Body)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return []byte{}, nil
+	}
+	if raw[0] != '"' {
+		return raw, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return nil, err
+	}
+	if payload.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func (job *replayJob) run(domain *replayDomainImpl) {
+	var limiter <-chan time.Time
+	// 任务限速 [与全局限速同时生效]
+	if rate := job.progress.Params.Rate; rate > 0 {
+		var ticker = time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+	for _, record := range job.records {
+		if limiter != nil {
+			select {
+			case <-job.ctx.Done():
+				job.finish(job.ctx.Err())
+				return
+			case <-limiter:
+			}
+		}
+		if err := domain.limiter.Wait(job.ctx); err != nil {
+			job.finish(err)
+			return
+		}
+		job.incr(domain.replay(record, job.progress.Params) == nil)
+	}
+	job.finish(nil)
+}
+
+// Progress 进度快照
+func (job *replayJob) Progress() *entity.ReplayProgress {
+	job.locker.RLock()
+	defer job.locker.RUnlock()
+	var progress = job.progress
+	return &progress
+}
+
+func (job *replayJob) incr(replayed bool) {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+	if replayed {
+		job.progress.Replayed++
+	} else {
+		job.progress.Failed++
+	}
+}
+
+func (job *replayJob) finish(err error) {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+	var now = time.Now()
+	job.progress.EndAt = &now
+	switch {
+	case err == nil:
+		job.progress.State = entity.ReplayCompleted
+	case err == context.Canceled:
+		job.progress.State = entity.ReplayCancelled
+	default:
+		job.progress.State = entity.ReplayFailed
+		job.progress.Error = err.Error()
+	}
+	job.cancel()
+	repo.GetLogger("consumer").WithFields(log.Fields{
+		"id":       job.progress.ID,
+		"state":    job.progress.State,
+		"replayed": job.progress.Replayed,
+		"failed":   job.progress.Failed,
+	}).Infoln("queue fails replay finished")
+}
+
+// rate: 每秒放行数 [<=0 不限速]
+func newReplayLimiter(rate int) *replayLimiter {
+	var limiter = new(replayLimiter)
+	if rate > 0 {
+		limiter.interval = time.Second / time.Duration(rate)
+	}
+	return limiter
+}
+
+// Wait 等待放行
+func (limiter *replayLimiter) Wait(ctx context.Context) error {
+	if limiter.interval <= 0 {
+		return ctx.Err()
+	}
+	limiter.locker.Lock()
+	var now = time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+	var wait = limiter.next.Sub(now)
+	limiter.next = limiter.next.Add(limiter.interval)
+	limiter.locker.Unlock()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	var timer = time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/models"
+	"github.com/weblfe/queue_mgr/repo"
+	"testing"
+	"time"
+)
+
+func TestReplayMessageOf(t *testing.T) {
+	var (
+		failure = NewFailureDomain().SetRedactFields("password")
+		msg     = entity.NewQueueMessage("orders", []byte(`{"sku":"a1","password":"x"}`), entity.KvMap{
+			"trace":                   "t1",
+			entity.HeaderRetryAttempt: 5,
+			repo.HeaderFanoutTarget:   "audit",
+		})
+	)
+	msg.ContentType = "application/json"
+	var record = failure.Build(&entity.ConsumeFailure{Queue: "orders", Binding: "audit", Action: entity.ConsumeDrop, Message: msg})
+	record.ID = 7
+	replay, err := replayMessageOf(record, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(replay.Body) != `{"password":"******","sku":"a1"}` || replay.ContentType != "application/json" {
+		t.Errorf("replayMessageOf body %s", replay.Body)
+	}
+	if replay.Headers.GetStr("trace") != "t1" || replay.Headers.GetStr(repo.HeaderFanoutTarget) != "audit" ||
+		replay.Headers.GetInt(HeaderReplayOf) != 7 || entity.RetryAttempt(replay) != 0 {
+		t.Errorf("replayMessageOf headers %v", replay.Headers)
+	}
+	// 投递其他队列 不定向绑定
+	if replay, _ = replayMessageOf(record, "orders.debug"); replay.Headers.GetStr(repo.HeaderFanoutTarget) != "" {
+		t.Errorf("replayMessageOf target queue headers %v", replay.Headers)
+	}
+	// 非 utf8 消息体
+	msg.Body = []byte{0xff, 0xfe, 0x01}
+	if replay, err = replayMessageOf(failure.Build(&entity.ConsumeFailure{Message: msg}), "orders"); err != nil || string(replay.Body) != string(msg.Body) {
+		t.Errorf("replayMessageOf binary body %v, %v", replay, err)
+	}
+	if _, err = replayMessageOf(&models.QueueFails{Payloads: "{"}, "orders"); err == nil {
+		t.Error("replayMessageOf invalid payloads expect error")
+	}
+}
+
+func TestReplayLimiter_Wait(t *testing.T) {
+	var (
+		limiter = newReplayLimiter(50)
+		start   = time.Now()
+	)
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("replayLimiter 3 waits at 50/s took %v", elapsed)
+	}
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx); err == nil {
+		t.Error("replayLimiter cancelled context expect error")
+	}
+}
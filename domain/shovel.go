@@ -0,0 +1,275 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// 队列消息转移任务管理
+	shovelDomainImpl struct {
+		safe    sync.RWMutex
+		jobs    map[string]*shovelJob
+		ttl     time.Duration
+		history int
+	}
+
+	// 队列消息转移任务
+	shovelJob struct {
+		locker   sync.RWMutex
+		progress entity.ShovelProgress
+		ctx      context.Context
+		cancel   context.CancelFunc
+		source   facede.QueueEntry
+		target   facede.QueueEntry
+	}
+)
+
+const (
+	// EnvShovelTTL 已结束任务保留时长
+	EnvShovelTTL = "QUEUE_SHOVEL_TTL"
+	// EnvShovelHistory 已结束任务最多保留数 [超出淘汰最早结束的任务]
+	EnvShovelHistory     = "QUEUE_SHOVEL_HISTORY"
+	defaultShovelTTL     = 24 * time.Hour
+	defaultShovelHistory = 100
+)
+
+var (
+	shovelDefault       *shovelDomainImpl
+	shovelDefaultMutex  sync.Mutex
+	ErrorShovelFinished = errors.New("shovel job already finished")
+	errorShovelDone     = errors.New("shovel max reached")
+)
+
+func NewShovelDomain() *shovelDomainImpl {
+	var domain = new(shovelDomainImpl)
+	return domain.init()
+}
+
+func GetShovelDomain() *shovelDomainImpl {
+	shovelDefaultMutex.Lock()
+	defer shovelDefaultMutex.Unlock()
+	if shovelDefault == nil {
+		shovelDefault = NewShovelDomain()
+	}
+	return shovelDefault
+}
+
+func (domain *shovelDomainImpl) init() *shovelDomainImpl {
+	domain.safe = sync.RWMutex{}
+	domain.jobs = make(map[string]*shovelJob)
+	domain.ttl = utils.GetEnvDuration(EnvShovelTTL, defaultShovelTTL)
+	domain.history = utils.GetEnvInt(EnvShovelHistory, defaultShovelHistory)
+	return domain
+}
+
+// Start 创建并后台运行转移任务
+func (domain *shovelDomainImpl) Start(params *entity.ShovelParams) (*entity.ShovelProgress, error) {
+	if params == nil {
+		return nil, entity.ErrorRequired
+	}
+	if err := params.Verify(); err != nil {
+		return nil, err
+	}
+	var drivers = repo.GetQueueDriverRepo()
+	source, err := drivers.Get(params.SourceDriver)
+	if err != nil {
+		return nil, err
+	}
+	target, err := drivers.Get(params.TargetDriver)
+	if err != nil {
+		return nil, err
+	}
+	total, err := source.Len(params.Source)
+	if err != nil {
+		return nil, err
+	}
+	if err = target.QueueDeclare(params.Target); err != nil {
+		return nil, err
+	}
+	var job = &shovelJob{
+		source: source,
+		target: target,
+		progress: entity.ShovelProgress{
+			ID:      fmt.Sprintf("%s-%d", params.Source, time.Now().UnixNano()),
+			State:   entity.ShovelRunning,
+			Params:  params,
+			Total:   total,
+			StartAt: time.Now(),
+		},
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	if err = repo.GetPoolRepo().Add(job.run); err != nil {
+		job.cancel()
+		return nil, err
+	}
+	domain.safe.Lock()
+	domain.evict(time.Now())
+	domain.jobs[job.progress.ID] = job
+	domain.safe.Unlock()
+	return job.Progress(), nil
+}
+
+// Get 查询转移任务进度
+func (domain *shovelDomainImpl) Get(id string) (*entity.ShovelProgress, bool) {
+	domain.safe.Lock()
+	defer domain.safe.Unlock()
+	domain.evict(time.Now())
+	if job, ok := domain.jobs[id]; ok {
+		return job.Progress(), true
+	}
+	return nil, false
+}
+
+// List 罗列转移任务 [按启动时间倒序]
+func (domain *shovelDomainImpl) List() []*entity.ShovelProgress {
+	domain.safe.Lock()
+	domain.evict(time.Now())
+	domain.safe.Unlock()
+	domain.safe.RLock()
+	var items = make([]*entity.ShovelProgress, 0, len(domain.jobs))
+	for _, job := range domain.jobs {
+		items = append(items, job.Progress())
+	}
+	domain.safe.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartAt.After(items[j].StartAt)
+	})
+	return items
+}
+
+// Cancel 取消转移任务
+func (domain *shovelDomainImpl) Cancel(id string) (*entity.ShovelProgress, error) {
+	domain.safe.RLock()
+	job, ok := domain.jobs[id]
+	domain.safe.RUnlock()
+	if !ok {
+		return nil, entity.ErrorEmpty
+	}
+	if job.Progress().Finished() {
+		return nil, ErrorShovelFinished
+	}
+	job.cancel()
+	return job.Progress(), nil
+}
+
+// 淘汰已结束任务 [超过保留时长或超出保留数, 运行中任务不淘汰; 调用方持有写锁]
+func (domain *shovelDomainImpl) evict(now time.Time) {
+	var finished = make([]*entity.ShovelProgress, 0, len(domain.jobs))
+	for id, job := range domain.jobs {
+		var progress = job.Progress()
+		if !progress.Finished() || progress.EndAt == nil {
+			continue
+		}
+		if domain.ttl > 0 && now.Sub(*progress.EndAt) > domain.ttl {
+			delete(domain.jobs, id)
+			continue
+		}
+		finished = append(finished, progress)
+	}
+	if domain.history <= 0 || len(finished) <= domain.history {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].EndAt.Before(*finished[j].EndAt)
+	})
+	for _, progress := range finished[:len(finished)-domain.history] {
+		delete(domain.jobs, progress.ID)
+	}
+}
+
+// Progress 进度快照
+func (job *shovelJob) Progress() *entity.ShovelProgress {
+	job.locker.RLock()
+	defer job.locker.RUnlock()
+	var progress = job.progress
+	return &progress
+}
+
+func (job *shovelJob) run() {
+	var (
+		params  = job.progress.Params
+		limiter <-chan time.Time
+	)
+	if job.progress.Total <= 0 {
+		job.finish(nil)
+		return
+	}
+	// 限速 [0:不限速]
+	if params.Rate > 0 {
+		var ticker = time.NewTicker(time.Second / time.Duration(params.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+	// 仅扫描启动时已有消息 跳过的消息不会被重复扫描
+	_, err := job.source.Take(job.progress.Total, func(msg *entity.QueueMessage) error {
+		if err := job.ctx.Err(); err != nil {
+			return err
+		}
+		// 已达上限 当前消息保留在源队列
+		if params.Max > 0 && job.Progress().Moved >= params.Max {
+			return errorShovelDone
+		}
+		if !params.Match(msg) {
+			job.incr(false)
+			return entity.ErrorMessageSkip
+		}
+		if limiter != nil {
+			select {
+			case <-job.ctx.Done():
+				return job.ctx.Err()
+			case <-limiter:
+			}
+		}
+		if err := job.target.Push(msg, params.Target); err != nil {
+			return err
+		}
+		job.incr(true)
+		return nil
+	}, params.Source)
+	job.finish(err)
+}
+
+// 累计进度
+func (job *shovelJob) incr(moved bool) {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+	job.progress.Scanned++
+	if moved {
+		job.progress.Moved++
+	} else {
+		job.progress.Skipped++
+	}
+}
+
+func (job *shovelJob) finish(err error) {
+	job.locker.Lock()
+	defer job.locker.Unlock()
+	var now = time.Now()
+	job.progress.EndAt = &now
+	switch {
+	case err == nil || err == errorShovelDone:
+		job.progress.State = entity.ShovelCompleted
+	case err == context.Canceled:
+		job.progress.State = entity.ShovelCancelled
+	default:
+		job.progress.State = entity.ShovelFailed
+		job.progress.Error = err.Error()
+	}
+	job.cancel()
+	repo.GetLogger("shovel").WithFields(log.Fields{
+		"id":      job.progress.ID,
+		"state":   job.progress.State,
+		"moved":   job.progress.Moved,
+		"skipped": job.progress.Skipped,
+	}).Infoln("shovel job finished")
+}
@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+	"time"
+)
+
+func TestShovelDomain_Evict(t *testing.T) {
+	var (
+		domain = NewShovelDomain()
+		now    = time.Now()
+	)
+	domain.ttl, domain.history = time.Hour, 2
+	var add = func(id string, state entity.ShovelState, end time.Duration) {
+		var job = &shovelJob{progress: entity.ShovelProgress{ID: id, State: state, StartAt: now.Add(-2 * time.Hour)}}
+		job.ctx, job.cancel = context.WithCancel(context.Background())
+		if state != entity.ShovelRunning {
+			var at = now.Add(-end)
+			job.progress.EndAt = &at
+		}
+		domain.jobs[id] = job
+	}
+	add("running", entity.ShovelRunning, 0)
+	add("expired", entity.ShovelCompleted, 2*time.Hour)
+	add("oldest", entity.ShovelFailed, 30*time.Minute)
+	add("older", entity.ShovelCancelled, 20*time.Minute)
+	add("latest", entity.ShovelCompleted, 10*time.Minute)
+
+	// 过期及超出保留数的已结束任务被淘汰 运行中任务保留
+	domain.evict(now)
+	for _, id := range []string{"running", "older", "latest"} {
+		if _, ok := domain.jobs[id]; !ok {
+			t.Fatalf("expect job %s kept", id)
+		}
+	}
+	if len(domain.jobs) != 3 {
+		t.Fatalf("expect 3 jobs kept, got %d", len(domain.jobs))
+	}
+
+	// 不限保留数
+	domain.history = 0
+	for i := 0; i < 5; i++ {
+		add(fmt.Sprintf("job-%d", i), entity.ShovelCompleted, time.Minute)
+	}
+	domain.evict(now)
+	if len(domain.jobs) != 8 {
+		t.Fatalf("expect 8 jobs kept, got %d", len(domain.jobs))
+	}
+}
@@ -69,6 +69,11 @@ func (kv KvMap) GetInt(key string, def ...int) int {
 			}
 		case int:
 			return v.(int)
+		case int64:
+			return int(v.(int64))
+		case float64:
+			// json 解码数值
+			return int(v.(float64))
 		case fmt.Stringer:
 			str := v.(fmt.Stringer).String()
 			if n, err := strconv.Atoi(str); err == nil {
@@ -0,0 +1,144 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/utils"
+	"sort"
+	"time"
+)
+
+type (
+	// QueueConsume 队列消费配置 [队列及其绑定的消费器]
+	QueueConsume struct {
+		Queue    QueueParams
+		Bindings []QueueConsumeBinding
+	}
+
+	// QueueConsumeBinding 队列绑定 [消费器类型及配置, 绑定过滤、重试、去重配置]
+	QueueConsumeBinding struct {
+		Consumer ConsumerParams
+		Bind     BindParams
+	}
+)
+
+const (
+	// PropertyBindingFilter 绑定配置 过滤表达式 [未配置匹配全部消息]
+	PropertyBindingFilter = "filter"
+	// PropertyBindingRetry 绑定配置 重试策略 [未配置时重试结果直接重新入队]
+	PropertyBindingRetry = "retry"
+	// PropertyBindingDedupWindow 绑定配置 去重窗口 [如 10m, 未配置不去重]
+	PropertyBindingDedupWindow = "dedup_window"
+	// PropertyBindingDedupBy 绑定配置 去重键 id|hash
+	PropertyBindingDedupBy = "dedup_by"
+
+	// 队列消费配置项
+	paramConsumeType       = "type"
+	paramConsumeMaxNum     = "consumer_max_num"
+	paramConsumeProperties = "properties"
+	paramConsumeBindings   = "bindings"
+)
+
+var (
+	ErrorQueueBindingsEmpty = errors.New("queue bindings empty")
+)
+
+// QueueConsumeOf 解析队列消费配置 [app.yml queues.<name>]
+// {"type":"amqp","consumer_max_num":4,"properties":{"dead_letter_queue":"orders.dead"},"bindings":{"php":{"type":"fastcgi","properties":{...},"filter":"...","retry":{...},"dedup_window":"10m"}}}
+func QueueConsumeOf(name string, kv KvMap) (*QueueConsume, error) {
+	if name == "" {
+		return nil, errors.New("queue name empty")
+	}
+	var consume = &QueueConsume{
+		Queue: QueueParams{
+			AppID:          utils.GetEnvVal("APP_ID"),
+			Status:         uint(Ready),
+			Type:           kv.GetStr(paramConsumeType),
+			Name:           name,
+			ConsumerMaxNum: uint(kv.GetInt(paramConsumeMaxNum)),
+			Properties:     propertiesOf(kv.GetKvMap(paramConsumeProperties)),
+		},
+	}
+	var bindings = kv.GetKvMap(paramConsumeBindings)
+	if len(bindings) == 0 {
+		return nil, ErrorQueueBindingsEmpty
+	}
+	var names = make([]string, 0, len(bindings))
+	for k := range bindings {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, binding := range names {
+		var item = bindings.GetKvMap(binding)
+		if item == nil {
+			return nil, fmt.Errorf("binding %s: invalid config", binding)
+		}
+		var settings = KvMap{}
+		for _, key := range []string{PropertyBindingFilter, PropertyBindingRetry, PropertyBindingDedupWindow, PropertyBindingDedupBy} {
+			if item.Exists(key) {
+				settings[key] = item.Get(key)
+			}
+		}
+		consume.Bindings = append(consume.Bindings, QueueConsumeBinding{
+			Consumer: ConsumerParams{
+				AppID:      consume.Queue.AppID,
+				Status:     uint(Ready),
+				Type:       item.GetStr(paramConsumeType),
+				Name:       binding,
+				Properties: utils.JsonEncode(item.GetKvMap(paramConsumeProperties, KvMap{})).String(),
+			},
+			Bind: BindParams{
+				AppID:      consume.Queue.AppID,
+				Queue:      name,
+				Consumer:   binding,
+				Status:     1,
+				Properties: utils.JsonEncode(settings).String(),
+			},
+		})
+	}
+	return consume, nil
+}
+
+// Filter 绑定过滤表达式
+func (params *BindParams) Filter() string {
+	return params.settings().GetStr(PropertyBindingFilter)
+}
+
+// RetryPolicy 绑定重试策略 [未配置返回 nil]
+func (params *BindParams) RetryPolicy() (*RetryPolicy, error) {
+	var settings = params.settings()
+	if !settings.Exists(PropertyBindingRetry) {
+		return nil, nil
+	}
+	return RetryPolicyOf(settings.GetKvMap(PropertyBindingRetry, KvMap{}))
+}
+
+// Dedup 绑定去重窗口及去重键 [未配置窗口为 0]
+func (params *BindParams) Dedup() (time.Duration, string) {
+	var settings = params.settings()
+	return settings.GetDuration(PropertyBindingDedupWindow), settings.GetStr(PropertyBindingDedupBy)
+}
+
+// 绑定配置
+func (params *BindParams) settings() KvMap {
+	var settings = KvMap{}
+	if params.Properties == "" {
+		return settings
+	}
+	if err := utils.JsonDecode([]byte(params.Properties), &settings); err != nil {
+		return KvMap{}
+	}
+	return settings
+}
+
+// 队列配置转换为 Properties json [值统一为字符串]
+func propertiesOf(kv KvMap) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var properties = make(map[string]string, len(kv))
+	for k, v := range kv {
+		properties[k] = fmt.Sprintf("%v", v)
+	}
+	return utils.JsonEncode(properties).String()
+}
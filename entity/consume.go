@@ -0,0 +1,68 @@
+package entity
+
+import "errors"
+
+type (
+	// ConsumeAction 消费结果处理动作
+	ConsumeAction int
+
+	// ConsumeFailure 消费失败信息 [处理错误, 非确认结果 或 异常]
+	ConsumeFailure struct {
+		AppID    string
+		Driver   string // amqp, redis
+		Queue    string
+		Consumer string // 消费器类型
+		Binding  string // 队列绑定名称
+		Action   ConsumeAction
+		Panic    bool
+		Stack    string
+		Err      error
+		Message  *QueueMessage
+	}
+)
+
+const (
+	// ConsumeAck 确认消费
+	ConsumeAck ConsumeAction = iota
+	// ConsumeRetry 重新入队重试
+	ConsumeRetry
+	// ConsumeDrop 丢弃 [配置死信交换机时进入死信队列]
+	ConsumeDrop
+)
+
+const (
+	ConsumerFastCGI  = "FastCGI"
+	ConsumerNative   = "Native"
+	ConsumerShell    = "Shell"
+	ConsumerApi      = "Api"
+	ConsumerGrpc     = "Grpc"
+	ConsumerProxy    = "Proxy"
+	ConsumerPlugins  = "Plugins"
+	ConsumerMysql    = "Mysql"
+	ConsumerFcm      = "Fcm"
+	ConsumerPipeline = "Pipeline"
+)
+
+func (action ConsumeAction) String() string {
+	switch action {
+	case ConsumeAck:
+		return "ack"
+	case ConsumeRetry:
+		return "retry"
+	case ConsumeDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// Errors 错误链 [外层在前]
+func (failure *ConsumeFailure) Errors() []string {
+	var chain []string
+	for err := failure.Err; err != nil; err = errors.Unwrap(err) {
+		chain = append(chain, err.Error())
+	}
+	if len(chain) == 0 && failure.Action != ConsumeAck {
+		chain = append(chain, "consume result "+failure.Action.String())
+	}
+	return chain
+}
@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"time"
+)
+
+const (
+	// PropertyDeadLetterQueue 队列配置 死信队列 [另一个受管队列]
+	PropertyDeadLetterQueue = "dead_letter_queue"
+	// HeaderDeadLetterQueue 死信来源队列
+	HeaderDeadLetterQueue = "x-dead-letter-queue"
+	// HeaderDeadLetterError 死信原因
+	HeaderDeadLetterError = "x-dead-letter-error"
+	// HeaderDeadLetterAttempts 死信前投递|重试次数
+	HeaderDeadLetterAttempts = "x-dead-letter-attempts"
+	// HeaderDeadLetterTime 进入死信时间 [RFC3339]
+	HeaderDeadLetterTime = "x-dead-letter-time"
+)
+
+// DeadLetterQueue 队列配置的死信队列 [未配置返回空]
+func (params *QueueParams) DeadLetterQueue() string {
+	if params.Properties == "" {
+		return ""
+	}
+	properties, err := ParseProperties(params.Properties)
+	if err != nil {
+		return ""
+	}
+	return properties.GetOr(PropertyDeadLetterQueue)
+}
+
+// DeadLetterOf 构建死信消息 [消息副本, 记录来源队列、原因及次数]
+func DeadLetterOf(msg *QueueMessage, queue string, reason error) *QueueMessage {
+	var (
+		letter   = msg.Clone()
+		attempts = RetryAttempt(msg)
+	)
+	if msg.Attempts > attempts {
+		attempts = msg.Attempts
+	}
+	if queue == "" {
+		queue = msg.Queue
+	}
+	letter.Headers[HeaderDeadLetterQueue] = queue
+	letter.Headers[HeaderDeadLetterAttempts] = attempts
+	letter.Headers[HeaderDeadLetterTime] = time.Now().Format(time.RFC3339)
+	if reason != nil {
+		letter.Headers[HeaderDeadLetterError] = reason.Error()
+	}
+	return letter
+}
@@ -15,6 +15,8 @@ var (
 	ErrorDecodeFailed  = errors.New("request param decode failed")
 	// ErrorSupport 未知支持类型
 	ErrorSupport = errors.New("unknown support type")
+	// ErrorMessageSkip 跳过消息 [消息保留在队列中]
+	ErrorMessageSkip = errors.New("message skipped")
 )
 
 func IsLoginError(err error) bool {
@@ -37,6 +39,10 @@ func IsDecodeFailError(err error) bool {
 		return err == ErrorDecodeFailed
 }
 
+func IsSkipError(err error) bool {
+	return err == ErrorMessageSkip
+}
+
 func IsSupportError(err error) bool {
 	return err == ErrorSupport
 }
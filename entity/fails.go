@@ -0,0 +1,230 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"time"
+)
+
+type (
+	ReplayState string
+
+	// FailQueryParams 消费失败记录查询参数
+	FailQueryParams struct {
+		AppID    string `query:"appid" json:"appid,omitempty"`
+		Queue    string `query:"queue" json:"queue,omitempty"`
+		Consumer string `query:"consumer" json:"consumer,omitempty"`
+		// 状态 failed,panic,resolved,ignored,replayed 逗号分隔
+		Status string `query:"status" json:"status,omitempty"`
+		// 异常信息包含内容
+		Error string `query:"error" json:"error,omitempty"`
+		// 创建时间范围 [unix 秒]
+		Since int64 `query:"since" json:"since,omitempty"`
+		Until int64 `query:"until" json:"until,omitempty"`
+		Page  int   `query:"page" json:"page,omitempty"`
+		Count int   `query:"count" json:"count,omitempty"`
+	}
+
+	// FailReplayParams 消费失败记录重放参数 [ids 与 filter 二选一]
+	FailReplayParams struct {
+		IDs    []uint           `json:"ids,omitempty"`
+		Filter *FailQueryParams `json:"filter,omitempty"`
+		// 目标队列 [为空时投递原队列]
+		Target string `json:"target,omitempty"`
+		// 目标队列驱动 amqp,redis [为空时使用记录的驱动]
+		Driver string `json:"driver,omitempty"`
+		// 每秒重放条数 0:使用全局限速
+		Rate int `json:"rate,omitempty"`
+		// 最大重放条数
+		Max int `json:"max,omitempty"`
+	}
+
+	// FailMarkParams 消费失败记录标记参数
+	FailMarkParams struct {
+		IDs []uint `json:"ids"`
+		// 状态 resolved,ignored
+		Status string `json:"status"`
+	}
+
+	// ReplayProgress 批量重放进度
+	ReplayProgress struct {
+		ID       string            `json:"id"`
+		State    ReplayState       `json:"state"`
+		Params   *FailReplayParams `json:"params"`
+		Total    int               `json:"total"`
+		Replayed int               `json:"replayed"`
+		Failed   int               `json:"failed"`
+		Error    string            `json:"error,omitempty"`
+		StartAt  time.Time         `json:"start_at"`
+		EndAt    *time.Time        `json:"end_at,omitempty"`
+	}
+)
+
+const (
+	FailStatusFailed   = "failed"
+	FailStatusPanic    = "panic"
+	FailStatusResolved = "resolved"
+	FailStatusIgnored  = "ignored"
+	FailStatusReplayed = "replayed"
+)
+
+const (
+	ReplayRunning   ReplayState = "running"
+	ReplayCompleted ReplayState = "completed"
+	ReplayCancelled ReplayState = "cancelled"
+	ReplayFailed    ReplayState = "failed"
+)
+
+const (
+	defaultFailCount = 20
+	maxFailCount     = 500
+	defaultReplayMax = 1000
+	maxReplayMax     = 10000
+	maxReplayRate    = 1000
+)
+
+// Parse 解析查询参数
+func (params *FailQueryParams) Parse(ctx *fiber.Ctx) error {
+	if err := ctx.QueryParser(params); err != nil {
+		return err
+	}
+	params.load()
+	return params.Verify()
+}
+
+func (params *FailQueryParams) load() {
+	if params.AppID == "" {
+		params.AppID = utils.GetEnvVal("APP_ID")
+	}
+	if params.Page <= 0 {
+		params.Page = 1
+	}
+	if params.Count <= 0 {
+		params.Count = defaultFailCount
+	}
+	if params.Count > maxFailCount {
+		params.Count = maxFailCount
+	}
+}
+
+// Verify 参数校验
+func (params *FailQueryParams) Verify() error {
+	if params.Since > 0 && params.Until > 0 && params.Since > params.Until {
+		return errors.New("fails since must not be after until")
+	}
+	for _, status := range params.Statuses() {
+		switch status {
+		case FailStatusFailed, FailStatusPanic, FailStatusResolved, FailStatusIgnored, FailStatusReplayed:
+		default:
+			return fmt.Errorf("unknown fails status: %s", status)
+		}
+	}
+	return nil
+}
+
+// Statuses 状态过滤列表
+func (params *FailQueryParams) Statuses() []string {
+	var statuses []string
+	for _, status := range strings.Split(params.Status, ",") {
+		if status = strings.ToLower(strings.TrimSpace(status)); status != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Offset 分页偏移
+func (params *FailQueryParams) Offset() int {
+	return (params.Page - 1) * params.Count
+}
+
+func (params *FailReplayParams) Decode(data []byte) error {
+	if err := utils.JsonDecode(data, params); err != nil {
+		return err
+	}
+	params.load()
+	return nil
+}
+
+func (params *FailReplayParams) load() {
+	params.Driver = strings.ToUpper(params.Driver)
+	if params.Max <= 0 {
+		params.Max = defaultReplayMax
+	}
+	if params.Filter != nil {
+		params.Filter.load()
+		// 默认只重放未处理的记录
+		if params.Filter.Status == "" {
+			params.Filter.Status = FailStatusFailed + "," + FailStatusPanic
+		}
+	}
+}
+
+func (params *FailReplayParams) Parse(ctx *fiber.Ctx) error {
+	if err := params.Decode(ctx.Body()); err != nil {
+		return err
+	}
+	return params.Verify()
+}
+
+// Verify 参数校验
+func (params *FailReplayParams) Verify() error {
+	if len(params.IDs) == 0 && params.Filter == nil {
+		return ErrorRequired
+	}
+	if params.Rate < 0 || params.Max < 0 {
+		return errors.New("replay rate and max must not be negative")
+	}
+	if params.Rate > maxReplayRate {
+		return fmt.Errorf("replay rate must not exceed %d", maxReplayRate)
+	}
+	if params.Max > maxReplayMax {
+		return fmt.Errorf("replay max must not exceed %d", maxReplayMax)
+	}
+	if params.Filter != nil {
+		return params.Filter.Verify()
+	}
+	return nil
+}
+
+func (params *FailMarkParams) Parse(ctx *fiber.Ctx) error {
+	if err := utils.JsonDecode(ctx.Body(), params); err != nil {
+		return err
+	}
+	params.Status = strings.ToLower(params.Status)
+	return params.Verify()
+}
+
+// Verify 参数校验 [只可标记为已解决或忽略]
+func (params *FailMarkParams) Verify() error {
+	if len(params.IDs) == 0 {
+		return ErrorRequired
+	}
+	if params.Status != FailStatusResolved && params.Status != FailStatusIgnored {
+		return fmt.Errorf("fails status must be %s or %s", FailStatusResolved, FailStatusIgnored)
+	}
+	return nil
+}
+
+// Finished 是否已结束
+func (progress *ReplayProgress) Finished() bool {
+	return progress.State != ReplayRunning
+}
+
+// KvMap 进度展示结构
+func (progress *ReplayProgress) KvMap() KvMap {
+	return KvMap{
+		"id":       progress.ID,
+		"state":    progress.State,
+		"params":   progress.Params,
+		"total":    progress.Total,
+		"replayed": progress.Replayed,
+		"failed":   progress.Failed,
+		"error":    progress.Error,
+		"start_at": progress.StartAt,
+		"end_at":   progress.EndAt,
+	}
+}
@@ -0,0 +1,585 @@
+package entity
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	// MessageFilter 消息过滤表达式
+	// headers.type == "order" && (body.amount >= 100 || headers["X-Region"] in ["eu", "us"]) && !body.test
+	// 取值: headers.<key>, body (原始消息体), body.<json 路径>, queue, id, attempts, content_type
+	// 运算: == != > >= < <= contains in matches && || ! ()
+	MessageFilter struct {
+		expr string
+		root filterNode
+	}
+
+	// 表达式节点
+	filterNode interface {
+		eval(ctx *filterContext) interface{}
+	}
+
+	// 单条消息求值上下文 [消息体按需解码一次]
+	filterContext struct {
+		msg     *QueueMessage
+		decoded bool
+		body    interface{}
+	}
+
+	filterToken struct {
+		kind  int
+		text  string
+		value interface{}
+	}
+
+	filterParser struct {
+		tokens []filterToken
+		pos    int
+	}
+
+	filterLiteral struct{ value interface{} }
+	filterList    struct{ items []filterNode }
+	filterPath    struct{ segments []string }
+	filterNot     struct{ node filterNode }
+	filterLogic   struct {
+		and         bool
+		left, right filterNode
+	}
+	filterCompare struct {
+		op          string
+		left, right filterNode
+		pattern     *regexp.Regexp
+	}
+)
+
+const (
+	filterTokenEnd = iota
+	filterTokenIdent
+	filterTokenLiteral
+	filterTokenOp
+)
+
+var ErrorFilterSyntax = errors.New("filter syntax error")
+
+// CompileFilter 编译过滤表达式 [空表达式匹配所有消息]
+func CompileFilter(expr string) (*MessageFilter, error) {
+	var filter = &MessageFilter{expr: strings.TrimSpace(expr)}
+	if filter.expr == "" {
+		return filter, nil
+	}
+	tokens, err := filterTokenize(filter.expr)
+	if err != nil {
+		return nil, err
+	}
+	var parser = &filterParser{tokens: tokens}
+	if filter.root, err = parser.parseOr(); err != nil {
+		return nil, err
+	}
+	if tok := parser.peek(); tok.kind != filterTokenEnd {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrorFilterSyntax, tok.text)
+	}
+	return filter, nil
+}
+
+// String 表达式原文
+func (filter *MessageFilter) String() string {
+	if filter == nil {
+		return ""
+	}
+	return filter.expr
+}
+
+// Match 消息是否匹配
+func (filter *MessageFilter) Match(msg *QueueMessage) bool {
+	if filter == nil || filter.root == nil {
+		return true
+	}
+	if msg == nil {
+		return false
+	}
+	return filterTruth(filter.root.eval(&filterContext{msg: msg}))
+}
+
+func filterTokenize(expr string) ([]filterToken, error) {
+	var (
+		tokens []filterToken
+		runes  = []rune(expr)
+	)
+	for i := 0; i < len(runes); {
+		var c = runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			var j = i + 1
+			for j < len(runes) && runes[j] != c {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrorFilterSyntax)
+			}
+			var raw = string(runes[i+1 : j])
+			if c == '\'' {
+				raw = strings.ReplaceAll(strings.ReplaceAll(raw, `\'`, `'`), `"`, `\"`)
+			}
+			text, err := strconv.Unquote(`"` + raw + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrorFilterSyntax, err)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenLiteral, text: string(runes[i : j+1]), value: text})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			var (
+				j     = i + 1
+				index = len(tokens) > 0 && tokens[len(tokens)-1].text == "."
+			)
+			// 路径中的数组下标只取整数 如 items.0.sku
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || (!index && strings.ContainsRune(".eE", runes[j]))) {
+				j++
+			}
+			var text = string(runes[i:j])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("%w: invalid number %s", ErrorFilterSyntax, text)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenLiteral, text: text, value: json.Number(text)})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			var j = i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '-') {
+				j++
+			}
+			var text = string(runes[i:j])
+			switch text {
+			case "true", "false":
+				tokens = append(tokens, filterToken{kind: filterTokenLiteral, text: text, value: text == "true"})
+			case "null", "nil":
+				tokens = append(tokens, filterToken{kind: filterTokenLiteral, text: text})
+			case "contains", "in", "matches":
+				tokens = append(tokens, filterToken{kind: filterTokenOp, text: text})
+			default:
+				tokens = append(tokens, filterToken{kind: filterTokenIdent, text: text})
+			}
+			i = j
+		default:
+			var op string
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", ">=", "<=", "&&", "||":
+					op = two
+				}
+			}
+			if op == "" {
+				switch c {
+				case '>', '<', '!', '(', ')', '[', ']', ',', '.':
+					op = string(c)
+				default:
+					return nil, fmt.Errorf("%w: unexpected %q", ErrorFilterSyntax, c)
+				}
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenOp, text: op})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{kind: filterTokenEnd}), nil
+}
+
+func (parser *filterParser) peek() filterToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *filterParser) next() filterToken {
+	var tok = parser.tokens[parser.pos]
+	if tok.kind != filterTokenEnd {
+		parser.pos++
+	}
+	return tok
+}
+
+// 期望运算符
+func (parser *filterParser) expect(op string) error {
+	if tok := parser.next(); tok.kind != filterTokenOp || tok.text != op {
+		return fmt.Errorf("%w: expect %q, got %q", ErrorFilterSyntax, op, tok.text)
+	}
+	return nil
+}
+
+func (parser *filterParser) is(op string) bool {
+	var tok = parser.peek()
+	return tok.kind == filterTokenOp && tok.text == op
+}
+
+func (parser *filterParser) parseOr() (filterNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for parser.is("||") {
+		parser.next()
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterLogic{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (parser *filterParser) parseAnd() (filterNode, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for parser.is("&&") {
+		parser.next()
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterLogic{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (parser *filterParser) parseNot() (filterNode, error) {
+	if parser.is("!") {
+		parser.next()
+		node, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node: node}, nil
+	}
+	return parser.parseCompare()
+}
+
+func (parser *filterParser) parseCompare() (filterNode, error) {
+	left, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	var tok = parser.peek()
+	if tok.kind != filterTokenOp {
+		return left, nil
+	}
+	switch tok.text {
+	case "==", "!=", ">", ">=", "<", "<=", "contains", "in", "matches":
+	default:
+		return left, nil
+	}
+	parser.next()
+	right, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	var node = &filterCompare{op: tok.text, left: left, right: right}
+	if literal, ok := right.(*filterLiteral); ok && tok.text == "matches" {
+		if node.pattern, err = regexp.Compile(fmt.Sprintf("%v", literal.value)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrorFilterSyntax, err)
+		}
+	}
+	return node, nil
+}
+
+func (parser *filterParser) parseOperand() (filterNode, error) {
+	var tok = parser.next()
+	switch tok.kind {
+	case filterTokenLiteral:
+		return &filterLiteral{value: tok.value}, nil
+	case filterTokenIdent:
+		return parser.parsePath(tok.text)
+	case filterTokenOp:
+		switch tok.text {
+		case "(":
+			node, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, parser.expect(")")
+		case "[":
+			var list = &filterList{}
+			for !parser.is("]") {
+				item, err := parser.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !parser.is(",") {
+					break
+				}
+				parser.next()
+			}
+			return list, parser.expect("]")
+		}
+	}
+	return nil, fmt.Errorf("%w: unexpected %q", ErrorFilterSyntax, tok.text)
+}
+
+// 取值路径 root.key.0["key"]
+func (parser *filterParser) parsePath(root string) (filterNode, error) {
+	switch root {
+	case "headers", "body", "queue", "id", "attempts", "content_type":
+	default:
+		return nil, fmt.Errorf("%w: unknown field %s", ErrorFilterSyntax, root)
+	}
+	var path = &filterPath{segments: []string{root}}
+	for {
+		switch {
+		case parser.is("."):
+			parser.next()
+			var tok = parser.next()
+			if tok.kind != filterTokenIdent && tok.kind != filterTokenLiteral {
+				return nil, fmt.Errorf("%w: invalid path after %s", ErrorFilterSyntax, strings.Join(path.segments, "."))
+			}
+			path.segments = append(path.segments, fmt.Sprintf("%v", tok.value))
+			if tok.kind == filterTokenIdent {
+				path.segments[len(path.segments)-1] = tok.text
+			}
+		case parser.is("["):
+			parser.next()
+			var tok = parser.next()
+			if tok.kind != filterTokenLiteral {
+				return nil, fmt.Errorf("%w: invalid index after %s", ErrorFilterSyntax, strings.Join(path.segments, "."))
+			}
+			path.segments = append(path.segments, fmt.Sprintf("%v", tok.value))
+			if err := parser.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			if root != "headers" && root != "body" && len(path.segments) > 1 {
+				return nil, fmt.Errorf("%w: %s has no fields", ErrorFilterSyntax, root)
+			}
+			return path, nil
+		}
+	}
+}
+
+func (node *filterLiteral) eval(*filterContext) interface{} {
+	return node.value
+}
+
+func (node *filterList) eval(ctx *filterContext) interface{} {
+	var items = make([]interface{}, 0, len(node.items))
+	for _, item := range node.items {
+		items = append(items, item.eval(ctx))
+	}
+	return items
+}
+
+func (node *filterPath) eval(ctx *filterContext) interface{} {
+	var msg = ctx.msg
+	switch node.segments[0] {
+	case "queue":
+		return msg.Queue
+	case "id":
+		return msg.ID
+	case "attempts":
+		return msg.Attempts
+	case "content_type":
+		return msg.ContentType
+	case "headers":
+		if len(node.segments) == 1 {
+			return map[string]interface{}(msg.Headers)
+		}
+		// 消息头名称忽略大小写
+		var value, ok = msg.Headers[node.segments[1]]
+		if !ok {
+			for k, v := range msg.Headers {
+				if strings.EqualFold(k, node.segments[1]) {
+					value = v
+					break
+				}
+			}
+		}
+		return filterLookup(value, node.segments[2:])
+	}
+	if len(node.segments) == 1 {
+		return string(msg.Body)
+	}
+	if !ctx.decoded {
+		ctx.decoded = true
+		var decoder = json.NewDecoder(bytes.NewReader(msg.Body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&ctx.body); err != nil {
+			ctx.body = nil
+		}
+	}
+	return filterLookup(ctx.body, node.segments[1:])
+}
+
+// 按路径取 json 值 [不存在返回 nil]
+func filterLookup(value interface{}, segments []string) interface{} {
+	for _, key := range segments {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			value = current[key]
+		case KvMap:
+			value = current[key]
+		case []interface{}:
+			var index, err = strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil
+			}
+			value = current[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func (node *filterNot) eval(ctx *filterContext) interface{} {
+	return !filterTruth(node.node.eval(ctx))
+}
+
+func (node *filterLogic) eval(ctx *filterContext) interface{} {
+	var left = filterTruth(node.left.eval(ctx))
+	if node.and {
+		return left && filterTruth(node.right.eval(ctx))
+	}
+	return left || filterTruth(node.right.eval(ctx))
+}
+
+func (node *filterCompare) eval(ctx *filterContext) interface{} {
+	var left, right = node.left.eval(ctx), node.right.eval(ctx)
+	switch node.op {
+	case "==":
+		return filterEqual(left, right)
+	case "!=":
+		return !filterEqual(left, right)
+	case "in":
+		return filterContains(right, left)
+	case "contains":
+		return filterContains(left, right)
+	case "matches":
+		if left == nil {
+			return false
+		}
+		var pattern = node.pattern
+		if pattern == nil {
+			var err error
+			if pattern, err = regexp.Compile(filterString(right)); err != nil {
+				return false
+			}
+		}
+		return pattern.MatchString(filterString(left))
+	}
+	if left == nil || right == nil {
+		return false
+	}
+	var cmp int
+	if l, ok := filterNumber(left); ok {
+		r, ok := filterNumber(right)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(filterString(left), filterString(right))
+	}
+	switch node.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp <= 0
+}
+
+// 真值 [nil, false, "", 0 为假]
+func filterTruth(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []byte:
+		return len(v) > 0
+	}
+	if n, ok := filterNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
+
+// 相等 [数值按数值比较, 其余按文本比较]
+func filterEqual(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if l, ok := filterNumber(left); ok {
+		if r, ok := filterNumber(right); ok {
+			return l == r
+		}
+	}
+	if l, ok := left.(bool); ok {
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	return filterString(left) == filterString(right)
+}
+
+// 包含 [字符串子串 或 数组元素]
+func filterContains(container, item interface{}) bool {
+	switch v := container.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		for _, element := range v {
+			if filterEqual(element, item) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		_, ok := v[filterString(item)]
+		return ok
+	}
+	return item != nil && strings.Contains(filterString(container), filterString(item))
+}
+
+func filterNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case bool, nil, []byte:
+		return 0, false
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return NewValue(v).Float(), true
+	}
+	return 0, false
+}
+
+func filterString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"testing"
+)
+
+func TestMessageFilter_Match(t *testing.T) {
+	var msg = NewQueueMessage("orders", []byte(`{"type":"order","amount":120,"items":[{"sku":"a-1"}],"test":false}`),
+		KvMap{"X-Region": "eu", "priority": int32(5)})
+	var cases = map[string]bool{
+		``:                                    true,
+		`headers.x-region == "eu"`:            true,
+		`headers["X-Region"] in ["us", 'eu']`: true,
+		`headers.priority >= 5 && body.amount > 100`: true,
+		`body.amount < 100 || body.type != "order"`:  false,
+		`body.items.0.sku matches "^a-\\d+$"`:        true,
+		`body contains "order" && !body.test`:        true,
+		`body.missing == null && !headers.none`:      true,
+		`queue == "orders" && (attempts == 0)`:       true,
+		`body.items contains "a-1"`:                  false,
+	}
+	for expr, want := range cases {
+		filter, err := CompileFilter(expr)
+		if err != nil {
+			t.Fatalf("CompileFilter %q Error: %v", expr, err)
+		}
+		if got := filter.Match(msg); got != want {
+			t.Errorf("MessageFilter %q Match = %v, want %v", expr, got, want)
+		}
+	}
+	for _, expr := range []string{`body ==`, `unknown == 1`, `(body.a == 1`, `body matches "["`, `queue.name == "a"`} {
+		if _, err := CompileFilter(expr); err == nil {
+			t.Errorf("CompileFilter %q expect error", expr)
+		}
+	}
+}
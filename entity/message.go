@@ -0,0 +1,186 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/drivers/rabbitmq"
+	"strconv"
+	"time"
+)
+
+type (
+	// QueueMessage 驱动无关的队列消息
+	QueueMessage struct {
+		ID          string    `json:"id"`
+		Queue       string    `json:"queue"`
+		Body        []byte    `json:"body"`
+		Headers     KvMap     `json:"headers"`
+		ContentType string    `json:"content_type"`
+		Attempts    int       `json:"delivery_count"`
+		Redelivered bool      `json:"redelivered"`
+		Timestamp   time.Time `json:"timestamp"`
+		raw         interface{}
+		ctx         context.Context
+	}
+
+	// ContextWrapper 携带消费上下文的消息封装器
+	ContextWrapper interface {
+		rabbitmq.MessageWrapper
+		Context() context.Context
+	}
+)
+
+const (
+	HeaderDeliveryCount = "x-delivery-count"
+)
+
+// NewQueueMessage 创建消息 [无原始投递对象]
+func NewQueueMessage(queue string, body []byte, headers ...KvMap) *QueueMessage {
+	headers = append(headers, KvMap{})
+	if headers[0] == nil {
+		headers[0] = KvMap{}
+	}
+	return &QueueMessage{
+		Queue:     queue,
+		Body:      body,
+		Headers:   headers[0],
+		Timestamp: time.Now(),
+	}
+}
+
+// MessageOfDelivery amqp 投递转换消息
+func MessageOfDelivery(delivery *amqp.Delivery) *QueueMessage {
+	if delivery == nil {
+		return nil
+	}
+	var msg = &QueueMessage{
+		ID:          delivery.MessageId,
+		Queue:       delivery.RoutingKey,
+		Body:        delivery.Body,
+		Headers:     KvMap{},
+		ContentType: delivery.ContentType,
+		Redelivered: delivery.Redelivered,
+		Timestamp:   delivery.Timestamp,
+		raw:         delivery,
+	}
+	for k, v := range delivery.Headers {
+		msg.Headers[k] = v
+	}
+	msg.Attempts = msg.deliveryCount()
+	return msg
+}
+
+// MessageOf 消息封装器转换消息
+func MessageOf(wrapper rabbitmq.MessageWrapper) *QueueMessage {
+	if wrapper == nil {
+		return nil
+	}
+	if msg, ok := wrapper.(*QueueMessage); ok {
+		return msg
+	}
+	var msg *QueueMessage
+	if delivery := rabbitmq.MessageForDelivery(wrapper); delivery != nil {
+		msg = MessageOfDelivery(delivery)
+	} else {
+		msg = NewQueueMessage("", wrapper.GetContent())
+		msg.raw = wrapper.GetRowMessage()
+	}
+	if carrier, ok := wrapper.(ContextWrapper); ok {
+		msg.ctx = carrier.Context()
+	}
+	return msg
+}
+
+// 投递次数 [quorum 队列 x-delivery-count 或 redelivered 标识]
+func (msg *QueueMessage) deliveryCount() int {
+	if v, ok := msg.Headers[HeaderDeliveryCount]; ok {
+		if n, err := strconv.Atoi(fmt.Sprintf("%v", v)); err == nil {
+			return n
+		}
+	}
+	if msg.Redelivered {
+		return 1
+	}
+	return 0
+}
+
+// GetContent 获取消息体
+func (msg *QueueMessage) GetContent() []byte {
+	return msg.Body
+}
+
+// GetRowMessage 获取原始消息对象
+func (msg *QueueMessage) GetRowMessage() interface{} {
+	if msg.raw == nil {
+		return msg
+	}
+	return msg.raw
+}
+
+// SetRowMessage 绑定原始消息对象
+func (msg *QueueMessage) SetRowMessage(raw interface{}) *QueueMessage {
+	msg.raw = raw
+	return msg
+}
+
+// Context 消费上下文 [未设置时为 context.Background]
+func (msg *QueueMessage) Context() context.Context {
+	if msg.ctx == nil {
+		return context.Background()
+	}
+	return msg.ctx
+}
+
+// WithContext 绑定消费上下文
+func (msg *QueueMessage) WithContext(ctx context.Context) *QueueMessage {
+	msg.ctx = ctx
+	return msg
+}
+
+// Publishing 转换 amqp 发布消息 [保留消息头]
+func (msg *QueueMessage) Publishing() amqp.Publishing {
+	var publishing = amqp.Publishing{
+		Headers:      amqp.Table{},
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	}
+	for k, v := range msg.Headers {
+		publishing.Headers[k] = v
+	}
+	if delivery, ok := msg.raw.(*amqp.Delivery); ok {
+		publishing.ContentEncoding = delivery.ContentEncoding
+		publishing.Expiration = delivery.Expiration
+		publishing.Type = delivery.Type
+		publishing.AppId = delivery.AppId
+	}
+	return publishing
+}
+
+// Clone 复制消息 [消息头独立, 共享原始投递对象]
+func (msg *QueueMessage) Clone() *QueueMessage {
+	var clone = *msg
+	clone.Headers = msg.Headers.Copy()
+	return &clone
+}
+
+func (msg *QueueMessage) String() string {
+	return string(msg.Body)
+}
+
+// KvMap 消息展示结构
+func (msg *QueueMessage) KvMap() KvMap {
+	return KvMap{
+		"id":             msg.ID,
+		"queue":          msg.Queue,
+		"body":           string(msg.Body),
+		"headers":        msg.Headers,
+		"content_type":   msg.ContentType,
+		"delivery_count": msg.Attempts,
+		"redelivered":    msg.Redelivered,
+		"timestamp":      msg.Timestamp,
+	}
+}
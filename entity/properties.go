@@ -41,12 +41,17 @@ func (p *Properties) Len() int {
 }
 
 func (p *Properties) MarshalJSON() ([]byte, error) {
-	var encoder = utils.JsonEncode(p)
+	var encoder = utils.JsonEncode(map[string]string(*p))
 	return encoder.Bytes(), encoder.Error()
 }
 
 func (p *Properties) UnmarshalJSON(data []byte) error {
-	return utils.JsonDecode(data, p)
+	var m map[string]string
+	if err := utils.JsonDecode(data, &m); err != nil {
+		return err
+	}
+	*p = m
+	return nil
 }
 
 func (p *Properties) GetOr(key string, v ...string) string {
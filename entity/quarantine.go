@@ -0,0 +1,56 @@
+package entity
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"time"
+)
+
+type (
+	// QuarantineEntry 隔离的毒消息 [反复导致处理异常]
+	QuarantineEntry struct {
+		ID        string        `json:"id"`
+		Driver    string        `json:"driver"`
+		Queue     string        `json:"queue"`
+		Binding   string        `json:"binding,omitempty"`
+		Reason    string        `json:"reason"`
+		Panics    int           `json:"panics"`
+		Stack     string        `json:"stack,omitempty"`
+		Message   *QueueMessage `json:"message"`
+		CreatedAt time.Time     `json:"created_at"`
+	}
+
+	// QuarantineReleaseParams 隔离消息释放参数
+	QuarantineReleaseParams struct {
+		// 目标队列 [为空时投递原队列]
+		Target string `json:"target,omitempty"`
+		// 目标队列驱动 amqp,redis [为空时使用原驱动]
+		Driver string `json:"driver,omitempty"`
+	}
+)
+
+func (params *QuarantineReleaseParams) Parse(ctx *fiber.Ctx) error {
+	if len(ctx.Body()) > 0 {
+		if err := utils.JsonDecode(ctx.Body(), params); err != nil {
+			return err
+		}
+	}
+	params.Driver = strings.ToUpper(params.Driver)
+	return nil
+}
+
+// KvMap 展示结构
+func (entry *QuarantineEntry) KvMap() KvMap {
+	return KvMap{
+		"id":         entry.ID,
+		"driver":     entry.Driver,
+		"queue":      entry.Queue,
+		"binding":    entry.Binding,
+		"reason":     entry.Reason,
+		"panics":     entry.Panics,
+		"stack":      entry.Stack,
+		"message":    entry.Message,
+		"created_at": entry.CreatedAt,
+	}
+}
@@ -44,6 +44,24 @@ func CreateResponse(content ...[]byte) *JsonResponse {
 	return resp
 }
 
+// CreateFailResponse 创建失败json 响应
+func CreateFailResponse(httpCode int, code Code, msg string) *JsonResponse {
+	return &JsonResponse{
+		HttpCode: httpCode,
+		Data: &JsonData{
+			Code: code.Int(),
+			Msg:  msg,
+		},
+	}
+}
+
+// CreateInfoResponse 创建带数据json 响应
+func CreateInfoResponse(info ...KvMap) *JsonResponse {
+	var resp = CreateResponse()
+	resp.Data.Info = info
+	return resp
+}
+
 func (resp *JsonResponse) GetData() JsonData {
 	if resp.Data != nil {
 		return *resp.Data
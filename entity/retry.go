@@ -0,0 +1,115 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+type (
+	// RetryPolicy 重试策略 [指数退避 + 随机抖动]
+	RetryPolicy struct {
+		MaxAttempts  int           `json:"max_attempts"`
+		InitialDelay time.Duration `json:"initial_delay"`
+		Multiplier   float64       `json:"multiplier"`
+		MaxDelay     time.Duration `json:"max_delay"`
+		Jitter       float64       `json:"jitter"` // 抖动比例 0~1
+	}
+)
+
+const (
+	// HeaderRetryAttempt 已重试次数
+	HeaderRetryAttempt = "x-retry-attempt"
+	// HeaderRetryDelay 本次重试延迟 [毫秒]
+	HeaderRetryDelay         = "x-retry-delay"
+	defaultRetryMaxAttempts  = 5
+	defaultRetryInitialDelay = time.Second
+	defaultRetryMultiplier   = 2
+	defaultRetryMaxDelay     = 5 * time.Minute
+	defaultRetryJitter       = 0.2
+	ParamRetryMaxAttempts    = "max_attempts"
+	ParamRetryInitialDelay   = "initial_delay"
+	ParamRetryMultiplier     = "multiplier"
+	ParamRetryMaxDelay       = "max_delay"
+	ParamRetryJitter         = "jitter"
+)
+
+// NewRetryPolicy 默认重试策略 [5 次, 1s 起 2 倍递增, 最长 5m, 抖动 20%]
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:  defaultRetryMaxAttempts,
+		InitialDelay: defaultRetryInitialDelay,
+		Multiplier:   defaultRetryMultiplier,
+		MaxDelay:     defaultRetryMaxDelay,
+		Jitter:       defaultRetryJitter,
+	}
+}
+
+// RetryPolicyOf 解析重试策略 [未配置项取默认值]
+// {"max_attempts":5,"initial_delay":"1s","multiplier":2,"max_delay":"5m","jitter":0.2}
+func RetryPolicyOf(kv KvMap) (*RetryPolicy, error) {
+	var policy = NewRetryPolicy()
+	if kv == nil {
+		return policy, nil
+	}
+	policy.MaxAttempts = kv.GetInt(ParamRetryMaxAttempts, policy.MaxAttempts)
+	policy.InitialDelay = kv.GetDuration(ParamRetryInitialDelay, policy.InitialDelay)
+	policy.Multiplier = kv.GetFloat(ParamRetryMultiplier, policy.Multiplier)
+	policy.MaxDelay = kv.GetDuration(ParamRetryMaxDelay, policy.MaxDelay)
+	policy.Jitter = kv.GetFloat(ParamRetryJitter, policy.Jitter)
+	return policy, policy.Verify()
+}
+
+// Verify 校验策略
+func (policy *RetryPolicy) Verify() error {
+	switch {
+	case policy.MaxAttempts < 0:
+		return errors.New("invalid retry " + ParamRetryMaxAttempts)
+	case policy.InitialDelay < 0 || policy.MaxDelay < 0:
+		return errors.New("invalid retry delay")
+	case policy.Multiplier < 1:
+		return errors.New("invalid retry " + ParamRetryMultiplier + ", expect >= 1")
+	case policy.Jitter < 0 || policy.Jitter > 1:
+		return errors.New("invalid retry " + ParamRetryJitter + ", expect 0~1")
+	}
+	return nil
+}
+
+// Exhausted 重试次数是否用尽 [attempt 为即将进行的重试次数]
+func (policy *RetryPolicy) Exhausted(attempt int) bool {
+	return attempt > policy.MaxAttempts
+}
+
+// Delay 第 attempt 次重试延迟 [initial * multiplier^(attempt-1), 不超过 max_delay, 按比例随机抖动]
+func (policy *RetryPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	var delay = float64(policy.InitialDelay) * math.Pow(policy.Multiplier, float64(attempt-1))
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	if policy.Jitter > 0 {
+		delay += delay * policy.Jitter * (rand.Float64()*2 - 1)
+	}
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	return time.Duration(delay)
+}
+
+// RetryAttempt 消息已重试次数
+func RetryAttempt(msg *QueueMessage) int {
+	var v, ok = msg.Headers[HeaderRetryAttempt]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(fmt.Sprintf("%v", v))
+	if err != nil {
+		return 0
+	}
+	return n
+}
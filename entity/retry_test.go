@@ -0,0 +1,43 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	var policy = &RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, Multiplier: 2, MaxDelay: 3 * time.Second}
+	var cases = map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 10: 3 * time.Second}
+	for attempt, want := range cases {
+		if delay := policy.Delay(attempt); delay != want {
+			t.Errorf("RetryPolicy.Delay(%d) = %v, want %v", attempt, delay, want)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(1); delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("RetryPolicy.Delay jitter out of range: %v", delay)
+		}
+	}
+	if !policy.Exhausted(4) || policy.Exhausted(3) {
+		t.Errorf("RetryPolicy.Exhausted max attempts %d", policy.MaxAttempts)
+	}
+}
+
+func TestRetryPolicyOf(t *testing.T) {
+	policy, err := RetryPolicyOf(KvMap{"max_attempts": 2, "initial_delay": "500ms", "max_delay": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.MaxAttempts != 2 || policy.InitialDelay != 500*time.Millisecond || policy.MaxDelay != time.Minute || policy.Multiplier != defaultRetryMultiplier {
+		t.Errorf("RetryPolicyOf = %+v", policy)
+	}
+	if _, err = RetryPolicyOf(KvMap{"jitter": 2}); err == nil {
+		t.Error("RetryPolicyOf jitter > 1 expect error")
+	}
+	var msg = NewQueueMessage("orders", []byte(`{}`))
+	msg.Headers[HeaderRetryAttempt] = int64(3)
+	if RetryAttempt(msg) != 3 {
+		t.Errorf("RetryAttempt = %d, want 3", RetryAttempt(msg))
+	}
+}
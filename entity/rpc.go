@@ -0,0 +1,8 @@
+package entity
+
+// RpcResult 同步调用应答 [消费器无输出消息或处理失败时的应答内容]
+type RpcResult struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
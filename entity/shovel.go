@@ -0,0 +1,139 @@
+package entity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+	"time"
+)
+
+type (
+	ShovelState string
+
+	// ShovelParams 队列消息转移参数
+	ShovelParams struct {
+		// 源队列
+		Source string `form:"source" json:"source"`
+		// 源队列驱动 amqp,redis
+		SourceDriver string `form:"source_driver" json:"source_driver"`
+		// 目标队列
+		Target string `form:"target" json:"target"`
+		// 目标队列驱动 amqp,redis
+		TargetDriver string `form:"target_driver" json:"target_driver"`
+		// 每秒转移条数 0:不限速
+		Rate int `form:"rate" json:"rate"`
+		// 最大转移条数 0:不限制
+		Max int `form:"max" json:"max"`
+		// 消息体包含内容过滤
+		Filter string `form:"filter" json:"filter,omitempty"`
+		// 消息头匹配过滤
+		FilterHeaders KvMap `form:"-" json:"filter_headers,omitempty"`
+	}
+
+	// ShovelProgress 队列消息转移进度
+	ShovelProgress struct {
+		ID      string        `json:"id"`
+		State   ShovelState   `json:"state"`
+		Params  *ShovelParams `json:"params"`
+		Total   int           `json:"total"`   // 启动时源队列消息数 [扫描上限]
+		Scanned int           `json:"scanned"` // 已扫描
+		Moved   int           `json:"moved"`   // 已转移
+		Skipped int           `json:"skipped"` // 过滤跳过
+		Error   string        `json:"error,omitempty"`
+		StartAt time.Time     `json:"start_at"`
+		EndAt   *time.Time    `json:"end_at,omitempty"`
+	}
+)
+
+const (
+	ShovelRunning   ShovelState = "running"
+	ShovelCompleted ShovelState = "completed"
+	ShovelCancelled ShovelState = "cancelled"
+	ShovelFailed    ShovelState = "failed"
+)
+
+// 每秒转移条数上限
+const maxShovelRate = 100000
+
+func (params *ShovelParams) Decode(data []byte) error {
+	if err := utils.JsonDecode(data, params); err != nil {
+		return err
+	}
+	params.load()
+	return nil
+}
+
+func (params *ShovelParams) load() {
+	params.SourceDriver = strings.ToUpper(params.SourceDriver)
+	params.TargetDriver = strings.ToUpper(params.TargetDriver)
+	if params.SourceDriver == "" {
+		params.SourceDriver = "AMQP"
+	}
+	if params.TargetDriver == "" {
+		params.TargetDriver = params.SourceDriver
+	}
+}
+
+func (params *ShovelParams) Parse(ctx *fiber.Ctx) error {
+	if err := params.Decode(ctx.Body()); err != nil {
+		if err2 := ctx.BodyParser(params); err2 != nil {
+			return err
+		}
+		params.load()
+	}
+	return params.Verify()
+}
+
+// Verify 参数校验
+func (params *ShovelParams) Verify() error {
+	if params.Source == "" || params.Target == "" {
+		return ErrorRequired
+	}
+	if params.Source == params.Target && params.SourceDriver == params.TargetDriver {
+		return errors.New("shovel source and target are the same queue")
+	}
+	if params.Rate < 0 || params.Max < 0 {
+		return errors.New("shovel rate and max must not be negative")
+	}
+	if params.Rate > maxShovelRate {
+		return fmt.Errorf("shovel rate must not exceed %d", maxShovelRate)
+	}
+	return nil
+}
+
+// Match 消息是否满足过滤条件
+func (params *ShovelParams) Match(msg *QueueMessage) bool {
+	if params.Filter != "" && !bytes.Contains(msg.Body, []byte(params.Filter)) {
+		return false
+	}
+	for k, v := range params.FilterHeaders {
+		if value, ok := msg.Headers[k]; !ok || fmt.Sprintf("%v", value) != fmt.Sprintf("%v", v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finished 是否已结束
+func (progress *ShovelProgress) Finished() bool {
+	return progress.State != ShovelRunning
+}
+
+// KvMap 进度展示结构
+func (progress *ShovelProgress) KvMap() KvMap {
+	return KvMap{
+		"id":       progress.ID,
+		"state":    progress.State,
+		"params":   progress.Params,
+		"total":    progress.Total,
+		"scanned":  progress.Scanned,
+		"moved":    progress.Moved,
+		"skipped":  progress.Skipped,
+		"error":    progress.Error,
+		"start_at": progress.StartAt,
+		"end_at":   progress.EndAt,
+	}
+}
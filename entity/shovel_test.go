@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"testing"
+)
+
+func TestShovelParams_Match(t *testing.T) {
+	var (
+		params = &ShovelParams{Filter: "order", FilterHeaders: KvMap{"x-app": "shop"}}
+		msg    = NewQueueMessage("test", []byte(`{"type":"order"}`), KvMap{"x-app": "shop"})
+	)
+	if !params.Match(msg) {
+		t.Error("ShovelParams Match Error")
+	}
+	msg.Headers["x-app"] = "user"
+	if params.Match(msg) {
+		t.Error("ShovelParams Match Headers Error")
+	}
+	if params.Match(NewQueueMessage("test", []byte(`{"type":"user"}`), KvMap{"x-app": "shop"})) {
+		t.Error("ShovelParams Match Body Error")
+	}
+}
+
+func TestShovelParams_Verify(t *testing.T) {
+	var params = &ShovelParams{Source: "a", Target: "a"}
+	params.load()
+	if params.Verify() == nil {
+		t.Error("ShovelParams Verify same queue Error")
+	}
+	params.TargetDriver = "REDIS"
+	if err := params.Verify(); err != nil {
+		t.Error("ShovelParams Verify cross driver Error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// PropertyConsumeTimeout 队列配置 单条消息处理超时 [如 30s, 未配置不限时]
+	PropertyConsumeTimeout = "consume_timeout"
+)
+
+var (
+	// ErrorConsumeTimeout 消息处理超时
+	ErrorConsumeTimeout = errors.New("consume timeout")
+	// ErrorConsumeCanceled 停止消费 处理被取消
+	ErrorConsumeCanceled = errors.New("consume canceled")
+)
+
+// ConsumeTimeout 队列配置的处理超时 [未配置或格式错误返回 0]
+func (params *QueueParams) ConsumeTimeout() time.Duration {
+	if params.Properties == "" {
+		return 0
+	}
+	properties, err := ParseProperties(params.Properties)
+	if err != nil {
+		return 0
+	}
+	timeout, err := time.ParseDuration(properties.GetOr(PropertyConsumeTimeout))
+	if err != nil || timeout < 0 {
+		return 0
+	}
+	return timeout
+}
+
+// ContextAction 按上下文修正处理结果 [超时或取消的未确认处理视为可重试失败]
+func ContextAction(ctx context.Context, action ConsumeAction, err error) (ConsumeAction, error) {
+	if action == ConsumeAck || ctx.Err() == nil {
+		return action, err
+	}
+	return ConsumeRetry, ContextError(ctx, err)
+}
+
+// ContextError 上下文结束原因 [超时: ErrorConsumeTimeout, 取消: ErrorConsumeCanceled]
+func ContextError(ctx context.Context, err error) error {
+	var reason = ErrorConsumeCanceled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = ErrorConsumeTimeout
+	}
+	if err == nil || errors.Is(err, reason) {
+		return reason
+	}
+	return fmt.Errorf("%w: %v", reason, err)
+}
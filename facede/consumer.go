@@ -0,0 +1,61 @@
+package facede
+
+import (
+	"context"
+	"github.com/weblfe/queue_mgr/entity"
+	"time"
+)
+
+// Consumer 队列消费器
+type Consumer interface {
+	// Type 消费器类型
+	Type() string
+	// Parse 解析消费器配置json
+	Parse(properties []byte) error
+	// Handle 处理消息 返回确认|重试|丢弃
+	Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error)
+}
+
+// Processor 可输出处理结果的消费器 [流水线中输出作为下一步输入]
+type Processor interface {
+	Consumer
+	// Process 处理消息 返回输出消息及确认|重试|丢弃
+	Process(msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error)
+}
+
+// ContextConsumer 支持上下文的消费器 [超时或停止消费时取消处理]
+type ContextConsumer interface {
+	Consumer
+	// HandleContext 处理消息 ctx 取消时尽快返回
+	HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error)
+}
+
+// ContextProcessor 支持上下文的输出消费器
+type ContextProcessor interface {
+	Processor
+	// ProcessContext 处理消息 ctx 取消时尽快返回
+	ProcessContext(ctx context.Context, msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error)
+}
+
+// BatchConsumer 批量处理的消费器 [绑定并发数不低于批次大小, 否则批次凑不满]
+type BatchConsumer interface {
+	Consumer
+	// BatchSize 单批最大消息数
+	BatchSize() int
+}
+
+// FailureRecorder 消费失败记录器
+type FailureRecorder interface {
+	// Record 记录失败 [不阻塞消费]
+	Record(failure *entity.ConsumeFailure)
+}
+
+// DedupStore 消息去重存储
+type DedupStore interface {
+	// Reserve 占用键 [已存在返回 false, ttl 后自动过期]
+	Reserve(key string, ttl time.Duration) (bool, error)
+	// Commit 处理成功 键有效期延长为 ttl
+	Commit(key string, ttl time.Duration) error
+	// Release 释放键
+	Release(key string) error
+}
@@ -2,6 +2,7 @@ package facede
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/yookoala/gofast"
 	"net/http"
 	"sync"
 	"time"
@@ -21,3 +22,11 @@ type Handler interface {
 	// Register 注册处理器池
 	Register(pool *sync.Pool)
 }
+
+// FastCgiUpstream FastCGI 连接来源 [单地址连接池 或 负载均衡组]
+type FastCgiUpstream interface {
+	// CreateClient 获取连接 Close 时归还
+	CreateClient() (gofast.Client, error)
+	// Addr 地址描述
+	Addr() string
+}
@@ -0,0 +1,18 @@
+package facede
+
+import (
+	"github.com/yuin/gopher-lua"
+	"io"
+)
+
+// LuaPlugin lua 脚本插件
+type LuaPlugin interface {
+	// Boot 加载扩展模块
+	Boot()
+	// GetLState lua 虚拟机
+	GetLState() *lua.LState
+	// LoadFile 加载脚本文件
+	LoadFile(file string) (*lua.LFunction, error)
+	// LoadByIo 加载脚本内容
+	LoadByIo(reader io.ReadCloser, name string) (*lua.LFunction, error)
+}
@@ -0,0 +1,9 @@
+package facede
+
+import "firebase.google.com/go/messaging"
+
+// FcmPusher firebase 消息推送
+type FcmPusher interface {
+	// SendBatch 批量发送 [单批最多 500 条]
+	SendBatch(messages []*messaging.Message) (*messaging.BatchResponse, error)
+}
@@ -1,10 +1,29 @@
 package facede
 
-import "github.com/weblfe/drivers/rabbitmq"
+import (
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"time"
+)
 
 type QueueEntry interface {
 	Stop()
 	QueueDeclare(queue string, options ...func(params interface{})) error
 	Push(data interface{}, queue ...string) error
 	Pop(callback func(broker rabbitmq.MessageWrapper), queue ...string) error
+	// Len 队列待消费消息数
+	Len(queue ...string) (int, error)
+	// Peek 查看队列头部 n 条消息 [不消费]
+	Peek(n int, queue ...string) ([]*entity.QueueMessage, error)
+	// Purge 清空队列 返回清除消息数
+	Purge(queue ...string) (int, error)
+	// Take 依次取出头部至多 n 条消息交由 handler 处理, 返回已处理条数
+	// handler 返回 nil 确认删除; entity.ErrorMessageSkip 保留并继续; 其他错误保留并终止
+	Take(n int, handler func(msg *entity.QueueMessage) error, queue ...string) (int, error)
+}
+
+// DelayedQueue 支持延迟投递的队列驱动
+type DelayedQueue interface {
+	// PushDelay 延迟 delay 后投递到 queue
+	PushDelay(msg *entity.QueueMessage, delay time.Duration, queue string) error
 }
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/utils"
+	"strings"
+)
+
+// Admin 管理员权限中间件 [依赖 jwt 解析]
+type Admin struct {
+	jwt   *Jwt
+	roles []string
+}
+
+const (
+	// LocalAuthKey jwt 解析后认证数据 ctx.Locals 键
+	LocalAuthKey = "auth"
+)
+
+func NewAdmin() *Admin {
+	var admin = new(Admin)
+	admin.jwt = NewJwt()
+	admin.roles = strings.Split(utils.GetEnvVal("JWT_ADMIN_ROLES", "1"), ",")
+	return admin
+}
+
+func NewAdminWare() fiber.Handler {
+	return NewAdmin().Handler
+}
+
+// IsAdmin 角色是否管理员
+func (ware *Admin) IsAdmin(role int) bool {
+	var value = fmt.Sprintf("%d", role)
+	for _, v := range ware.roles {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (ware *Admin) Handler(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodOptions || ware.jwt.GetDebug() {
+		return c.Next()
+	}
+	var data, err = ware.jwt.decode(c)
+	if err != nil {
+		return ware.jwt.unauthorized(c, fiber.StatusUnauthorized, `please try login`)
+	}
+	if !ware.IsAdmin(data.Role) {
+		return ware.jwt.unauthorized(c, fiber.StatusForbidden, `permission denied`)
+	}
+	return c.Next()
+}
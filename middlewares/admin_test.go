@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/utils"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdmin_Handler(t *testing.T) {
+	t.Setenv("APP_ID", "queue_mgr")
+	t.Setenv("APP_SECRET", "admin-test-secret")
+	t.Setenv("JWT_FILTER_OFF", "false")
+	t.Setenv("JWT_ADMIN_ROLES", "1")
+
+	var app = fiber.New()
+	app.Post("/purge", NewAdminWare(), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	app.Get("/len", NewJwtWare(), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	var tokenOf = func(role int) string {
+		token, err := utils.JwtTokenEncode(utils.AuthData{Uid: "u-1", Role: role, Scope: "queue", ExpireAt: time.Now().Add(time.Hour).Unix()}, "admin-test-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	var cases = []struct {
+		method, path, token string
+		status              int
+	}{
+		{fiber.MethodPost, "/purge", "", fiber.StatusUnauthorized},
+		{fiber.MethodPost, "/purge", "invalid", fiber.StatusUnauthorized},
+		{fiber.MethodPost, "/purge", tokenOf(2), fiber.StatusForbidden},
+		{fiber.MethodPost, "/purge", tokenOf(1), fiber.StatusOK},
+		{fiber.MethodGet, "/len", "", fiber.StatusUnauthorized},
+		{fiber.MethodGet, "/len", tokenOf(2), fiber.StatusOK},
+	}
+	for _, c := range cases {
+		var req = httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", c.token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s token %t: status %d, want %d", c.method, c.path, c.token != "", resp.StatusCode, c.status)
+		}
+	}
+}
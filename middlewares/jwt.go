@@ -83,37 +83,39 @@ func (ware *Jwt) GetKey() string {
 	return ware.headerKey
 }
 
-func (ware *Jwt) decode(c *fiber.Ctx) error {
+func (ware *Jwt) decode(c *fiber.Ctx) (*utils.AuthData, error) {
 	var (
 		key         = ware.GetKey()
 		accessToken = c.Get(key)
 	)
 	if accessToken == "" {
-		return errors.New("miss access token")
+		return nil, errors.New("miss access token")
 	}
 	var appID = ware.getAppID(c)
 	if appID == "" {
-		return errors.New("miss appID")
+		return nil, errors.New("miss appID")
 	}
 	var secret = ware.GetAppSecret(appID)
 	if secret == "" {
-		return errors.New("miss app secret")
+		return nil, errors.New("miss app secret")
 	}
 	var data, err = utils.JwtTokenDecode(accessToken, secret)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err = data.Verify(); err != nil {
-		return err
+		return nil, err
 	}
 	if ware.scope != "" {
 		if data.Scope != ware.getScope() && data.Scope != "*" {
-			return errors.New("scope error")
+			return nil, errors.New("scope error")
 		}
 	}
-	c.Request().Header.Add("X-UID", data.Uid)
-	c.Request().Header.Add("X-Role", fmt.Sprintf("%d", data.Role))
-	return nil
+	// 覆盖请求方传入的同名头
+	c.Request().Header.Set("X-UID", data.Uid)
+	c.Request().Header.Set("X-Role", fmt.Sprintf("%d", data.Role))
+	c.Locals(LocalAuthKey, data)
+	return data, nil
 }
 
 func (ware *Jwt) GetAppSecret(appID string) string {
@@ -208,22 +210,26 @@ func (ware *Jwt) Handler(c *fiber.Ctx) error {
 	if c.Method() == fiber.MethodOptions || ware.skip(c) {
 		return c.Next()
 	}
-	if err := ware.decode(c); err == nil {
+	if _, err := ware.decode(c); err == nil {
 		return c.Next()
 	}
+	return ware.unauthorized(c, fiber.StatusUnauthorized, `please try login`)
+}
+
+func (ware *Jwt) unauthorized(c *fiber.Ctx, status int, msg string) error {
 	if c.Get(fiber.HeaderAccept) == fiber.MIMEApplicationJSON {
 		var data = fiber.Map{
-			"httpCode": fiber.StatusUnauthorized,
+			"httpCode": status,
 			"data": fiber.Map{
-				"code": fiber.StatusUnauthorized,
-				"msg":  `please try login`,
+				"code": status,
+				"msg":  msg,
 			},
 		}
 		ware.getLogger().WithField("request",c.Request()).Errorln("jwt decode failed")
-		return c.Status(fiber.StatusUnauthorized).JSON(data)
+		return c.Status(status).JSON(data)
 	}
 	c.Response().Header.SetContentType(fiber.MIMETextHTMLCharsetUTF8)
-	return c.Status(fiber.StatusUnauthorized).Send([]byte(`please try login`))
+	return c.Status(status).Send([]byte(msg))
 }
 
 func (ware *Jwt) getLogger() *logrus.Logger {
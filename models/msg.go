@@ -1,11 +1,15 @@
 package models
 
-import "time"
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"time"
+	"xorm.io/builder"
+)
 
 type QueueFails struct {
 	ID    uint   `xorm:" pk 'id'" json:"id"`
 	AppID string `xorm:"'appid'" json:"appid"`
-	// 队列状态 0: 消费失败, 1:消费异常
+	// 队列状态 0: 消费失败, 1:消费异常, 2:已解决, 3:已忽略, 4:已重放
 	Status uint `xorm:"'status'" json:"status"`
 	// 已重试次数
 	TryTimes uint `xorm:"'try_times'" json:"try_times"`
@@ -17,15 +21,130 @@ type QueueFails struct {
 	Queue    string `xorm:"'queue'" json:"queue"`
 	Consumer string `xorm:"'consumer'" json:"consumer"`
 	// 队列配置json
-	Payloads  string    `xorm:"'payloads'" json:"payloads"`
-	UpdatedAt time.Time `xorm:" updated 'updated_at'" json:"-"`
-	CreatedAt time.Time `xorm:" created 'created_at'" json:"-"`
+	Payloads string `xorm:"'payloads'" json:"payloads"`
+	// 重放次数
+	ReplayTimes uint `xorm:"'replay_times'" json:"replay_times"`
+	// 最近重放目标队列
+	ReplayQueue string `xorm:"'replay_queue'" json:"replay_queue,omitempty"`
+	// 最近重放结果 [成功为空]
+	ReplayError string     `xorm:"'replay_error'" json:"replay_error,omitempty"`
+	ReplayedAt  *time.Time `xorm:"'replayed_at'" json:"replayed_at,omitempty"`
+	UpdatedAt   time.Time  `xorm:" updated 'updated_at'" json:"-"`
+	CreatedAt   time.Time  `xorm:" created 'created_at'" json:"created_at"`
 	baseModel
 }
 
+const (
+	// QueueFailStatusFailed 消费失败
+	QueueFailStatusFailed uint = 0
+	// QueueFailStatusPanic 消费异常
+	QueueFailStatusPanic uint = 1
+	// QueueFailStatusResolved 已解决
+	QueueFailStatusResolved uint = 2
+	// QueueFailStatusIgnored 已忽略
+	QueueFailStatusIgnored uint = 3
+	// QueueFailStatusReplayed 已重放
+	QueueFailStatusReplayed uint = 4
+)
+
+var queueFailStatuses = map[string]uint{
+	entity.FailStatusFailed:   QueueFailStatusFailed,
+	entity.FailStatusPanic:    QueueFailStatusPanic,
+	entity.FailStatusResolved: QueueFailStatusResolved,
+	entity.FailStatusIgnored:  QueueFailStatusIgnored,
+	entity.FailStatusReplayed: QueueFailStatusReplayed,
+}
+
+// QueueFailStatusOf 状态名称转换
+func QueueFailStatusOf(name string) (uint, bool) {
+	status, ok := queueFailStatuses[name]
+	return status, ok
+}
+
 func (info *QueueFails) TableName() string {
 	if info.table == "" {
 		info.setTable("app_queue_fails")
 	}
 	return info.baseModel.TableName()
 }
+
+// Save 写入失败记录
+func (info *QueueFails) Save() error {
+	_, err := info.save(info)
+	return err
+}
+
+// Get 按 ID 查询
+func (info *QueueFails) Get(id uint) error {
+	ok, err := info.Query().Table(info.TableName()).ID(id).Get(info)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return entity.ErrorEmpty
+	}
+	return nil
+}
+
+// List 按条件分页查询 [ID 倒序]
+func (info *QueueFails) List(params *entity.FailQueryParams) ([]*QueueFails, int64, error) {
+	var items []*QueueFails
+	total, err := info.Query().Table(info.TableName()).Where(info.condOf(params)).
+		Desc("id").Limit(params.Count, params.Offset()).FindAndCount(&items)
+	return items, total, err
+}
+
+// MarkStatus 批量标记状态
+func (info *QueueFails) MarkStatus(ids []uint, status uint) (int64, error) {
+	return info.Query().Table(info.TableName()).In("id", ids).Cols("status").Update(&QueueFails{Status: status})
+}
+
+// SaveReplay 记录重放结果 [成功时标记已重放]
+func (info *QueueFails) SaveReplay(queue string, replayErr error) error {
+	var now = time.Now()
+	info.ReplayTimes++
+	info.ReplayQueue = queue
+	info.ReplayError = ""
+	info.ReplayedAt = &now
+	if replayErr != nil {
+		info.ReplayError = replayErr.Error()
+	} else {
+		info.Status = QueueFailStatusReplayed
+	}
+	_, err := info.Query().Table(info.TableName()).ID(info.ID).
+		Cols("status", "replay_times", "replay_queue", "replay_error", "replayed_at").Update(info)
+	return err
+}
+
+// 查询条件
+func (info *QueueFails) condOf(params *entity.FailQueryParams) builder.Cond {
+	var cond = builder.NewCond()
+	if params.AppID != "" {
+		cond = cond.And(builder.Eq{"appid": params.AppID})
+	}
+	if params.Queue != "" {
+		cond = cond.And(builder.Eq{"queue": params.Queue})
+	}
+	if params.Consumer != "" {
+		cond = cond.And(builder.Eq{"consumer": params.Consumer})
+	}
+	if params.Error != "" {
+		cond = cond.And(builder.Like{"error", params.Error})
+	}
+	if params.Since > 0 {
+		cond = cond.And(builder.Gte{"created_at": time.Unix(params.Since, 0)})
+	}
+	if params.Until > 0 {
+		cond = cond.And(builder.Lte{"created_at": time.Unix(params.Until, 0)})
+	}
+	var statuses []uint
+	for _, name := range params.Statuses() {
+		if status, ok := QueueFailStatusOf(name); ok {
+			statuses = append(statuses, status)
+		}
+	}
+	if len(statuses) > 0 {
+		cond = cond.And(builder.In("status", statuses))
+	}
+	return cond
+}
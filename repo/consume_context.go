@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"context"
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"sync"
+	"time"
+)
+
+type (
+	// 消费上下文 [Stop 时取消进行中的处理, 再次消费时重建]
+	consumeContext struct {
+		locker sync.Mutex
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+
+	// 携带消费上下文的 amqp 投递
+	contextDelivery struct {
+		*rabbitmq.AmqpMessageWrapper
+		ctx context.Context
+	}
+)
+
+// HandleContext 带上下文调用消费器 [timeout>0 时限时, 超时或取消的未确认处理视为重试, rpc 调用时记录应答内容]
+// 消费器未实现 facede.ContextConsumer 时无法中断, 超时只影响处理结果
+func HandleContext(ctx context.Context, consumer facede.Consumer, msg *entity.QueueMessage, timeout time.Duration) (entity.ConsumeAction, error) {
+	if ctx == nil {
+		ctx = msg.Context()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if ctx.Err() != nil {
+		return entity.ConsumeRetry, entity.ContextError(ctx, nil)
+	}
+	msg.WithContext(ctx)
+	var (
+		output    *entity.QueueMessage
+		action    entity.ConsumeAction
+		err       error
+		responder = responderOf(ctx)
+
+		ctxProcessor, isCtxProcessor = consumer.(facede.ContextProcessor)
+		processor, isProcessor       = consumer.(facede.Processor)
+		ctxHandler, isCtxHandler     = consumer.(facede.ContextConsumer)
+	)
+	// rpc 调用 输出消息作为应答内容
+	switch {
+	case responder != nil && isCtxProcessor:
+		output, action, err = ctxProcessor.ProcessContext(ctx, msg)
+	case responder != nil && isProcessor:
+		output, action, err = processor.Process(msg)
+	case isCtxHandler:
+		action, err = ctxHandler.HandleContext(ctx, msg)
+	default:
+		action, err = consumer.Handle(msg)
+	}
+	action, err = entity.ContextAction(ctx, action, err)
+	responder.record(output, action, err)
+	return action, err
+}
+
+// Context 当前消费上下文 [未初始化时为 context.Background]
+func (c *consumeContext) Context() context.Context {
+	if c == nil {
+		return context.Background()
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.ctx == nil {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+	}
+	return c.ctx
+}
+
+// Cancel 取消进行中的处理
+func (c *consumeContext) Cancel() {
+	if c == nil {
+		return
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.ctx, c.cancel = nil, nil
+}
+
+func (delivery *contextDelivery) Context() context.Context {
+	return delivery.ctx
+}
@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+	"time"
+)
+
+// 阻塞至上下文结束 返回丢弃
+type contextTestConsumer struct {
+	fanoutTestConsumer
+}
+
+func (consumer *contextTestConsumer) HandleContext(ctx context.Context, msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	if _, err := consumer.Handle(msg); err != nil {
+		return entity.ConsumeRetry, err
+	}
+	select {
+	case <-ctx.Done():
+		return entity.ConsumeDrop, ctx.Err()
+	case <-time.After(time.Second):
+		return consumer.action, nil
+	}
+}
+
+func TestHandleContext(t *testing.T) {
+	var consumer = &contextTestConsumer{fanoutTestConsumer{action: entity.ConsumeAck}}
+	// 超时视为重试
+	var start = time.Now()
+	action, err := HandleContext(context.Background(), consumer, entity.NewQueueMessage("events", nil), 20*time.Millisecond)
+	if action != entity.ConsumeRetry || !errors.Is(err, entity.ErrorConsumeTimeout) {
+		t.Fatalf("HandleContext timeout %s %v, want retry", action, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("HandleContext timeout not propagated to consumer")
+	}
+
+	// 停止消费 取消进行中的处理, 之后重建上下文
+	var consuming = new(consumeContext)
+	var ctx = consuming.Context()
+	time.AfterFunc(20*time.Millisecond, consuming.Cancel)
+	action, err = HandleContext(ctx, consumer, entity.NewQueueMessage("events", nil), 0)
+	if action != entity.ConsumeRetry || !errors.Is(err, entity.ErrorConsumeCanceled) {
+		t.Fatalf("HandleContext canceled %s %v, want retry", action, err)
+	}
+	if consuming.Context().Err() != nil {
+		t.Fatal("consumeContext should renew after cancel")
+	}
+
+	// 不支持上下文的消费器 结果不变
+	action, err = HandleContext(nil, &fanoutTestConsumer{action: entity.ConsumeDrop}, entity.NewQueueMessage("events", nil), time.Second)
+	if action != entity.ConsumeDrop || err != nil {
+		t.Fatalf("HandleContext plain consumer %s %v, want drop", action, err)
+	}
+}
+
+func TestQueueFanout_Timeout(t *testing.T) {
+	var (
+		consumer = &contextTestConsumer{fanoutTestConsumer{action: entity.ConsumeAck}}
+		replies  = make(chan entity.ConsumeAction, 1)
+		fanout   = NewQueueFanout("events", new(fanoutTestEntry))
+	)
+	defer fanout.Close()
+	if err := fanout.Bind(&QueueBinding{Name: "slow", Consumer: consumer, Timeout: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	fanout.Dispatch(entity.NewQueueMessage("events", []byte(`{}`)), func(action entity.ConsumeAction) error {
+		replies <- action
+		return nil
+	})
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeRetry {
+		t.Fatalf("QueueFanout timeout reply %s, want retry", action)
+	}
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"errors"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"sync"
+)
+
+var (
+	ErrorDeadLetterSelf = errors.New("dead letter queue same as source queue")
+	deadLetters         = sync.Map{} // queue => dead letter queue
+)
+
+// SetDeadLetterQueue 设置队列死信队列 [target 为空时移除, 需在队列声明前设置]
+func SetDeadLetterQueue(queue, target string) error {
+	if target == "" {
+		deadLetters.Delete(queue)
+		return nil
+	}
+	if target == queue {
+		return ErrorDeadLetterSelf
+	}
+	deadLetters.Store(queue, target)
+	return nil
+}
+
+// DeadLetterQueueOf 队列的死信队列 [未配置返回空]
+func DeadLetterQueueOf(queue string) string {
+	if target, ok := deadLetters.Load(queue); ok {
+		return target.(string)
+	}
+	return ""
+}
+
+// DeadLetter 投递死信队列 [成功:确认, 未配置或投递失败:丢弃 由 broker 原生死信兜底]
+func DeadLetter(entry facede.QueueEntry, msg *entity.QueueMessage, queue string, reason error) (entity.ConsumeAction, error) {
+	var target = DeadLetterQueueOf(queue)
+	if target == "" || entry == nil {
+		return entity.ConsumeDrop, nil
+	}
+	if err := entry.Push(entity.DeadLetterOf(msg, queue, reason), target); err != nil {
+		return entity.ConsumeDrop, err
+	}
+	return entity.ConsumeAck, nil
+}
@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"errors"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+)
+
+func TestDeadLetter(t *testing.T) {
+	var (
+		entry = new(fanoutTestEntry)
+		msg   = entity.NewQueueMessage("orders", []byte(`{}`))
+	)
+	if action, _ := DeadLetter(entry, msg, "orders", nil); action != entity.ConsumeDrop || len(entry.messages()) != 0 {
+		t.Fatalf("DeadLetter without target = %s", action)
+	}
+	if err := SetDeadLetterQueue("orders", "orders"); err != ErrorDeadLetterSelf {
+		t.Errorf("SetDeadLetterQueue self = %v", err)
+	}
+	if err := SetDeadLetterQueue("orders", "orders.dlq"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDeadLetterQueue("orders", "")
+	msg.Headers[entity.HeaderRetryAttempt] = 5
+	action, err := DeadLetter(entry, msg, "orders", errors.New("timeout"))
+	if err != nil || action != entity.ConsumeAck {
+		t.Fatalf("DeadLetter = %s, %v", action, err)
+	}
+	var letter = entry.messages()[0]
+	if entry.queues[0] != "orders.dlq" || letter.Headers.GetStr(entity.HeaderDeadLetterQueue) != "orders" ||
+		letter.Headers.GetStr(entity.HeaderDeadLetterError) != "timeout" || letter.Headers.GetInt(entity.HeaderDeadLetterAttempts) != 5 {
+		t.Errorf("DeadLetter headers %v => %s", letter.Headers, entry.queues[0])
+	}
+	if _, ok := msg.Headers[entity.HeaderDeadLetterQueue]; ok {
+		t.Error("DeadLetter modified source message")
+	}
+}
+
+func TestQueueFanout_DeadLetter(t *testing.T) {
+	if err := SetDeadLetterQueue("events", "events.dlq"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDeadLetterQueue("events", "")
+	var (
+		entry   = new(fanoutTestEntry)
+		replies = make(chan entity.ConsumeAction, 1)
+		fanout  = NewQueueFanout("events", entry)
+	)
+	defer fanout.Close()
+	if err := fanout.Bind(&QueueBinding{Name: "audit", Consumer: &fanoutTestConsumer{action: entity.ConsumeDrop}}); err != nil {
+		t.Fatal(err)
+	}
+	fanout.Dispatch(entity.NewQueueMessage("events", []byte(`{}`)), func(action entity.ConsumeAction) error {
+		replies <- action
+		return nil
+	})
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("QueueFanout dead letter reply %s, want ack", action)
+	}
+	var published = entry.messages()
+	if len(published) != 1 || entry.queues[0] != "events.dlq" || published[0].Headers.GetStr(HeaderFanoutTarget) != "audit" {
+		t.Errorf("QueueFanout dead letter %v", published)
+	}
+}
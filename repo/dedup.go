@@ -0,0 +1,203 @@
+package repo
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/utils"
+	"sync"
+	"time"
+)
+
+type (
+	// Dedup 消息去重窗口 [处理前短期占用键, 处理成功后保留至窗口结束, 窗口内重复消息直接确认, 处理失败释放键]
+	Dedup struct {
+		window      time.Duration
+		reservation time.Duration // 处理中占用时长 [进程退出未释放的键 到期后可再次处理]
+		by          string
+		store       facede.DedupStore
+	}
+
+	// RedisDedupStore redis 去重存储 [SETNX + TTL, 多节点共享]
+	RedisDedupStore struct {
+		db     *RedisRepository
+		prefix string
+	}
+
+	// LevelDedupStore leveldb 去重存储 [单节点, 值为过期时间, 过期键占用时覆盖, 定期清理过期键]
+	LevelDedupStore struct {
+		locker   sync.Mutex
+		db       *leveldb.DB
+		prefix   string
+		interval time.Duration // 过期键清理间隔
+		swept    time.Time
+	}
+)
+
+const (
+	// DedupByID 按消息 ID 去重 [无 ID 时按消息体]
+	DedupByID = "id"
+	// DedupByHash 按消息体哈希去重
+	DedupByHash = "hash"
+	// DedupStoreRedis redis 去重存储
+	DedupStoreRedis = "redis"
+	// DedupStoreLevelDB leveldb 去重存储
+	DedupStoreLevelDB = "leveldb"
+	// EnvDedupReservation 处理中占用时长 [默认 1m, 不超过去重窗口]
+	EnvDedupReservation = "QUEUE_DEDUP_RESERVATION"
+	// EnvDedupSweepInterval leveldb 过期键清理间隔 [默认 1m]
+	EnvDedupSweepInterval     = "QUEUE_DEDUP_SWEEP_INTERVAL"
+	defaultDedupWindow        = 10 * time.Minute
+	defaultDedupReservation   = time.Minute
+	defaultDedupSweepInterval = time.Minute
+	dedupKeyPrefix            = "dedup:"
+)
+
+// NewDedup 创建去重窗口 [window 为空时 10m, by: id|hash]
+func NewDedup(window time.Duration, by string, store facede.DedupStore) (*Dedup, error) {
+	if store == nil {
+		return nil, entity.ErrorRequired
+	}
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	switch by {
+	case "":
+		by = DedupByID
+	case DedupByID, DedupByHash:
+	default:
+		return nil, fmt.Errorf("unknown dedup key: %s", by)
+	}
+	var reservation = utils.GetEnvDuration(EnvDedupReservation, defaultDedupReservation)
+	if reservation <= 0 || reservation > window {
+		reservation = window
+	}
+	return &Dedup{window: window, reservation: reservation, by: by, store: store}, nil
+}
+
+// Key 去重键 [队列 + 绑定 + 消息 ID|消息体哈希]
+func (dedup *Dedup) Key(queue, binding string, msg *entity.QueueMessage) string {
+	var id = msg.ID
+	if dedup.by == DedupByHash || id == "" {
+		id = fmt.Sprintf("%x", sha1.Sum(msg.Body))
+	}
+	return fmt.Sprintf("%s:%s:%s", queue, binding, id)
+}
+
+// Reserve 处理前占用去重键 [返回 false 为重复消息]
+func (dedup *Dedup) Reserve(key string) (bool, error) {
+	return dedup.store.Reserve(key, dedup.reservation)
+}
+
+// Commit 处理成功 去重键保留至窗口结束
+func (dedup *Dedup) Commit(key string) error {
+	return dedup.store.Commit(key, dedup.window)
+}
+
+// Release 释放去重键 [处理失败后重试可再次处理]
+func (dedup *Dedup) Release(key string) error {
+	return dedup.store.Release(key)
+}
+
+// Window 去重窗口
+func (dedup *Dedup) Window() time.Duration {
+	return dedup.window
+}
+
+// NewRedisDedupStore 创建 redis 去重存储
+func NewRedisDedupStore(db *RedisRepository) *RedisDedupStore {
+	return &RedisDedupStore{db: db, prefix: dedupKeyPrefix}
+}
+
+func (store *RedisDedupStore) Reserve(key string, ttl time.Duration) (bool, error) {
+	return store.db.SetNX(store.prefix+key, time.Now().Unix(), ttl).Result()
+}
+
+func (store *RedisDedupStore) Commit(key string, ttl time.Duration) error {
+	return store.db.Set(store.prefix+key, time.Now().Unix(), ttl).Err()
+}
+
+func (store *RedisDedupStore) Release(key string) error {
+	return store.db.Del(store.prefix + key).Err()
+}
+
+// NewLevelDedupStore 创建 leveldb 去重存储
+func NewLevelDedupStore(db *leveldb.DB) *LevelDedupStore {
+	return &LevelDedupStore{
+		db:       db,
+		prefix:   dedupKeyPrefix,
+		interval: utils.GetEnvDuration(EnvDedupSweepInterval, defaultDedupSweepInterval),
+		swept:    time.Now(),
+	}
+}
+
+func (store *LevelDedupStore) Reserve(key string, ttl time.Duration) (bool, error) {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	var (
+		k   = []byte(store.prefix + key)
+		now = time.Now()
+	)
+	if store.interval > 0 && now.Sub(store.swept) >= store.interval {
+		if _, err := store.sweep(now); err != nil {
+			return false, err
+		}
+	}
+	v, err := store.db.Get(k, nil)
+	switch {
+	case err == nil && len(v) == 8 && int64(binary.BigEndian.Uint64(v)) > now.UnixNano():
+		return false, nil
+	case err != nil && err != leveldb.ErrNotFound:
+		return false, err
+	}
+	return true, store.put(k, now.Add(ttl))
+}
+
+func (store *LevelDedupStore) Commit(key string, ttl time.Duration) error {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	return store.put([]byte(store.prefix+key), time.Now().Add(ttl))
+}
+
+func (store *LevelDedupStore) Release(key string) error {
+	return store.db.Delete([]byte(store.prefix+key), nil)
+}
+
+// Sweep 删除过期键 返回删除数
+func (store *LevelDedupStore) Sweep() (int, error) {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	return store.sweep(time.Now())
+}
+
+// 删除过期键 [调用方持有锁]
+func (store *LevelDedupStore) sweep(now time.Time) (int, error) {
+	store.swept = now
+	var (
+		batch = new(leveldb.Batch)
+		iter  = store.db.NewIterator(util.BytesPrefix([]byte(store.prefix)), nil)
+	)
+	for iter.Next() {
+		if v := iter.Value(); len(v) != 8 || int64(binary.BigEndian.Uint64(v)) <= now.UnixNano() {
+			batch.Delete(append([]byte{}, iter.Key()...))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	return batch.Len(), store.db.Write(batch, nil)
+}
+
+func (store *LevelDedupStore) put(key []byte, expire time.Time) error {
+	var v = make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(expire.UnixNano()))
+	return store.db.Put(key, v, nil)
+}
@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+	"time"
+)
+
+func newDedupTestStore(t *testing.T) *LevelDedupStore {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewLevelDedupStore(db)
+}
+
+func TestLevelDedupStore_Reserve(t *testing.T) {
+	var store = newDedupTestStore(t)
+	if ok, err := store.Reserve("a", time.Hour); !ok || err != nil {
+		t.Fatalf("LevelDedupStore.Reserve = %v, %v", ok, err)
+	}
+	if ok, _ := store.Reserve("a", time.Hour); ok {
+		t.Error("LevelDedupStore.Reserve duplicate key reserved")
+	}
+	if err := store.Release("a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Reserve("a", 10*time.Millisecond); !ok {
+		t.Error("LevelDedupStore.Reserve released key not reserved")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := store.Reserve("a", time.Hour); !ok {
+		t.Error("LevelDedupStore.Reserve expired key not reserved")
+	}
+}
+
+func TestLevelDedupStore_Sweep(t *testing.T) {
+	var store = newDedupTestStore(t)
+	_, _ = store.Reserve("a", 10*time.Millisecond)
+	_, _ = store.Reserve("b", time.Hour)
+	// 处理成功 延长至完整窗口
+	_, _ = store.Reserve("c", 10*time.Millisecond)
+	if err := store.Commit("c", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n, err := store.Sweep(); n != 1 || err != nil {
+		t.Fatalf("LevelDedupStore.Sweep = %d, %v, want 1", n, err)
+	}
+	if _, err := store.db.Get([]byte(dedupKeyPrefix+"a"), nil); err != leveldb.ErrNotFound {
+		t.Errorf("LevelDedupStore.Sweep expired key kept: %v", err)
+	}
+	if ok, _ := store.Reserve("c", time.Hour); ok {
+		t.Error("LevelDedupStore.Commit key not extended")
+	}
+
+	// 占用时按间隔清理
+	store.interval = 10 * time.Millisecond
+	_, _ = store.Reserve("d", 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	_, _ = store.Reserve("e", time.Hour)
+	if _, err := store.db.Get([]byte(dedupKeyPrefix+"d"), nil); err != leveldb.ErrNotFound {
+		t.Errorf("LevelDedupStore.Reserve expired key not swept: %v", err)
+	}
+}
+
+func TestQueueFanout_Dedup(t *testing.T) {
+	// 处理中短期占用, 确认后保留至窗口结束
+	t.Setenv(EnvDedupReservation, "10ms")
+	dedup, err := NewDedup(time.Hour, DedupByID, newDedupTestStore(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		audit   = &fanoutTestConsumer{action: entity.ConsumeRetry}
+		replies = make(chan entity.ConsumeAction, 1)
+		fanout  = NewQueueFanout("events", nil)
+		msg     = entity.NewQueueMessage("events", []byte(`{}`))
+		reply   = func(action entity.ConsumeAction) error {
+			replies <- action
+			return nil
+		}
+	)
+	defer fanout.Close()
+	if err = fanout.Bind(&QueueBinding{Name: "audit", Consumer: audit, Dedup: dedup}); err != nil {
+		t.Fatal(err)
+	}
+	msg.ID = "m1"
+	// 处理失败释放键 重投时再次处理
+	fanout.Dispatch(msg, reply)
+	waitFanoutReply(t, replies)
+	audit.action = entity.ConsumeAck
+	fanout.Dispatch(msg, reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("QueueFanout dedup retry reply %s", action)
+	}
+	// 重复消息确认 不再处理
+	time.Sleep(20 * time.Millisecond)
+	fanout.Dispatch(msg, reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("QueueFanout duplicate reply %s", action)
+	}
+	if n := audit.count(); n != 2 {
+		t.Errorf("QueueFanout dedup handled %d, want 2", n)
+	}
+}
@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"errors"
+	"github.com/weblfe/queue_mgr/facede"
+	"strings"
+	"sync"
+)
+
+type (
+	// QueueEntryCreator 队列驱动构造器
+	QueueEntryCreator func(namespace ...string) facede.QueueEntry
+
+	queueDriverRepository struct {
+		locker   sync.RWMutex
+		creators map[string]QueueEntryCreator
+		entries  map[string]facede.QueueEntry
+	}
+)
+
+const (
+	DriverAmqp = "AMQP"
+)
+
+var (
+	queueDrivers        = newQueueDriverRepository()
+	ErrorDriverNotFound = errors.New("queue driver not found")
+)
+
+var (
+	_ facede.QueueEntry   = (*RabbitmqUtils)(nil)
+	_ facede.QueueEntry   = (*RedisStreamQueue)(nil)
+	_ facede.DelayedQueue = (*RabbitmqUtils)(nil)
+	_ facede.DelayedQueue = (*RedisStreamQueue)(nil)
+)
+
+func newQueueDriverRepository() *queueDriverRepository {
+	var repo = new(queueDriverRepository)
+	repo.locker = sync.RWMutex{}
+	repo.creators = make(map[string]QueueEntryCreator)
+	repo.entries = make(map[string]facede.QueueEntry)
+	repo.Register(DriverAmqp, func(namespace ...string) facede.QueueEntry {
+		var entry = RabbitmqOf(namespace...)
+		return &entry
+	})
+	repo.Register(DriverRedis, func(namespace ...string) facede.QueueEntry {
+		return RedisStreamOf(namespace...)
+	})
+	return repo
+}
+
+// GetQueueDriverRepo 获取队列驱动库
+func GetQueueDriverRepo() *queueDriverRepository {
+	return queueDrivers
+}
+
+// Register 注册队列驱动
+func (repo *queueDriverRepository) Register(driver string, creator QueueEntryCreator) bool {
+	if driver == "" || creator == nil {
+		return false
+	}
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	repo.creators[strings.ToUpper(driver)] = creator
+	return true
+}
+
+// Create 创建新的队列实例
+func (repo *queueDriverRepository) Create(driver string, namespace ...string) (facede.QueueEntry, error) {
+	repo.locker.RLock()
+	defer repo.locker.RUnlock()
+	if driver == "" {
+		driver = DriverAmqp
+	}
+	creator, ok := repo.creators[strings.ToUpper(driver)]
+	if !ok {
+		return nil, ErrorDriverNotFound
+	}
+	return creator(namespace...), nil
+}
+
+// Get 获取共享的队列实例 [管理操作使用]
+func (repo *queueDriverRepository) Get(driver string, namespace ...string) (facede.QueueEntry, error) {
+	namespace = append(namespace, "")
+	if driver == "" {
+		driver = DriverAmqp
+	}
+	var key = strings.ToUpper(driver) + "." + namespace[0]
+	repo.locker.RLock()
+	entry, ok := repo.entries[key]
+	repo.locker.RUnlock()
+	if ok {
+		return entry, nil
+	}
+	entry, err := repo.Create(driver, namespace[0])
+	if err != nil {
+		return nil, err
+	}
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	if exists, ok := repo.entries[key]; ok {
+		return exists, nil
+	}
+	repo.entries[key] = entry
+	return entry, nil
+}
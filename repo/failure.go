@@ -0,0 +1,30 @@
+package repo
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"sync"
+)
+
+var (
+	failureRecorder       facede.FailureRecorder
+	failureRecorderLocker sync.RWMutex
+)
+
+// SetFailureRecorder 设置消费失败记录器
+func SetFailureRecorder(recorder facede.FailureRecorder) {
+	failureRecorderLocker.Lock()
+	defer failureRecorderLocker.Unlock()
+	failureRecorder = recorder
+}
+
+// RecordFailure 记录消费失败 [未设置记录器时忽略]
+func RecordFailure(failure *entity.ConsumeFailure) {
+	failureRecorderLocker.RLock()
+	var recorder = failureRecorder
+	failureRecorderLocker.RUnlock()
+	if recorder == nil || failure == nil {
+		return
+	}
+	recorder.Record(failure)
+}
@@ -0,0 +1,255 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/yookoala/gofast"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// FastCgiMember 负载均衡组成员
+	FastCgiMember struct {
+		Network string
+		Addr    string
+		Weight  int
+	}
+
+	// FastCgiGroupOptions 负载均衡组参数
+	FastCgiGroupOptions struct {
+		// 选择策略 round_robin|least_conn
+		Balance string
+		// fail_timeout 内连续失败次数 达到后摘除
+		MaxFails int
+		// 摘除时长 到期自动恢复
+		FailTimeout time.Duration
+	}
+
+	// FastCgiGroup FastCGI 负载均衡组 [加权轮询|最少连接, 被动失败摘除]
+	FastCgiGroup struct {
+		locker      sync.Mutex
+		name        string
+		options     FastCgiGroupOptions
+		poolOptions FastCgiPoolOptions // 成员连接池参数
+		members     []*fastCgiGroupMember
+	}
+
+	fastCgiGroupMember struct {
+		pool       *FastCgiPool
+		weight     int
+		current    int
+		active     int
+		fails      int
+		failAt     time.Time
+		ejectUntil time.Time
+	}
+
+	// 组连接 Close 时回报成员处理结果
+	fastCgiGroupClient struct {
+		gofast.Client
+		group  *FastCgiGroup
+		member *fastCgiGroupMember
+	}
+)
+
+const (
+	BalanceRoundRobin         = "round_robin"
+	BalanceLeastConn          = "least_conn"
+	defaultFastCgiFailTimeout = 10 * time.Second
+)
+
+var (
+	ErrorFastCgiNoUpstream                        = errors.New("fastcgi no upstream available")
+	_                      facede.FastCgiUpstream = (*FastCgiPool)(nil)
+	_                      facede.FastCgiUpstream = (*FastCgiGroup)(nil)
+)
+
+// Group 获取负载均衡组 [相同成员及策略共享, 成员复用地址连接池, 摘除参数不一致时返回 ErrorFastCgiConflict]
+func (repo *fastCgiPoolRepository) Group(members []FastCgiMember, options FastCgiGroupOptions, poolOptions FastCgiPoolOptions) (*FastCgiGroup, error) {
+	if len(members) == 0 {
+		return nil, ErrorFastCgiNoUpstream
+	}
+	options = options.load()
+	var names = make([]string, 0, len(members))
+	for _, m := range members {
+		names = append(names, fmt.Sprintf("%s://%s|%d", m.Network, m.Addr, m.Weight))
+	}
+	sort.Strings(names)
+	var key = options.Balance + "@" + strings.Join(names, ",")
+	repo.locker.Lock()
+	group, ok := repo.groups[key]
+	repo.locker.Unlock()
+	if ok {
+		return group.match(options, poolOptions)
+	}
+	group = &FastCgiGroup{name: strings.Join(names, ","), options: options, poolOptions: poolOptions.load()}
+	for _, m := range members {
+		if m.Weight <= 0 {
+			m.Weight = 1
+		}
+		pool, err := repo.Get(m.Network, m.Addr, poolOptions)
+		if err != nil {
+			return nil, err
+		}
+		group.members = append(group.members, &fastCgiGroupMember{
+			pool:   pool,
+			weight: m.Weight,
+		})
+	}
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	if exists, ok := repo.groups[key]; ok {
+		return exists.match(options, poolOptions)
+	}
+	repo.groups[key] = group
+	return group, nil
+}
+
+// 共享组参数一致检查 [摘除参数及成员连接池参数]
+func (group *FastCgiGroup) match(options FastCgiGroupOptions, poolOptions FastCgiPoolOptions) (*FastCgiGroup, error) {
+	if group.options != options || group.poolOptions != poolOptions.load() {
+		return nil, fmt.Errorf("%w: %s@%s", ErrorFastCgiConflict, options.Balance, group.name)
+	}
+	return group, nil
+}
+
+func (options FastCgiGroupOptions) load() FastCgiGroupOptions {
+	options.Balance = strings.ToLower(options.Balance)
+	if options.Balance != BalanceLeastConn {
+		options.Balance = BalanceRoundRobin
+	}
+	if options.MaxFails <= 0 {
+		options.MaxFails = 1
+	}
+	if options.FailTimeout <= 0 {
+		options.FailTimeout = defaultFastCgiFailTimeout
+	}
+	return options
+}
+
+// Addr 成员地址列表
+func (group *FastCgiGroup) Addr() string {
+	return group.name
+}
+
+// CreateClient 选择成员获取连接 [连接失败时切换下一个成员]
+func (group *FastCgiGroup) CreateClient() (gofast.Client, error) {
+	var (
+		tried   = make(map[*fastCgiGroupMember]bool)
+		lastErr = ErrorFastCgiNoUpstream
+	)
+	for i := 0; i < len(group.members); i++ {
+		var member = group.pick(tried)
+		if member == nil {
+			break
+		}
+		tried[member] = true
+		client, err := member.pool.CreateClient()
+		if err != nil {
+			// 连接数已满不视为成员故障
+			group.done(member, err != ErrorFastCgiExhausted)
+			lastErr = err
+			continue
+		}
+		return &fastCgiGroupClient{Client: client, group: group, member: member}, nil
+	}
+	return nil, lastErr
+}
+
+// Stats 成员状态
+func (group *FastCgiGroup) Stats() []FastCgiPoolStats {
+	var stats = make([]FastCgiPoolStats, 0, len(group.members))
+	for _, member := range group.members {
+		stats = append(stats, member.pool.Stats())
+	}
+	return stats
+}
+
+// 选择成员 [跳过不健康及摘除中的成员, 全部不可用时忽略摘除状态]
+func (group *FastCgiGroup) pick(tried map[*fastCgiGroupMember]bool) *fastCgiGroupMember {
+	group.locker.Lock()
+	defer group.locker.Unlock()
+	var (
+		now        = time.Now()
+		candidates []*fastCgiGroupMember
+		fallback   []*fastCgiGroupMember
+	)
+	for _, member := range group.members {
+		if tried[member] || !member.pool.Healthy() {
+			continue
+		}
+		fallback = append(fallback, member)
+		if now.After(member.ejectUntil) {
+			candidates = append(candidates, member)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallback
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	var best *fastCgiGroupMember
+	if group.options.Balance == BalanceLeastConn {
+		for _, member := range candidates {
+			if best == nil || member.active*best.weight < best.active*member.weight {
+				best = member
+			}
+		}
+	} else {
+		// 平滑加权轮询
+		var total = 0
+		for _, member := range candidates {
+			member.current += member.weight
+			total += member.weight
+			if best == nil || member.current > best.current {
+				best = member
+			}
+		}
+		best.current -= total
+	}
+	best.active++
+	return best
+}
+
+// 回报处理结果 [fail_timeout 内失败 max_fails 次 摘除 fail_timeout]
+func (group *FastCgiGroup) done(member *fastCgiGroupMember, failed bool) {
+	group.locker.Lock()
+	defer group.locker.Unlock()
+	member.active--
+	if !failed {
+		member.fails = 0
+		return
+	}
+	var now = time.Now()
+	if now.Sub(member.failAt) > group.options.FailTimeout {
+		member.fails = 0
+	}
+	member.fails++
+	member.failAt = now
+	if member.fails >= group.options.MaxFails {
+		member.fails = 0
+		member.ejectUntil = now.Add(group.options.FailTimeout)
+		GetLogger("fastcgi").WithField("upstream", member.pool.Addr()).
+			Warnln("fastcgi upstream ejected for", group.options.FailTimeout)
+	}
+}
+
+// Close 归还连接并回报成员处理结果
+func (client *fastCgiGroupClient) Close() error {
+	if client.member == nil {
+		return nil
+	}
+	var failed bool
+	if inner, ok := client.Client.(*fastCgiPoolClient); ok {
+		failed = inner.broken()
+	}
+	var err = client.Client.Close()
+	client.group.done(client.member, failed)
+	client.member = nil
+	return err
+}
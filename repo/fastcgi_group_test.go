@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/yookoala/gofast"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFastCgiGroup(t *testing.T) {
+	var hits [2]int32
+	var members []FastCgiMember
+	for i := range hits {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		var counter = &hits[i]
+		go func() {
+			_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(counter, 1)
+			}))
+		}()
+		members = append(members, FastCgiMember{Network: "tcp", Addr: listener.Addr().String(), Weight: 3 - 2*i})
+	}
+	// 不可用成员
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+	members = append(members, FastCgiMember{Network: "tcp", Addr: dead.Addr().String(), Weight: 1})
+
+	group, err := GetFastCgiPoolRepo().Group(members, FastCgiGroupOptions{FailTimeout: 200 * time.Millisecond}, FastCgiPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var call = func() error {
+		var ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/index.php", nil)
+		req.RemoteAddr = "127.0.0.1:0"
+		client, err := group.CreateClient()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		resp, err := gofast.NewFileEndpoint("/index.php")(gofast.BasicSession)(client, gofast.NewRequest(req))
+		if err != nil {
+			return err
+		}
+		return resp.WriteTo(&pingRecorder{header: http.Header{}}, ioutil.Discard)
+	}
+	// 不可用成员失败切换 并被摘除
+	for i := 0; i < 8; i++ {
+		if err = call(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, b := atomic.LoadInt32(&hits[0]), atomic.LoadInt32(&hits[1]); a != 6 || b != 2 {
+		t.Fatalf("expect weighted 6:2, got %d:%d", a, b)
+	}
+	var dm = group.members[2]
+	group.locker.Lock()
+	var ejected = time.Now().Before(dm.ejectUntil)
+	group.locker.Unlock()
+	if !ejected {
+		t.Fatal("expect dead member ejected")
+	}
+	// 到期后重新参与选择
+	time.Sleep(250 * time.Millisecond)
+	var seen = map[*fastCgiGroupMember]bool{}
+	for i := 0; i < 5; i++ {
+		var member = group.pick(map[*fastCgiGroupMember]bool{})
+		seen[member] = true
+		group.done(member, false)
+	}
+	if !seen[dm] {
+		t.Fatal("expect ejected member re-admitted")
+	}
+}
+
+func TestFastCgiPoolRepository_GroupConflict(t *testing.T) {
+	var repo = newFastCgiPoolRepository()
+	// 相同成员及策略 摘除参数一致时共享, 不一致时拒绝
+	var members = []FastCgiMember{{Network: "tcp", Addr: "127.0.0.1:19001", Weight: 1}, {Network: "tcp", Addr: "127.0.0.1:19002", Weight: 1}}
+	group, err := repo.Group(members, FastCgiGroupOptions{MaxFails: 2}, FastCgiPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, err := repo.Group(members, FastCgiGroupOptions{MaxFails: 2, FailTimeout: defaultFastCgiFailTimeout}, FastCgiPoolOptions{}); shared != group || err != nil {
+		t.Fatalf("expect shared group, got %v", err)
+	}
+	if _, err = repo.Group(members, FastCgiGroupOptions{MaxFails: 5}, FastCgiPoolOptions{}); !errors.Is(err, ErrorFastCgiConflict) {
+		t.Fatalf("expect group max_fails conflict, got %v", err)
+	}
+	if _, err = repo.Group(members, FastCgiGroupOptions{MaxFails: 2, FailTimeout: time.Minute}, FastCgiPoolOptions{}); !errors.Is(err, ErrorFastCgiConflict) {
+		t.Fatalf("expect group fail_timeout conflict, got %v", err)
+	}
+	// 不同策略 独立组, 成员连接池参数须一致
+	if other, err := repo.Group(members, FastCgiGroupOptions{Balance: BalanceLeastConn, MaxFails: 2}, FastCgiPoolOptions{}); other == group || err != nil {
+		t.Fatalf("expect separate least_conn group, got %v", err)
+	}
+	if _, err = repo.Group(members, FastCgiGroupOptions{Balance: BalanceLeastConn, MaxFails: 2}, FastCgiPoolOptions{MaxOpen: 4}); !errors.Is(err, ErrorFastCgiConflict) {
+		t.Fatalf("expect member pool conflict, got %v", err)
+	}
+}
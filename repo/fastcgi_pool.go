@@ -0,0 +1,479 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yookoala/gofast"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// FastCgiPoolOptions FastCGI 连接池参数
+	FastCgiPoolOptions struct {
+		// 最大空闲连接
+		MaxIdle int
+		// 最大打开连接 0:不限制
+		MaxOpen int
+		// 空闲连接过期时长
+		IdleTimeout time.Duration
+		// 建立连接|等待空闲连接 超时
+		DialTimeout time.Duration
+		// php-fpm ping.path 为空时不做健康检查
+		PingPath string
+		// 健康检查间隔
+		PingInterval time.Duration
+		// 连续失败次数 判定不健康
+		MaxFails int
+	}
+
+	// FastCgiPool FastCGI 连接池 [同一 upstream 共享]
+	FastCgiPool struct {
+		locker  sync.Mutex
+		network string
+		addr    string
+		options FastCgiPoolOptions
+		idle    []*fastCgiConn
+		slots   chan struct{}
+		open    int32
+		healthy int32
+		fails   int
+		closed  chan struct{}
+		once    sync.Once
+	}
+
+	// FastCgiPoolStats 连接池状态
+	FastCgiPoolStats struct {
+		Addr    string `json:"addr"`
+		Open    int    `json:"open"`
+		Idle    int    `json:"idle"`
+		Healthy bool   `json:"healthy"`
+	}
+
+	fastCgiConn struct {
+		client gofast.Client
+		conn   *trackedConn
+		usedAt time.Time
+	}
+
+	// 记录读写错误的连接 [出错后不再复用]
+	trackedConn struct {
+		net.Conn
+		failed int32
+	}
+
+	// 池化客户端 Close 时归还连接
+	fastCgiPoolClient struct {
+		pool *FastCgiPool
+		conn *fastCgiConn
+		req  *gofast.Request
+	}
+
+	// 健康检查响应状态
+	pingRecorder struct {
+		code   int
+		header http.Header
+	}
+
+	fastCgiPoolRepository struct {
+		locker sync.Mutex
+		pools  map[string]*FastCgiPool
+		groups map[string]*FastCgiGroup
+	}
+)
+
+const (
+	defaultFastCgiMaxIdle      = 4
+	defaultFastCgiIdleTimeout  = time.Minute
+	defaultFastCgiDialTimeout  = 5 * time.Second
+	defaultFastCgiPingInterval = 10 * time.Second
+	defaultFastCgiMaxFails     = 3
+)
+
+var (
+	ErrorFastCgiUnhealthy = errors.New("fastcgi upstream unhealthy")
+	ErrorFastCgiExhausted = errors.New("fastcgi connection pool exhausted")
+	ErrorFastCgiClosed    = errors.New("fastcgi connection pool closed")
+	ErrorFastCgiConflict  = errors.New("fastcgi upstream options conflict")
+	fastCgiPools          = newFastCgiPoolRepository()
+)
+
+func newFastCgiPoolRepository() *fastCgiPoolRepository {
+	var repo = new(fastCgiPoolRepository)
+	repo.locker = sync.Mutex{}
+	repo.pools = make(map[string]*FastCgiPool)
+	repo.groups = make(map[string]*FastCgiGroup)
+	return repo
+}
+
+// GetFastCgiPoolRepo 获取 FastCGI 连接池库
+func GetFastCgiPoolRepo() *fastCgiPoolRepository {
+	return fastCgiPools
+}
+
+// Get 获取 upstream 连接池 [不存在时按参数创建, 同一地址参数不一致时返回 ErrorFastCgiConflict]
+func (repo *fastCgiPoolRepository) Get(network, addr string, options FastCgiPoolOptions) (*FastCgiPool, error) {
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	var key = network + "://" + addr
+	if pool, ok := repo.pools[key]; ok {
+		if pool.options != options.load() {
+			return nil, fmt.Errorf("%w: %s", ErrorFastCgiConflict, key)
+		}
+		return pool, nil
+	}
+	var pool = NewFastCgiPool(network, addr, options)
+	repo.pools[key] = pool
+	return pool, nil
+}
+
+// Remove 移除并关闭 upstream 连接池
+func (repo *fastCgiPoolRepository) Remove(network, addr string) {
+	repo.locker.Lock()
+	var key = network + "://" + addr
+	pool, ok := repo.pools[key]
+	delete(repo.pools, key)
+	repo.locker.Unlock()
+	if ok {
+		pool.Close()
+	}
+}
+
+// Stats 所有连接池状态
+func (repo *fastCgiPoolRepository) Stats() []FastCgiPoolStats {
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	var stats = make([]FastCgiPoolStats, 0, len(repo.pools))
+	for _, pool := range repo.pools {
+		stats = append(stats, pool.Stats())
+	}
+	return stats
+}
+
+func NewFastCgiPool(network, addr string, options FastCgiPoolOptions) *FastCgiPool {
+	var pool = new(FastCgiPool)
+	pool.network = network
+	pool.addr = addr
+	pool.options = options.load()
+	pool.healthy = 1
+	pool.closed = make(chan struct{})
+	if pool.options.MaxOpen > 0 {
+		pool.slots = make(chan struct{}, pool.options.MaxOpen)
+	}
+	if pool.options.PingPath != "" {
+		go pool.check()
+	}
+	return pool
+}
+
+func (options FastCgiPoolOptions) load() FastCgiPoolOptions {
+	if options.MaxIdle <= 0 {
+		options.MaxIdle = defaultFastCgiMaxIdle
+	}
+	if options.MaxOpen > 0 && options.MaxIdle > options.MaxOpen {
+		options.MaxIdle = options.MaxOpen
+	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = defaultFastCgiIdleTimeout
+	}
+	if options.DialTimeout <= 0 {
+		options.DialTimeout = defaultFastCgiDialTimeout
+	}
+	if options.PingInterval <= 0 {
+		options.PingInterval = defaultFastCgiPingInterval
+	}
+	if options.MaxFails <= 0 {
+		options.MaxFails = defaultFastCgiMaxFails
+	}
+	return options
+}
+
+// CreateClient 获取连接 [实现 gofast.ClientFactory, Close 归还连接池]
+func (pool *FastCgiPool) CreateClient() (gofast.Client, error) {
+	if pool.isClosed() {
+		return nil, ErrorFastCgiClosed
+	}
+	if !pool.Healthy() {
+		return nil, ErrorFastCgiUnhealthy
+	}
+	if err := pool.acquire(); err != nil {
+		return nil, err
+	}
+	if conn := pool.popIdle(); conn != nil {
+		return &fastCgiPoolClient{pool: pool, conn: conn}, nil
+	}
+	conn, err := pool.dial()
+	if err != nil {
+		pool.release()
+		return nil, err
+	}
+	return &fastCgiPoolClient{pool: pool, conn: conn}, nil
+}
+
+// Healthy upstream 是否健康
+func (pool *FastCgiPool) Healthy() bool {
+	return atomic.LoadInt32(&pool.healthy) == 1
+}
+
+// Addr upstream 地址
+func (pool *FastCgiPool) Addr() string {
+	return pool.addr
+}
+
+// Stats 连接池状态
+func (pool *FastCgiPool) Stats() FastCgiPoolStats {
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	return FastCgiPoolStats{
+		Addr:    pool.addr,
+		Open:    int(atomic.LoadInt32(&pool.open)),
+		Idle:    len(pool.idle),
+		Healthy: pool.Healthy(),
+	}
+}
+
+// Close 关闭连接池 [使用中的连接归还时关闭]
+func (pool *FastCgiPool) Close() {
+	pool.once.Do(func() {
+		close(pool.closed)
+		pool.evict()
+	})
+}
+
+// 占用连接数 [max_open 限制]
+func (pool *FastCgiPool) acquire() error {
+	if pool.slots == nil {
+		return nil
+	}
+	var timer = time.NewTimer(pool.options.DialTimeout)
+	defer timer.Stop()
+	select {
+	case pool.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrorFastCgiExhausted
+	case <-pool.closed:
+		return ErrorFastCgiClosed
+	}
+}
+
+func (pool *FastCgiPool) release() {
+	if pool.slots != nil {
+		<-pool.slots
+	}
+}
+
+// 取空闲连接 [过期连接直接关闭]
+func (pool *FastCgiPool) popIdle() *fastCgiConn {
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	for len(pool.idle) > 0 {
+		var conn = pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		if time.Since(conn.usedAt) < pool.options.IdleTimeout {
+			return conn
+		}
+		pool.closeConn(conn)
+	}
+	return nil
+}
+
+// 归还连接 [连接出错|超时中断|不健康|空闲已满 时关闭]
+func (pool *FastCgiPool) put(conn *fastCgiConn, broken bool) {
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	defer pool.release()
+	if broken || conn.conn.broken() || !pool.Healthy() || pool.isClosed() || len(pool.idle) >= pool.options.MaxIdle {
+		pool.closeConn(conn)
+		return
+	}
+	conn.usedAt = time.Now()
+	pool.idle = append(pool.idle, conn)
+}
+
+func (pool *FastCgiPool) dial() (*fastCgiConn, error) {
+	c, err := net.DialTimeout(pool.network, pool.addr, pool.options.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var conn = &trackedConn{Conn: c}
+	client, err := gofast.SimpleClientFactory(func() (net.Conn, error) {
+		return conn, nil
+	})()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	atomic.AddInt32(&pool.open, 1)
+	return &fastCgiConn{client: client, conn: conn, usedAt: time.Now()}, nil
+}
+
+func (pool *FastCgiPool) closeConn(conn *fastCgiConn) {
+	_ = conn.client.Close()
+	atomic.AddInt32(&pool.open, -1)
+}
+
+// 关闭全部空闲连接
+func (pool *FastCgiPool) evict() {
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	for _, conn := range pool.idle {
+		pool.closeConn(conn)
+	}
+	pool.idle = nil
+}
+
+func (pool *FastCgiPool) isClosed() bool {
+	select {
+	case <-pool.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// 定时健康检查 [连续失败 max_fails 次 标记不健康并清空空闲连接, 恢复后重新可用]
+func (pool *FastCgiPool) check() {
+	var ticker = time.NewTicker(pool.options.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.closed:
+			return
+		case <-ticker.C:
+			pool.report(pool.Ping())
+		}
+	}
+}
+
+func (pool *FastCgiPool) report(err error) {
+	pool.locker.Lock()
+	var changed bool
+	if err == nil {
+		pool.fails = 0
+		changed = atomic.SwapInt32(&pool.healthy, 1) == 0
+	} else {
+		pool.fails++
+		if pool.fails >= pool.options.MaxFails {
+			changed = atomic.SwapInt32(&pool.healthy, 0) == 1
+		}
+	}
+	pool.locker.Unlock()
+	if !changed {
+		return
+	}
+	var logger = GetLogger("fastcgi").WithField("upstream", pool.addr)
+	if err == nil {
+		logger.Infoln("fastcgi upstream recovered")
+		return
+	}
+	pool.evict()
+	logger.Warnln("fastcgi upstream unhealthy:", err)
+}
+
+// Ping 请求 php-fpm ping.path [独立连接, 不占用连接池]
+func (pool *FastCgiPool) Ping() error {
+	var ctx, cancel = context.WithTimeout(context.Background(), pool.options.DialTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+pool.options.PingPath, nil)
+	if err != nil {
+		return err
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	conn, err := pool.dial()
+	if err != nil {
+		return err
+	}
+	defer pool.closeConn(conn)
+	var session = gofast.Chain(
+		gofast.BasicParamsMap,
+		gofast.MapHeader,
+		gofast.MapEndpoint(pool.options.PingPath),
+	)(gofast.BasicSession)
+	resp, err := session(conn.client, gofast.NewRequest(req))
+	if err != nil {
+		return err
+	}
+	var recorder = &pingRecorder{header: http.Header{}}
+	if err = resp.WriteTo(recorder, ioutil.Discard); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if recorder.code < 200 || recorder.code >= 300 {
+		return fmt.Errorf("fastcgi ping response %d", recorder.code)
+	}
+	return nil
+}
+
+func (client *fastCgiPoolClient) Do(req *gofast.Request) (*gofast.ResponsePipe, error) {
+	if client.conn == nil {
+		return nil, ErrorFastCgiClosed
+	}
+	client.req = req
+	return client.conn.client.Do(req)
+}
+
+// Close 归还连接池 [请求超时中断的连接不再复用]
+func (client *fastCgiPoolClient) Close() error {
+	if client.conn == nil {
+		return nil
+	}
+	client.pool.put(client.conn, client.broken())
+	client.conn = nil
+	return nil
+}
+
+// 连接出错 或 请求超时中断
+func (client *fastCgiPoolClient) broken() bool {
+	if client.conn == nil {
+		return false
+	}
+	if client.conn.conn.broken() {
+		return true
+	}
+	return client.req != nil && client.req.Raw != nil && client.req.Raw.Context().Err() != nil
+}
+
+func (conn *trackedConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	if err != nil {
+		atomic.StoreInt32(&conn.failed, 1)
+	}
+	return n, err
+}
+
+func (conn *trackedConn) Write(p []byte) (int, error) {
+	n, err := conn.Conn.Write(p)
+	if err != nil {
+		atomic.StoreInt32(&conn.failed, 1)
+	}
+	return n, err
+}
+
+func (conn *trackedConn) broken() bool {
+	return atomic.LoadInt32(&conn.failed) == 1
+}
+
+func (recorder *pingRecorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *pingRecorder) Write(p []byte) (int, error) {
+	if recorder.code == 0 {
+		recorder.code = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (recorder *pingRecorder) WriteHeader(code int) {
+	if recorder.code == 0 {
+		recorder.code = code
+	}
+}
@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"github.com/yookoala/gofast"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFastCgiPool(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var down int32
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&down) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.URL.Path == "/slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			_, _ = w.Write([]byte("pong"))
+		}))
+	}()
+
+	var pool = NewFastCgiPool("tcp", listener.Addr().String(), FastCgiPoolOptions{MaxOpen: 2, MaxFails: 2, PingPath: "/ping", PingInterval: time.Hour})
+	defer pool.Close()
+	var call = func(path string, timeout time.Duration) {
+		var ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+		req.RemoteAddr = "127.0.0.1:0"
+		client, err := pool.CreateClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		resp, err := gofast.NewFileEndpoint(path)(gofast.BasicSession)(client, gofast.NewRequest(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.WriteTo(&pingRecorder{header: http.Header{}}, ioutil.Discard)
+	}
+
+	// 连接复用
+	for i := 0; i < 3; i++ {
+		call("/index.php", time.Second)
+	}
+	if stats := pool.Stats(); stats.Open != 1 || stats.Idle != 1 {
+		t.Fatalf("expect reused connection, got %+v", stats)
+	}
+	// 超时连接不再复用
+	call("/slow", 50*time.Millisecond)
+	if stats := pool.Stats(); stats.Open != 0 {
+		t.Fatalf("expect timeout connection closed, got %+v", stats)
+	}
+	// 健康检查
+	if err = pool.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&down, 1)
+	for i := 0; i < 2; i++ {
+		pool.report(pool.Ping())
+	}
+	if pool.Healthy() || pool.Stats().Idle != 0 {
+		t.Fatalf("expect unhealthy and evicted, got %+v", pool.Stats())
+	}
+	if _, err = pool.CreateClient(); err != ErrorFastCgiUnhealthy {
+		t.Fatalf("expect unhealthy error, got %v", err)
+	}
+	atomic.StoreInt32(&down, 0)
+	pool.report(pool.Ping())
+	if !pool.Healthy() {
+		t.Fatal("expect recovered")
+	}
+}
+
+func TestFastCgiPoolRepository_Conflict(t *testing.T) {
+	var repo = newFastCgiPoolRepository()
+	// 同一地址 参数一致时共享, 不一致时拒绝
+	pool, err := repo.Get("tcp", "127.0.0.1:19000", FastCgiPoolOptions{MaxOpen: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if shared, err := repo.Get("tcp", "127.0.0.1:19000", FastCgiPoolOptions{MaxOpen: 8, MaxIdle: defaultFastCgiMaxIdle}); shared != pool || err != nil {
+		t.Fatalf("expect shared pool, got %v", err)
+	}
+	if _, err = repo.Get("tcp", "127.0.0.1:19000", FastCgiPoolOptions{MaxOpen: 16}); !errors.Is(err, ErrorFastCgiConflict) {
+		t.Fatalf("expect pool conflict, got %v", err)
+	}
+}
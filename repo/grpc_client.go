@@ -0,0 +1,197 @@
+package repo
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io/ioutil"
+	"sync"
+)
+
+type (
+	// 原始字节编解码 [请求按描述符预先编码, 响应保留原始字节]
+	grpcRawCodec struct{}
+
+	grpcConnRepository struct {
+		locker sync.Mutex
+		conns  map[string]*grpc.ClientConn
+	}
+)
+
+var (
+	// GrpcRawCodec 原始字节编解码器 [调用时 grpc.ForceCodec 指定]
+	GrpcRawCodec = grpcRawCodec{}
+	grpcConns    = newGrpcConnRepository()
+)
+
+func (grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch data := v.(type) {
+	case []byte:
+		return data, nil
+	case *[]byte:
+		return *data, nil
+	}
+	return nil, fmt.Errorf("grpc raw codec: unsupported type %T", v)
+}
+
+func (grpcRawCodec) Unmarshal(data []byte, v interface{}) error {
+	var dst, ok = v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("grpc raw codec: unsupported type %T", v)
+	}
+	*dst = append((*dst)[:0], data...)
+	return nil
+}
+
+func (grpcRawCodec) Name() string {
+	return "proto"
+}
+
+func newGrpcConnRepository() *grpcConnRepository {
+	var repo = new(grpcConnRepository)
+	repo.locker = sync.Mutex{}
+	repo.conns = make(map[string]*grpc.ClientConn)
+	return repo
+}
+
+// GetGrpcConnRepo 获取 gRPC 连接库
+func GetGrpcConnRepo() *grpcConnRepository {
+	return grpcConns
+}
+
+// Get 获取连接 [同一地址共享, 不存在时创建]
+func (repo *grpcConnRepository) Get(target string, secure bool) (*grpc.ClientConn, error) {
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	var key = fmt.Sprintf("%s|%t", target, secure)
+	if conn, ok := repo.conns[key]; ok {
+		return conn, nil
+	}
+	var option = grpc.WithInsecure()
+	if secure {
+		option = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	}
+	conn, err := grpc.Dial(target, option)
+	if err != nil {
+		return nil, err
+	}
+	repo.conns[key] = conn
+	return conn, nil
+}
+
+// Remove 移除并关闭连接
+func (repo *grpcConnRepository) Remove(target string, secure bool) {
+	repo.locker.Lock()
+	var key = fmt.Sprintf("%s|%t", target, secure)
+	conn, ok := repo.conns[key]
+	delete(repo.conns, key)
+	repo.locker.Unlock()
+	if ok {
+		_ = conn.Close()
+	}
+}
+
+// LoadGrpcDescriptorSet 加载描述符集文件 [protoc --include_imports --descriptor_set_out]
+func LoadGrpcDescriptorSet(file string) (*protoregistry.Files, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set = new(descriptorpb.FileDescriptorSet)
+	if err = proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return protodesc.NewFiles(set)
+}
+
+// GrpcReflect 通过服务反射获取包含 symbol 的描述文件及其依赖
+func GrpcReflect(ctx context.Context, conn *grpc.ClientConn, symbol string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+	var (
+		set       = new(descriptorpb.FileDescriptorSet)
+		loaded    = make(map[string]bool)
+		requested = make(map[string]bool)
+		request   = &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+		}
+		queue []string
+		name  = symbol
+	)
+	for request != nil {
+		if err = stream.Send(request); err != nil {
+			return nil, err
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := response.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("grpc reflect %s: %s", name, e.GetErrorMessage())
+		}
+		var files []*descriptorpb.FileDescriptorProto
+		for _, data := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var file = new(descriptorpb.FileDescriptorProto)
+			if err = proto.Unmarshal(data, file); err != nil {
+				return nil, fmt.Errorf("grpc reflect %s: %v", name, err)
+			}
+			if !loaded[file.GetName()] {
+				loaded[file.GetName()] = true
+				set.File = append(set.File, file)
+				files = append(files, file)
+			}
+		}
+		// 依赖未随响应返回时 按文件名补充获取
+		for _, file := range files {
+			for _, dependency := range file.GetDependency() {
+				if !loaded[dependency] && !requested[dependency] {
+					requested[dependency] = true
+					queue = append(queue, dependency)
+				}
+			}
+		}
+		request = nil
+		for len(queue) > 0 && request == nil {
+			name, queue = queue[0], queue[1:]
+			if !loaded[name] {
+				request = &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+				}
+			}
+		}
+	}
+	return protodesc.NewFiles(set)
+}
+
+// GrpcFindMethod 查找一元调用方法 [service: 包名.服务名]
+func GrpcFindMethod(files *protoregistry.Files, service, method string) (protoreflect.MethodDescriptor, error) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("grpc service %s: %v", service, err)
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("grpc %s is not a service", service)
+	}
+	var methodDesc = serviceDesc.Methods().ByName(protoreflect.Name(method))
+	if methodDesc == nil {
+		return nil, fmt.Errorf("grpc method %s/%s not found", service, method)
+	}
+	if methodDesc.IsStreamingClient() || methodDesc.IsStreamingServer() {
+		return nil, fmt.Errorf("grpc method %s/%s is not unary", service, method)
+	}
+	return methodDesc, nil
+}
@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"bytes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"strings"
+)
+
+// 描述文件中的消息及扩展类型 [Any 内嵌消息及扩展字段解析, 未找到时使用全局注册类型]
+type grpcTypes struct {
+	files *protoregistry.Files
+}
+
+// GrpcEncodeJson json 按消息描述编码为 protobuf 字节 [protojson 映射规则]
+// 支持 Timestamp、Duration、wrappers、Struct、Any 等知名类型; files: Any 及扩展字段的描述来源, 为空时使用全局注册类型
+func GrpcEncodeJson(desc protoreflect.MessageDescriptor, data []byte, files ...*protoregistry.Files) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []byte{}, nil
+	}
+	var (
+		msg     = dynamicpb.NewMessage(desc)
+		options = protojson.UnmarshalOptions{Resolver: grpcTypes{files: append(files, nil)[0]}}
+	)
+	if err := options.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+func (types grpcTypes) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if types.files != nil {
+		if desc, err := types.files.FindDescriptorByName(name); err == nil {
+			if message, ok := desc.(protoreflect.MessageDescriptor); ok {
+				return dynamicpb.NewMessageType(message), nil
+			}
+		}
+	}
+	return protoregistry.GlobalTypes.FindMessageByName(name)
+}
+
+// FindMessageByURL type_url 取最后一个 / 之后的消息全名
+func (types grpcTypes) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	var name = url
+	if index := strings.LastIndexByte(url, '/'); index >= 0 {
+		name = url[index+1:]
+	}
+	return types.FindMessageByName(protoreflect.FullName(name))
+}
+
+func (types grpcTypes) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	if types.files != nil {
+		if desc, err := types.files.FindDescriptorByName(name); err == nil {
+			if extension, ok := desc.(protoreflect.ExtensionDescriptor); ok {
+				return dynamicpb.NewExtensionType(extension), nil
+			}
+		}
+	}
+	return protoregistry.GlobalTypes.FindExtensionByName(name)
+}
+
+// FindExtensionByNumber 仅查找文件顶层扩展
+func (types grpcTypes) FindExtensionByNumber(message protoreflect.FullName, number protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	var found protoreflect.ExtensionType
+	if types.files != nil {
+		types.files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+			var extensions = file.Extensions()
+			for i := 0; i < extensions.Len(); i++ {
+				if extension := extensions.Get(i); extension.ContainingMessage().FullName() == message && extension.Number() == number {
+					found = dynamicpb.NewExtensionType(extension)
+					return false
+				}
+			}
+			return true
+		})
+	}
+	if found != nil {
+		return found, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, number)
+}
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrpcEncodeJson(t *testing.T) {
+	var field = new(descriptorpb.FieldDescriptorProto)
+	data, err := GrpcEncodeJson(field.ProtoReflect().Descriptor(),
+		[]byte(`{"name":"tags","number":"3","label":"LABEL_REPEATED","type":9,"jsonName":"tagList","options":{"packed":true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = proto.Unmarshal(data, field); err != nil {
+		t.Fatal(err)
+	}
+	if field.GetName() != "tags" || field.GetNumber() != 3 || field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED ||
+		field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_STRING || field.GetJsonName() != "tagList" || !field.GetOptions().GetPacked() {
+		t.Fatalf("unexpected message %v", field)
+	}
+
+	// 知名类型 Struct 按 json 对象映射
+	var value = new(structpb.Struct)
+	data, err = GrpcEncodeJson(value.ProtoReflect().Descriptor(), []byte(`{"score":1.5,"tags":["a",true]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = proto.Unmarshal(data, value); err != nil {
+		t.Fatal(err)
+	}
+	var tags = value.Fields["tags"].GetListValue().GetValues()
+	if value.Fields["score"].GetNumberValue() != 1.5 || len(tags) != 2 || tags[0].GetStringValue() != "a" || !tags[1].GetBoolValue() {
+		t.Fatalf("unexpected struct %v", value)
+	}
+
+	if data, err = GrpcEncodeJson(field.ProtoReflect().Descriptor(), []byte(" ")); err != nil || len(data) != 0 {
+		t.Fatalf("expect empty message, got %v %v", data, err)
+	}
+	if _, err = GrpcEncodeJson(field.ProtoReflect().Descriptor(), []byte(`{"unknown":1}`)); err == nil {
+		t.Fatal("expect unknown field error")
+	}
+	if _, err = GrpcEncodeJson(field.ProtoReflect().Descriptor(), []byte(`{"number":"x"}`)); err == nil {
+		t.Fatal("expect invalid number error")
+	}
+}
+
+func TestGrpcEncodeJson_WellKnownTypes(t *testing.T) {
+	var (
+		message = func(name string) *descriptorpb.FieldDescriptorProto {
+			return &descriptorpb.FieldDescriptorProto{Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(name)}
+		}
+		fields = map[string]*descriptorpb.FieldDescriptorProto{
+			"at":     message(".google.protobuf.Timestamp"),
+			"wait":   message(".google.protobuf.Duration"),
+			"count":  message(".google.protobuf.Int32Value"),
+			"detail": message(".google.protobuf.Any"),
+			"note":   {Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+		}
+		event = &descriptorpb.DescriptorProto{Name: proto.String("Event")}
+		note  = &descriptorpb.DescriptorProto{Name: proto.String("Note"), Field: []*descriptorpb.FieldDescriptorProto{fields["note"]}}
+	)
+	for i, name := range []string{"at", "wait", "count", "detail"} {
+		fields[name].Name, fields[name].JsonName, fields[name].Number = proto.String(name), proto.String(name), proto.Int32(int32(i+1))
+		fields[name].Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+		event.Field = append(event.Field, fields[name])
+	}
+	fields["note"].Name, fields["note"].JsonName, fields["note"].Number = proto.String("text"), proto.String("text"), proto.Int32(1)
+	fields["note"].Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("test/event.proto"),
+		Package:     proto.String("queue.test"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{"google/protobuf/timestamp.proto", "google/protobuf/duration.proto", "google/protobuf/wrappers.proto", "google/protobuf/any.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{event, note},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files = new(protoregistry.Files)
+	if err = files.RegisterFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any 内嵌消息从描述文件解析
+	var desc = file.Messages().ByName("Event")
+	data, err := GrpcEncodeJson(desc, []byte(`{"at":"2021-01-01T00:00:00Z","wait":"1.5s","count":3,"detail":{"@type":"type.googleapis.com/queue.test.Note","text":"hi"}}`), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg = dynamicpb.NewMessage(desc)
+	if err = proto.Unmarshal(data, msg); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		at     = new(timestamppb.Timestamp)
+		wait   = new(durationpb.Duration)
+		count  = new(wrapperspb.Int32Value)
+		detail = new(anypb.Any)
+	)
+	for name, value := range map[protoreflect.Name]proto.Message{"at": at, "wait": wait, "count": count, "detail": detail} {
+		raw, _ := proto.Marshal(msg.Get(desc.Fields().ByName(name)).Message().Interface())
+		if err = proto.Unmarshal(raw, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if at.GetSeconds() != 1609459200 || wait.AsDuration() != 1500*time.Millisecond || count.GetValue() != 3 ||
+		detail.GetTypeUrl() != "type.googleapis.com/queue.test.Note" || !strings.Contains(string(detail.GetValue()), "hi") {
+		t.Fatalf("unexpected message at=%v wait=%v count=%v detail=%v", at, wait, count, detail)
+	}
+
+	// 未提供描述文件时 Any 内嵌类型无法解析
+	if _, err = GrpcEncodeJson(desc, []byte(`{"detail":{"@type":"type.googleapis.com/queue.test.Note","text":"hi"}}`)); err == nil {
+		t.Fatal("expect unresolved any type error")
+	}
+	if _, err = GrpcEncodeJson(desc, []byte(`{"at":{"seconds":1}}`)); err == nil {
+		t.Fatal("expect invalid timestamp error")
+	}
+}
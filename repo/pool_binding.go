@@ -0,0 +1,229 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panjf2000/ants/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// BindingPool 绑定独立协程池 [容量来自队列 ConsumerMaxNum, 排队超限快速失败]
+	BindingPool struct {
+		queue   string
+		name    string
+		pool    *ants.Pool
+		backlog chan func()
+		feeder  sync.WaitGroup
+		tasks   sync.WaitGroup
+		closed  int32
+		locker  sync.RWMutex
+		panics  uint64
+		dropped uint64
+	}
+
+	// BindingPoolStats 协程池使用情况
+	BindingPoolStats struct {
+		Queue    string `json:"queue"`
+		Binding  string `json:"binding"`
+		Capacity int    `json:"capacity"`
+		Running  int    `json:"running"`
+		Waiting  int    `json:"waiting"`
+		Limit    int    `json:"waiting_limit"`
+		Panics   uint64 `json:"panics"`
+		Rejected uint64 `json:"rejected"`
+	}
+
+	// 绑定协程池指标 [采集时读取]
+	bindingPoolCollector struct {
+		capacity *prometheus.Desc
+		running  *prometheus.Desc
+		waiting  *prometheus.Desc
+		panics   *prometheus.Desc
+		rejected *prometheus.Desc
+	}
+)
+
+const (
+	defaultBindingPoolSize    = 1
+	defaultBindingPoolWaiting = 100
+)
+
+var (
+	ErrorBindingPoolOverload = errors.New("binding pool waiting queue full")
+	ErrorBindingPoolClosed   = errors.New("binding pool closed")
+	bindingPools             = sync.Map{} // *BindingPool => *BindingPool
+)
+
+// BindingPoolSize 协程池容量 [队列 ConsumerMaxNum, 未配置时为 1]
+func BindingPoolSize(consumerMaxNum uint) int {
+	if consumerMaxNum <= 0 {
+		return defaultBindingPoolSize
+	}
+	return int(consumerMaxNum)
+}
+
+// NewBindingPool 创建绑定协程池 [size: 并发数, waiting: 排队上限]
+func NewBindingPool(queue, name string, size, waiting int) (*BindingPool, error) {
+	if size <= 0 {
+		size = defaultBindingPoolSize
+	}
+	if waiting <= 0 {
+		waiting = defaultBindingPoolWaiting
+	}
+	var bp = &BindingPool{queue: queue, name: name, backlog: make(chan func(), waiting)}
+	pool, err := ants.NewPool(size, ants.WithPanicHandler(bp.Panicked))
+	if err != nil {
+		return nil, err
+	}
+	bp.pool = pool
+	bp.feeder.Add(1)
+	go bp.feed()
+	bindingPools.Store(bp, bp)
+	return bp, nil
+}
+
+// GetBindingPoolStats 所有绑定协程池使用情况 [多个消费实例的同名绑定合并统计]
+func GetBindingPoolStats() []BindingPoolStats {
+	var (
+		stats []BindingPoolStats
+		index = make(map[string]int)
+	)
+	bindingPools.Range(func(_, value interface{}) bool {
+		var (
+			bp    = value.(*BindingPool)
+			item  = bp.Stats()
+			i, ok = index[bp.key()]
+		)
+		if !ok {
+			index[bp.key()] = len(stats)
+			stats = append(stats, item)
+			return true
+		}
+		stats[i].Capacity += item.Capacity
+		stats[i].Running += item.Running
+		stats[i].Waiting += item.Waiting
+		stats[i].Limit += item.Limit
+		stats[i].Panics += item.Panics
+		stats[i].Rejected += item.Rejected
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Queue != stats[j].Queue {
+			return stats[i].Queue < stats[j].Queue
+		}
+		return stats[i].Binding < stats[j].Binding
+	})
+	return stats
+}
+
+func (bp *BindingPool) key() string {
+	return fmt.Sprintf("%s/%s", bp.queue, bp.name)
+}
+
+// 排队任务逐个提交 [协程池满时阻塞, 不影响其他绑定]
+func (bp *BindingPool) feed() {
+	defer bp.feeder.Done()
+	for task := range bp.backlog {
+		if err := bp.pool.Submit(task); err != nil {
+			bp.tasks.Done()
+			GetLogger("consumer").WithField("binding", bp.key()).Errorln("binding pool submit error:", err)
+		}
+	}
+}
+
+// Submit 提交任务 [排队已满返回 ErrorBindingPoolOverload]
+func (bp *BindingPool) Submit(task func()) error {
+	bp.locker.RLock()
+	defer bp.locker.RUnlock()
+	if atomic.LoadInt32(&bp.closed) == 1 {
+		return ErrorBindingPoolClosed
+	}
+	bp.tasks.Add(1)
+	select {
+	case bp.backlog <- func() {
+		defer bp.tasks.Done()
+		task()
+	}:
+		return nil
+	default:
+		bp.tasks.Done()
+		atomic.AddUint64(&bp.dropped, 1)
+		return ErrorBindingPoolOverload
+	}
+}
+
+// Capacity 可同时容纳的任务数 [并发数 + 排队上限, 作为队列预取数依据]
+func (bp *BindingPool) Capacity() int {
+	return bp.pool.Cap() + cap(bp.backlog)
+}
+
+// Panicked 记录任务异常
+func (bp *BindingPool) Panicked(e interface{}) {
+	atomic.AddUint64(&bp.panics, 1)
+	GetLogger("consumer").WithField("binding", bp.key()).Errorln("binding pool task panic:", e)
+}
+
+// Close 关闭协程池 [等待排队及运行中任务完成]
+func (bp *BindingPool) Close() {
+	bp.locker.Lock()
+	if !atomic.CompareAndSwapInt32(&bp.closed, 0, 1) {
+		bp.locker.Unlock()
+		return
+	}
+	close(bp.backlog)
+	bp.locker.Unlock()
+	bp.feeder.Wait()
+	bp.tasks.Wait()
+	bp.pool.Release()
+	bindingPools.Delete(bp)
+}
+
+// Stats 使用情况
+func (bp *BindingPool) Stats() BindingPoolStats {
+	return BindingPoolStats{
+		Queue:    bp.queue,
+		Binding:  bp.name,
+		Capacity: bp.pool.Cap(),
+		Running:  bp.pool.Running(),
+		Waiting:  len(bp.backlog),
+		Limit:    cap(bp.backlog),
+		Panics:   atomic.LoadUint64(&bp.panics),
+		Rejected: atomic.LoadUint64(&bp.dropped),
+	}
+}
+
+func newBindingPoolCollector() *bindingPoolCollector {
+	var labels = []string{"queue", "binding"}
+	var desc = func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "binding_pool", name), help, labels, nil)
+	}
+	return &bindingPoolCollector{
+		capacity: desc("capacity", "binding pool worker capacity"),
+		running:  desc("running", "binding pool running workers"),
+		waiting:  desc("waiting", "binding pool queued tasks"),
+		panics:   desc("panics_total", "binding pool task panics"),
+		rejected: desc("rejected_total", "binding pool tasks rejected by waiting limit"),
+	}
+}
+
+func (collector *bindingPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.capacity
+	ch <- collector.running
+	ch <- collector.waiting
+	ch <- collector.panics
+	ch <- collector.rejected
+}
+
+func (collector *bindingPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range GetBindingPoolStats() {
+		ch <- prometheus.MustNewConstMetric(collector.capacity, prometheus.GaugeValue, float64(stats.Capacity), stats.Queue, stats.Binding)
+		ch <- prometheus.MustNewConstMetric(collector.running, prometheus.GaugeValue, float64(stats.Running), stats.Queue, stats.Binding)
+		ch <- prometheus.MustNewConstMetric(collector.waiting, prometheus.GaugeValue, float64(stats.Waiting), stats.Queue, stats.Binding)
+		ch <- prometheus.MustNewConstMetric(collector.panics, prometheus.CounterValue, float64(stats.Panics), stats.Queue, stats.Binding)
+		ch <- prometheus.MustNewConstMetric(collector.rejected, prometheus.CounterValue, float64(stats.Rejected), stats.Queue, stats.Binding)
+	}
+}
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBindingPool_Submit(t *testing.T) {
+	pool, err := NewBindingPool("orders", "audit", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		done    int32
+		release = make(chan struct{})
+		started = make(chan struct{})
+	)
+	// 占满协程 第二个任务排队 第三个任务超限
+	if err = pool.Submit(func() {
+		close(started)
+		<-release
+		atomic.AddInt32(&done, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err = pool.Submit(func() { atomic.AddInt32(&done, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	var deadline = time.Now().Add(time.Second)
+	for pool.Submit(func() { atomic.AddInt32(&done, 1) }) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("BindingPool Submit expect overload")
+		}
+	}
+	if err = pool.Submit(func() {}); !errors.Is(err, ErrorBindingPoolOverload) {
+		t.Fatalf("BindingPool Submit error %v, want overload", err)
+	}
+	var stats = pool.Stats()
+	if stats.Capacity != 1 || stats.Running != 1 || stats.Rejected == 0 {
+		t.Fatalf("BindingPool Stats %+v", stats)
+	}
+	close(release)
+	pool.Close()
+	if n := atomic.LoadInt32(&done); n < 2 {
+		t.Fatalf("BindingPool Close should wait queued tasks, done %d", n)
+	}
+	if err = pool.Submit(func() {}); !errors.Is(err, ErrorBindingPoolClosed) {
+		t.Fatalf("BindingPool Submit after close error %v", err)
+	}
+	for _, item := range GetBindingPoolStats() {
+		if item.Queue == "orders" && item.Binding == "audit" {
+			t.Fatal("BindingPool Close should remove stats")
+		}
+	}
+}
+
+func TestBindingPool_Concurrency(t *testing.T) {
+	pool, err := NewBindingPool("orders", "mail", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if pool.Capacity() != 8 {
+		t.Fatalf("BindingPool Capacity %d, want 8", pool.Capacity())
+	}
+	var (
+		running int32
+		release = make(chan struct{})
+	)
+	defer close(release)
+	// 容量内任务同时运行
+	for i := 0; i < 3; i++ {
+		if err = pool.Submit(func() {
+			atomic.AddInt32(&running, 1)
+			<-release
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var deadline = time.Now().Add(time.Second)
+	for atomic.LoadInt32(&running) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("BindingPool running %d, want 3", atomic.LoadInt32(&running))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,14 +1,27 @@
 package repo
 
 import (
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
+	"time"
 )
 
 type prometheusRepository struct {
-
+	// rabbitmq 发布确认耗时
+	confirmLatency *prometheus.HistogramVec
+	// 流水线步骤处理耗时
+	pipelineLatency *prometheus.HistogramVec
+	// 流水线步骤处理结果
+	pipelineResults *prometheus.CounterVec
+	// 去重窗口内重复消息数
+	dedupDuplicates *prometheus.CounterVec
 }
 
+const (
+	metricsNamespace = "queue_mgr"
+)
+
 var (
 	prometheusImpl *prometheusRepository
 )
@@ -22,13 +35,67 @@ func GetPrometheusRepo() *prometheusRepository {
 
 func NewPrometheusRepo() *prometheusRepository {
 	var prom = new(prometheusRepository)
-	return prom
+	return prom.init()
 }
 
 func (repo *prometheusRepository)init()*prometheusRepository {
+	repo.confirmLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "rabbitmq",
+		Name:      "publish_confirm_seconds",
+		Help:      "rabbitmq publisher confirm latency in seconds",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"queue", "result"})
+	repo.pipelineLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "pipeline",
+		Name:      "step_seconds",
+		Help:      "consumer pipeline step latency in seconds",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"pipeline", "step"})
+	repo.pipelineResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "pipeline",
+		Name:      "step_total",
+		Help:      "consumer pipeline step results",
+	}, []string{"pipeline", "step", "result"})
+	repo.dedupDuplicates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "dedup",
+		Name:      "duplicates_total",
+		Help:      "duplicate messages acked within dedup window",
+	}, []string{"queue", "binding"})
+	repo.register(repo.confirmLatency, repo.pipelineLatency, repo.pipelineResults, repo.dedupDuplicates, newBindingPoolCollector())
 	return repo
 }
 
+// 注册指标 [重复注册忽略]
+func (repo *prometheusRepository) register(collectors ...prometheus.Collector) {
+	for _, collector := range collectors {
+		if err := prometheus.Register(collector); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				GetLogger("prometheus").Errorln("prometheus register error:", err)
+			}
+		}
+	}
+}
+
+// ObserveConfirm 记录发布确认耗时
+func (repo *prometheusRepository) ObserveConfirm(queue, result string, duration time.Duration) {
+	repo.confirmLatency.WithLabelValues(queue, result).Observe(duration.Seconds())
+}
+
+// ObservePipelineStep 记录流水线步骤耗时及结果
+func (repo *prometheusRepository) ObservePipelineStep(pipeline, step, result string, duration time.Duration) {
+	repo.pipelineLatency.WithLabelValues(pipeline, step).Observe(duration.Seconds())
+	repo.pipelineResults.WithLabelValues(pipeline, step, result).Inc()
+}
+
+// IncrDedupDuplicate 记录重复消息
+func (repo *prometheusRepository) IncrDedupDuplicate(queue, binding string) {
+	repo.dedupDuplicates.WithLabelValues(queue, binding).Inc()
+}
+
 func (repo *prometheusRepository)GetHttpHandler() http.Handler {
 	 return promhttp.Handler()
-}
\ No newline at end of file
+}
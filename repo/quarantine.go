@@ -0,0 +1,313 @@
+package repo
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// 毒消息隔离 [同一消息多次处理异常后移出队列, 保留堆栈, 可查看及释放]
+	quarantineRepository struct {
+		locker    sync.RWMutex
+		threshold int
+		limit     int
+		ttl       time.Duration
+		panics    map[string]*poisonRecord
+		entries   map[string]*entity.QuarantineEntry
+		db        *leveldb.DB
+		logger    *logrus.Logger
+	}
+
+	// 消息异常计数
+	poisonRecord struct {
+		count int
+		last  time.Time
+	}
+)
+
+const (
+	// EnvPoisonThreshold 同一消息异常次数达到后隔离
+	EnvPoisonThreshold = "QUEUE_POISON_THRESHOLD"
+	// EnvQuarantineMax 隔离消息上限 [超出时移除最早的]
+	EnvQuarantineMax = "QUEUE_QUARANTINE_MAX"
+	// EnvQuarantineStore 隔离消息存储 memory|leveldb
+	EnvQuarantineStore     = "QUEUE_QUARANTINE_STORE"
+	defaultPoisonThreshold = 3
+	defaultQuarantineMax   = 1000
+	poisonRecordTTL        = time.Hour
+	quarantineKeyPrefix    = "quarantine:"
+	inflightKeyPrefix      = "quarantine_inflight:"
+	quarantineReasonPanic  = "panic"
+	quarantineReasonCrash  = "crashed during handling"
+)
+
+var (
+	quarantineImpl *quarantineRepository
+	quarantineOnce sync.Once
+)
+
+// GetQuarantineRepo 毒消息隔离
+func GetQuarantineRepo() *quarantineRepository {
+	quarantineOnce.Do(func() {
+		quarantineImpl = newQuarantineRepository()
+		if strings.ToLower(utils.GetEnvVal(EnvQuarantineStore)) == DedupStoreLevelDB {
+			if db, ok := GetLocalStorageRepo().GetStorage(); ok {
+				quarantineImpl.SetStorage(db)
+			}
+		}
+	})
+	return quarantineImpl
+}
+
+func newQuarantineRepository() *quarantineRepository {
+	return &quarantineRepository{
+		threshold: utils.GetEnvInt(EnvPoisonThreshold, defaultPoisonThreshold),
+		limit:     utils.GetEnvInt(EnvQuarantineMax, defaultQuarantineMax),
+		ttl:       poisonRecordTTL,
+		panics:    make(map[string]*poisonRecord),
+		entries:   make(map[string]*entity.QuarantineEntry),
+		logger:    GetLogger("consumer"),
+	}
+}
+
+// SetStorage 持久化到 leveldb [加载已隔离消息]
+func (repo *quarantineRepository) SetStorage(db *leveldb.DB) *quarantineRepository {
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	repo.db = db
+	var iter = db.NewIterator(util.BytesPrefix([]byte(quarantineKeyPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var entry = new(entity.QuarantineEntry)
+		if err := json.Unmarshal(iter.Value(), entry); err == nil {
+			repo.entries[entry.ID] = entry
+		}
+	}
+	return repo
+}
+
+// Panicked 记录消息处理异常 [达到阈值时隔离, 返回 true 表示已隔离 消息应确认移出队列]
+func (repo *quarantineRepository) Panicked(driver, binding string, msg *entity.QueueMessage, reason error, stack string) bool {
+	var key = poisonKey(msg, binding)
+	repo.locker.Lock()
+	var record, ok = repo.panics[key]
+	if !ok || time.Since(record.last) > repo.ttl {
+		record = &poisonRecord{}
+		repo.panics[key] = record
+	}
+	record.count++
+	record.last = time.Now()
+	var count = record.count
+	if count >= repo.threshold {
+		delete(repo.panics, key)
+	}
+	repo.sweep()
+	repo.locker.Unlock()
+	if count < repo.threshold {
+		return false
+	}
+	var entry = &entity.QuarantineEntry{
+		Driver:  strings.ToUpper(driver),
+		Queue:   msg.Queue,
+		Binding: binding,
+		Reason:  reason.Error(),
+		Panics:  count,
+		Stack:   stack,
+		Message: msg,
+	}
+	return repo.Add(entry) == nil
+}
+
+// Begin 开始处理消息 [持久化时记录处理中标记, 未完成即重投的次数达到阈值视为进程崩溃, 隔离并返回 true]
+func (repo *quarantineRepository) Begin(driver string, msg *entity.QueueMessage) bool {
+	repo.locker.RLock()
+	var db = repo.db
+	repo.locker.RUnlock()
+	if db == nil {
+		return false
+	}
+	var (
+		key     = []byte(inflightKeyPrefix + poisonKey(msg, ""))
+		count   = 1
+		data, _ = db.Get(key, nil)
+	)
+	if n, err := strconv.Atoi(string(data)); err == nil {
+		count += n
+	}
+	if count <= repo.threshold {
+		_ = db.Put(key, []byte(strconv.Itoa(count)), nil)
+		return false
+	}
+	_ = db.Delete(key, nil)
+	return repo.Add(&entity.QuarantineEntry{
+		Driver:  strings.ToUpper(driver),
+		Queue:   msg.Queue,
+		Reason:  fmt.Sprintf("%s: %d", quarantineReasonCrash, count-1),
+		Panics:  count - 1,
+		Message: msg,
+	}) == nil
+}
+
+// Done 消息处理完成 [清除处理中标记]
+func (repo *quarantineRepository) Done(msg *entity.QueueMessage) {
+	repo.locker.RLock()
+	var db = repo.db
+	repo.locker.RUnlock()
+	if db != nil {
+		_ = db.Delete([]byte(inflightKeyPrefix+poisonKey(msg, "")), nil)
+	}
+}
+
+// Add 隔离消息 [超出上限时移除最早的]
+func (repo *quarantineRepository) Add(entry *entity.QuarantineEntry) error {
+	if entry.ID == "" {
+		entry.ID = createMessageID(entry.Queue)
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	if repo.db != nil {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err = repo.db.Put([]byte(quarantineKeyPrefix+entry.ID), data, nil); err != nil {
+			repo.logger.WithFields(logrus.Fields{"queue": entry.Queue, "id": entry.Message.ID}).Errorln("quarantine save error:", err)
+			return err
+		}
+	}
+	repo.entries[entry.ID] = entry
+	for repo.limit > 0 && len(repo.entries) > repo.limit {
+		repo.remove(repo.oldest())
+	}
+	repo.logger.WithFields(logrus.Fields{
+		"queue":   entry.Queue,
+		"binding": entry.Binding,
+		"id":      entry.Message.ID,
+	}).Warnln("poison message quarantined:", entry.Reason)
+	return nil
+}
+
+// List 隔离消息 [按隔离时间倒序, queue 为空时全部]
+func (repo *quarantineRepository) List(queue string) []*entity.QuarantineEntry {
+	repo.locker.RLock()
+	var items = make([]*entity.QuarantineEntry, 0, len(repo.entries))
+	for _, entry := range repo.entries {
+		if queue == "" || entry.Queue == queue {
+			items = append(items, entry)
+		}
+	}
+	repo.locker.RUnlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+	return items
+}
+
+// Get 查询隔离消息
+func (repo *quarantineRepository) Get(id string) (*entity.QuarantineEntry, bool) {
+	repo.locker.RLock()
+	defer repo.locker.RUnlock()
+	entry, ok := repo.entries[id]
+	return entry, ok
+}
+
+// Release 释放隔离消息 [重新投递原队列或指定队列后移除]
+func (repo *quarantineRepository) Release(id string, params *entity.QuarantineReleaseParams) (*entity.QuarantineEntry, error) {
+	var entry, ok = repo.Get(id)
+	if !ok {
+		return nil, entity.ErrorEmpty
+	}
+	if params == nil {
+		params = new(entity.QuarantineReleaseParams)
+	}
+	var (
+		queue  = params.Target
+		driver = params.Driver
+		msg    = entry.Message.Clone()
+	)
+	if queue == "" {
+		queue = entry.Queue
+	}
+	if driver == "" {
+		driver = entry.Driver
+	}
+	if entry.Binding != "" && queue == entry.Queue {
+		msg.Headers[HeaderFanoutTarget] = entry.Binding
+	}
+	target, err := GetQueueDriverRepo().Get(driver)
+	if err != nil {
+		return nil, err
+	}
+	if err = target.Push(msg, queue); err != nil {
+		return nil, err
+	}
+	return entry, repo.Discard(id)
+}
+
+// Discard 删除隔离消息
+func (repo *quarantineRepository) Discard(id string) error {
+	repo.locker.Lock()
+	defer repo.locker.Unlock()
+	if _, ok := repo.entries[id]; !ok {
+		return entity.ErrorEmpty
+	}
+	return repo.remove(id)
+}
+
+// 调用方持有锁
+func (repo *quarantineRepository) remove(id string) error {
+	delete(repo.entries, id)
+	if repo.db != nil {
+		return repo.db.Delete([]byte(quarantineKeyPrefix+id), nil)
+	}
+	return nil
+}
+
+// 调用方持有锁
+func (repo *quarantineRepository) oldest() string {
+	var (
+		id    string
+		first time.Time
+	)
+	for key, entry := range repo.entries {
+		if id == "" || entry.CreatedAt.Before(first) {
+			id, first = key, entry.CreatedAt
+		}
+	}
+	return id
+}
+
+// 清理过期异常计数 [调用方持有锁]
+func (repo *quarantineRepository) sweep() {
+	if len(repo.panics) < defaultQuarantineMax {
+		return
+	}
+	for key, record := range repo.panics {
+		if time.Since(record.last) > repo.ttl {
+			delete(repo.panics, key)
+		}
+	}
+}
+
+// 异常计数键 [消息 ID, 无 ID 时按消息体]
+func poisonKey(msg *entity.QueueMessage, binding string) string {
+	var id = msg.ID
+	if id == "" {
+		id = fmt.Sprintf("%x", sha1.Sum(msg.Body))
+	}
+	return fmt.Sprintf("%s:%s:%s", msg.Queue, binding, id)
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+)
+
+func TestQuarantineRepository_Panicked(t *testing.T) {
+	var (
+		repo = newQuarantineRepository()
+		msg  = entity.NewQueueMessage("orders", []byte(`{}`))
+		err  = errors.New("panic: boom")
+	)
+	repo.threshold = 2
+	msg.ID = "m1"
+	if repo.Panicked(DriverAmqp, "", msg, err, "stack") {
+		t.Fatal("quarantineRepository.Panicked quarantined below threshold")
+	}
+	if !repo.Panicked(DriverAmqp, "", msg, err, "stack") {
+		t.Fatal("quarantineRepository.Panicked not quarantined at threshold")
+	}
+	var items = repo.List("orders")
+	if len(items) != 1 || items[0].Reason != err.Error() || items[0].Stack != "stack" || items[0].Panics != 2 {
+		t.Fatalf("quarantineRepository.List = %v", items)
+	}
+	if err = repo.Discard(items[0].ID); err != nil || len(repo.List("")) != 0 {
+		t.Errorf("quarantineRepository.Discard = %v", err)
+	}
+	if _, err = repo.Release(items[0].ID, nil); err != entity.ErrorEmpty {
+		t.Errorf("quarantineRepository.Release discarded = %v", err)
+	}
+}
+
+func TestQuarantineRepository_Begin(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var (
+		repo = newQuarantineRepository().SetStorage(db)
+		msg  = entity.NewQueueMessage("orders", []byte(`{}`))
+	)
+	repo.threshold = 1
+	msg.ID = "m1"
+	// 处理完成 不计入崩溃
+	repo.Begin(DriverAmqp, msg)
+	repo.Done(msg)
+	if repo.Begin(DriverAmqp, msg) {
+		t.Fatal("quarantineRepository.Begin quarantined after done")
+	}
+	// 未完成即重投 视为崩溃
+	if !repo.Begin(DriverAmqp, msg) {
+		t.Fatal("quarantineRepository.Begin crash not quarantined")
+	}
+	// 重启后加载已隔离消息
+	var reload = newQuarantineRepository().SetStorage(db)
+	if items := reload.List(""); len(items) != 1 || items[0].Message.ID != "m1" {
+		t.Errorf("quarantineRepository reload = %v", items)
+	}
+}
+
+func TestQueueFanout_Quarantine(t *testing.T) {
+	var (
+		replies = make(chan entity.ConsumeAction, 1)
+		fanout  = NewQueueFanout("events", nil)
+		msg     = entity.NewQueueMessage("events", []byte(`{}`))
+		reply   = func(action entity.ConsumeAction) error {
+			replies <- action
+			return nil
+		}
+	)
+	defer fanout.Close()
+	if err := fanout.Bind(&QueueBinding{Name: "audit", Consumer: &fanoutTestConsumer{}}); err != nil {
+		t.Fatal(err)
+	}
+	msg.ID = "poison"
+	msg.Headers["panic"] = true
+	var quarantine = GetQuarantineRepo()
+	for i := 1; i <= quarantine.threshold; i++ {
+		fanout.Dispatch(msg, reply)
+		var action = waitFanoutReply(t, replies)
+		if i < quarantine.threshold && action != entity.ConsumeRetry {
+			t.Fatalf("QueueFanout panic %d reply %s, want retry", i, action)
+		}
+		if i == quarantine.threshold && action != entity.ConsumeAck {
+			t.Fatalf("QueueFanout poison reply %s, want ack", action)
+		}
+	}
+	var items = quarantine.List("events")
+	if len(items) != 1 || items[0].Binding != "audit" || items[0].Stack == "" {
+		t.Fatalf("QueueFanout quarantine %v", items)
+	}
+	_ = quarantine.Discard(items[0].ID)
+}
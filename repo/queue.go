@@ -2,23 +2,80 @@ package repo
 
 import (
 	"context"
+	"crypto/md5"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/utils"
+	"math/rand"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
 type RabbitmqUtils struct {
 	client          *rabbitmq.Client
 	params          rabbitmq.PubSubParams
 	container       sync.Map
+	fanouts         sync.Map // 队列分发 queue => *QueueFanout
 	locker          sync.RWMutex
 	consumerOptions []func(params interface{})
 	ctrl            chan bool
+	// 消费上下文 [Stop 时取消]
+	consuming       *consumeContext
+	confirm         bool          // 发布确认模式
+	confirmTimeout  time.Duration // 发布确认等待时长
+	mandatory       bool          // 不可路由消息退回
+	fallbackQueue   string        // 不可路由消息转投队列
+	publisher       sync.Mutex    // 确认模式发布锁
+	publishSeq      uint64        // 确认模式发布序号
+	confirms        chan amqp.Confirmation
+	returns         chan amqp.Return
+	returnListener  sync.Once
 }
 
+type (
+	// 发布端 [Send 发布, channel 确认模式及退回监听信道]
+	publishClient interface {
+		Send(msg rabbitmq.MessageParams) error
+		channel() publishChannel
+	}
+
+	// 发布信道
+	publishChannel interface {
+		Confirm(noWait bool) error
+		NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+		NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	}
+
+	rabbitmqPublisher struct {
+		*rabbitmq.Client
+	}
+)
+
+const (
+	defaultConfirmTimeout = 5 * time.Second
+	confirmResultAck      = "ack"
+	confirmResultNack     = "nack"
+	confirmResultTimeout  = "timeout"
+	confirmResultReturned = "returned"
+	confirmResultClosed   = "closed"
+	HeaderUnroutableKey   = "x-unroutable-key"
+	HeaderUnroutableText  = "x-unroutable-reason"
+	HeaderUnroutableEx    = "x-unroutable-exchange"
+	retryQueueIdle        = time.Minute // 重试队列空闲删除时长
+)
+
+var (
+	ErrorUnroutable     = errors.New("rabbitmq message unroutable")
+	ErrorConfirmTimeout = errors.New("rabbitmq publish confirm timeout")
+	ErrorPublishNack    = errors.New("rabbitmq publish nack by broker")
+	ErrorConfirmClosed  = errors.New("rabbitmq confirm channel closed")
+)
+
 func RabbitmqOf(namespace ...string) RabbitmqUtils {
 	namespace = append(namespace, "")
 	return RabbitmqUtils{
@@ -29,9 +86,15 @@ func RabbitmqOf(namespace ...string) RabbitmqUtils {
 			Entry:   namespace[0],
 		},
 		container:       sync.Map{},
+		fanouts:         sync.Map{},
 		locker:          sync.RWMutex{},
 		ctrl:            make(chan bool, 2),
+		consuming:       new(consumeContext),
 		consumerOptions: []func(params interface{}){},
+		confirm:         utils.GetEnvBool("RABBITMQ_PUBLISH_CONFIRM"),
+		confirmTimeout:  utils.GetEnvDuration("RABBITMQ_CONFIRM_TIMEOUT", defaultConfirmTimeout),
+		mandatory:       utils.GetEnvBool("RABBITMQ_PUBLISH_MANDATORY"),
+		fallbackQueue:   utils.GetEnvVal("RABBITMQ_FALLBACK_QUEUE"),
 	}
 }
 
@@ -74,8 +137,249 @@ func (utils *RabbitmqUtils) Push(data interface{}, queues ...string) error {
 	if err := utils.QueueDeclare(queue); err != nil {
 		return err
 	}
+	if m, ok := data.(*entity.QueueMessage); ok {
+		data = m.Publishing()
+	}
 	msg := rabbitmq.MessageParamsOf("", queue, data)
-	return utils.getClient().Send(*msg)
+	return utils.publish(*msg)
+}
+
+// PushDelay 延迟投递 [消息进入按延迟分档的重试队列, 过期后经死信路由回原队列]
+func (utils *RabbitmqUtils) PushDelay(msg *entity.QueueMessage, delay time.Duration, queue string) error {
+	var (
+		ttl   = retryBucket(delay)
+		retry = fmt.Sprintf("%s.retry.%d", queue, ttl.Milliseconds())
+	)
+	if err := utils.QueueDeclare(retry, WithGoodQueueOptions, withRetryQueueOptions(queue, ttl)); err != nil {
+		return err
+	}
+	return utils.Push(msg, retry)
+}
+
+// SetPublishConfirm 开启|关闭 发布确认模式
+func (utils *RabbitmqUtils) SetPublishConfirm(on bool, timeout ...time.Duration) *RabbitmqUtils {
+	utils.publisher.Lock()
+	defer utils.publisher.Unlock()
+	utils.confirm = on
+	if len(timeout) > 0 && timeout[0] > 0 {
+		utils.confirmTimeout = timeout[0]
+	}
+	return utils
+}
+
+// SetMandatory 开启|关闭 mandatory 发布, 可选不可路由消息转投队列
+func (utils *RabbitmqUtils) SetMandatory(on bool, fallbackQueue ...string) *RabbitmqUtils {
+	utils.publisher.Lock()
+	defer utils.publisher.Unlock()
+	utils.mandatory = on
+	if len(fallbackQueue) > 0 {
+		utils.fallbackQueue = fallbackQueue[0]
+	}
+	return utils
+}
+
+// 发布消息 [确认模式下等待 broker ack]
+func (utils *RabbitmqUtils) publish(msg rabbitmq.MessageParams) error {
+	var client = utils.getClient()
+	if client == nil {
+		return errors.New("queue client connection failed")
+	}
+	return utils.publishWith(rabbitmqPublisher{client}, msg)
+}
+
+// 按发布配置发布 [配置在 publisher 锁内读取, 确认模式全程持锁]
+func (utils *RabbitmqUtils) publishWith(client publishClient, msg rabbitmq.MessageParams) error {
+	if msg.Msg.MessageId == "" {
+		msg.Msg.MessageId = createMessageID(msg.Key)
+	}
+	utils.publisher.Lock()
+	msg.Mandatory = utils.mandatory
+	if !utils.confirm {
+		utils.publisher.Unlock()
+		if msg.Mandatory {
+			utils.listenReturns(client)
+		}
+		return client.Send(msg)
+	}
+	defer utils.publisher.Unlock()
+	var err = utils.publishConfirm(client, msg)
+	if err != ErrorUnroutable || utils.fallbackQueue == "" || msg.Key == utils.fallbackQueue {
+		return err
+	}
+	// 不可路由 转投备用队列
+	if err = utils.QueueDeclare(utils.fallbackQueue); err != nil {
+		return err
+	}
+	return utils.publishConfirm(client, createFallback(msg, utils.fallbackQueue, ErrorUnroutable.Error()))
+}
+
+// 确认模式发布 [调用方持有 publisher 锁]
+func (utils *RabbitmqUtils) publishConfirm(client publishClient, msg rabbitmq.MessageParams) error {
+	if err := utils.initConfirm(client); err != nil {
+		return err
+	}
+	var start = time.Now()
+	if err := client.Send(msg); err != nil {
+		return err
+	}
+	utils.publishSeq++
+	var result, err = utils.waitConfirm(utils.publishSeq, msg.Msg.MessageId)
+	GetPrometheusRepo().ObserveConfirm(msg.Key, result, time.Since(start))
+	return err
+}
+
+// 信道开启确认模式 [开启 mandatory 后补注册退回监听, 调用方持有 publisher 锁]
+func (utils *RabbitmqUtils) initConfirm(client publishClient) error {
+	if utils.confirms != nil && (!utils.mandatory || utils.returns != nil) {
+		return nil
+	}
+	var channel = client.channel()
+	if channel == nil {
+		return errors.New("queue channel missing")
+	}
+	if utils.confirms == nil {
+		if err := channel.Confirm(false); err != nil {
+			return err
+		}
+		utils.publishSeq = 0
+		utils.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 64))
+		utils.returns = nil
+	}
+	if utils.mandatory && utils.returns == nil {
+		utils.returns = channel.NotifyReturn(make(chan amqp.Return, 64))
+	}
+	return nil
+}
+
+// 等待对应序号的确认
+func (utils *RabbitmqUtils) waitConfirm(tag uint64, messageID string) (string, error) {
+	var timer = time.NewTimer(utils.confirmTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case confirm, ok := <-utils.confirms:
+			if !ok {
+				utils.confirms = nil
+				return confirmResultClosed, ErrorConfirmClosed
+			}
+			// 之前超时未取的确认
+			if confirm.DeliveryTag < tag {
+				continue
+			}
+			if !confirm.Ack {
+				return confirmResultNack, ErrorPublishNack
+			}
+			// broker 先于 ack 发送 basic.return
+			if utils.returned(messageID) {
+				return confirmResultReturned, ErrorUnroutable
+			}
+			return confirmResultAck, nil
+		case <-timer.C:
+			return confirmResultTimeout, ErrorConfirmTimeout
+		}
+	}
+}
+
+// 检查消息是否被退回
+func (utils *RabbitmqUtils) returned(messageID string) bool {
+	if utils.returns == nil {
+		return false
+	}
+	var found bool
+	for {
+		select {
+		case ret := <-utils.returns:
+			if ret.MessageId == messageID {
+				found = true
+				continue
+			}
+			log.WithField("queue", ret.RoutingKey).Warnln("rabbitmq stale return:", ret.ReplyText)
+		default:
+			return found
+		}
+	}
+}
+
+// 非确认模式 异步处理退回消息
+func (utils *RabbitmqUtils) listenReturns(client publishClient) {
+	utils.returnListener.Do(func() {
+		var channel = client.channel()
+		if channel == nil {
+			return
+		}
+		var returns = channel.NotifyReturn(make(chan amqp.Return, 64))
+		_ = GetPoolRepo().Add(func() {
+			for ret := range returns {
+				utils.onReturn(client, ret)
+			}
+		})
+	})
+}
+
+// 退回消息处理
+func (utils *RabbitmqUtils) onReturn(client publishClient, ret amqp.Return) {
+	var logger = log.WithFields(log.Fields{
+		"exchange": ret.Exchange,
+		"key":      ret.RoutingKey,
+		"id":       ret.MessageId,
+	})
+	utils.publisher.Lock()
+	var fallbackQueue = utils.fallbackQueue
+	utils.publisher.Unlock()
+	if fallbackQueue == "" || ret.RoutingKey == fallbackQueue {
+		logger.Errorln("rabbitmq message unroutable:", ret.ReplyText)
+		return
+	}
+	if err := utils.QueueDeclare(fallbackQueue); err != nil {
+		logger.Errorln("rabbitmq fallback queue declare error:", err)
+		return
+	}
+	var msg = rabbitmq.MessageParams{
+		Exchange: ret.Exchange,
+		Key:      ret.RoutingKey,
+		Msg: amqp.Publishing{
+			Headers:         ret.Headers,
+			ContentType:     ret.ContentType,
+			ContentEncoding: ret.ContentEncoding,
+			DeliveryMode:    ret.DeliveryMode,
+			Priority:        ret.Priority,
+			CorrelationId:   ret.CorrelationId,
+			ReplyTo:         ret.ReplyTo,
+			Expiration:      ret.Expiration,
+			MessageId:       ret.MessageId,
+			Timestamp:       ret.Timestamp,
+			Type:            ret.Type,
+			UserId:          ret.UserId,
+			AppId:           ret.AppId,
+			Body:            ret.Body,
+		},
+	}
+	if err := client.Send(createFallback(msg, fallbackQueue, ret.ReplyText)); err != nil {
+		logger.Errorln("rabbitmq fallback publish error:", err)
+	}
+}
+
+func (client rabbitmqPublisher) channel() publishChannel {
+	if channel := client.GetChannel(); channel != nil {
+		return channel
+	}
+	return nil
+}
+
+// 构建转投备用队列消息
+func createFallback(msg rabbitmq.MessageParams, fallbackQueue, reason string) rabbitmq.MessageParams {
+	var headers = amqp.Table{}
+	for k, v := range msg.Msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderUnroutableEx] = msg.Exchange
+	headers[HeaderUnroutableKey] = msg.Key
+	headers[HeaderUnroutableText] = reason
+	msg.Msg.Headers = headers
+	msg.Exchange = ""
+	msg.Key = fallbackQueue
+	msg.Mandatory = false
+	return msg
 }
 
 // QueueDeclare 队列定义
@@ -109,18 +413,27 @@ func (utils *RabbitmqUtils) QueueDeclare(queue string, options ...func(params in
 		utils.container.Store(queue+".declare", queueIns)
 		// log.Infoln("queueParams",fmt.Sprintf("%v",params))
 	}
+	// 死信队列需先存在 否则 broker 丢弃死信
+	if target := DeadLetterQueueOf(queue); err == nil && target != "" {
+		err = utils.QueueDeclare(target)
+	}
 	return err
 }
 
-// 队列参数
+// 队列参数 [配置死信队列时 丢弃的消息经默认交换机路由至死信队列]
 func (utils *RabbitmqUtils) getQueueArgs(queue string) rabbitmq.QueueParams {
-	return rabbitmq.QueueParams{
+	var params = rabbitmq.QueueParams{
 		Durable:    true,
 		Key:        queue,
 		AutoDelete: false,
 		Exclusive:  false,
 		NoWait:     true,
 	}
+	if target := DeadLetterQueueOf(queue); target != "" {
+		params.SetArgs(rabbitmq.ArgDeadLetterExchange, "")
+		params.SetArgs(rabbitmq.ArgDeadLetterRoutingKey, target)
+	}
+	return params
 }
 
 // Pop 消费 [一个个消费]
@@ -160,7 +473,7 @@ func (utils *RabbitmqUtils) wait(queue string) error {
 	for {
 		select {
 		case msg := <-channel:
-			utils.dispatch(queue, msg)
+			utils.consume(queue, msg)
 		case c := <-utils.ctrl:
 			if c {
 				log.Infoln("stop-ctrl")
@@ -236,17 +549,70 @@ func (utils *RabbitmqUtils) registerHandler(queue string, handler func(broker ra
 	utils.container.Store(queue, handlers)
 }
 
+// Stop 停止消费 [取消进行中的处理]
 func (utils *RabbitmqUtils) Stop() {
+	utils.consuming.Cancel()
 	utils.ctrl <- true
 }
 
-func (utils *RabbitmqUtils) dispatch(queue string, delivery amqp.Delivery) {
-	utils.locker.Lock()
-	defer utils.locker.Unlock()
+// Context 消费上下文 [Stop 时取消]
+func (utils *RabbitmqUtils) Context() context.Context {
+	return utils.consuming.Context()
+}
+
+// 消费单条消息 [处理异常不中断消费循环, 毒消息隔离后确认]
+func (utils *RabbitmqUtils) consume(queue string, delivery amqp.Delivery) {
+	var (
+		msg        = entity.MessageOfDelivery(&delivery).WithContext(utils.Context())
+		quarantine = GetQuarantineRepo()
+	)
+	if quarantine.Begin(DriverAmqp, msg) {
+		if err := delivery.Ack(false); err != nil {
+			log.WithField("queue", queue).Errorln("quarantine ack error:", err)
+		}
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			quarantine.Done(msg)
+			var err error
+			if quarantine.Panicked(DriverAmqp, "", msg, fmt.Errorf("panic: %v", e), string(debug.Stack())) {
+				err = delivery.Ack(false)
+			} else {
+				err = delivery.Nack(false, true)
+			}
+			log.WithFields(log.Fields{"queue": queue, "id": msg.ID}).Errorln("queue dispatch panic:", e, err)
+		}
+	}()
+	utils.dispatch(queue, delivery, msg, func() {
+		quarantine.Done(msg)
+	})
+}
+
+// 分发消息 [done: 处理完成回调]
+func (utils *RabbitmqUtils) dispatch(queue string, delivery amqp.Delivery, msg *entity.QueueMessage, done func()) {
+	// rpc 调用 处理完成后应答调用方
+	var responder = withResponder(msg, delivery, utils.publish)
+	// 绑定分发 各绑定独立处理
+	if fanout := utils.fanoutOf(queue); fanout != nil && fanout.Len() > 0 {
+		var reply = replyDelivery(delivery)
+		fanout.Dispatch(msg, func(action entity.ConsumeAction) error {
+			done()
+			if err := responder.Reply(action); err != nil {
+				log.WithFields(log.Fields{"queue": queue, "id": msg.ID, "reply_to": delivery.ReplyTo}).Errorln("rpc reply error:", err)
+			}
+			return reply(action)
+		})
+		return
+	}
+	defer done()
+	// 回调列表只读副本 处理时不持有锁
+	utils.locker.RLock()
 	var (
 		entry, ok = utils.container.Load(queue)
 		handlers  []func(broker rabbitmq.MessageWrapper)
 	)
+	utils.locker.RUnlock()
 	if !ok {
 		log.Infoln(string(delivery.Body))
 		return
@@ -262,10 +628,102 @@ func (utils *RabbitmqUtils) dispatch(queue string, delivery amqp.Delivery) {
 		if handler == nil {
 			continue
 		}
-		handler(rabbitmq.NewMessageWrapper(delivery))
+		handler(&contextDelivery{AmqpMessageWrapper: rabbitmq.NewMessageWrapper(delivery), ctx: msg.Context()})
+	}
+}
+
+// Bind 添加队列绑定 [同一队列多个绑定按过滤条件分发, 绑定名称不可重复]
+func (utils *RabbitmqUtils) Bind(queue string, bindings ...*QueueBinding) error {
+	var fanout = NewQueueFanout(queue, utils).SetDriver(DriverAmqp)
+	if entry, loaded := utils.fanouts.LoadOrStore(queue, fanout); loaded {
+		fanout = entry.(*QueueFanout)
+	}
+	return fanout.Bind(bindings...)
+}
+
+// Unbind 移除队列绑定 [未指定名称时移除全部]
+func (utils *RabbitmqUtils) Unbind(queue string, names ...string) {
+	var fanout = utils.fanoutOf(queue)
+	if fanout == nil {
+		return
+	}
+	if len(names) == 0 {
+		fanout.Close()
+		return
+	}
+	for _, name := range names {
+		fanout.Unbind(name)
+	}
+}
+
+// Consume 按绑定消费 [阻塞, 预取数为绑定容量之和, 停止时移除本次绑定]
+func (utils *RabbitmqUtils) Consume(queue string, bindings ...*QueueBinding) error {
+	if len(bindings) == 0 {
+		return errors.New("queue bindings empty")
+	}
+	if err := utils.QueueDeclare(queue); err != nil {
+		return err
+	}
+	if err := utils.Bind(queue, bindings...); err != nil {
+		return err
+	}
+	var names = make([]string, 0, len(bindings))
+	for _, binding := range bindings {
+		names = append(names, binding.Name)
+	}
+	defer utils.Unbind(queue, names...)
+	// 预取数取全部绑定容量 [全部绑定处理完成才应答, 预取 1 时慢绑定阻塞其他绑定]
+	if err := utils.getClient().Qos(&rabbitmq.QosParams{PrefetchCount: utils.fanoutOf(queue).Prefetch()}); err != nil {
+		return err
+	}
+	return utils.wait(queue)
+}
+
+func (utils *RabbitmqUtils) fanoutOf(queue string) *QueueFanout {
+	if entry, ok := utils.fanouts.Load(queue); ok {
+		return entry.(*QueueFanout)
+	}
+	return nil
+}
+
+// 按处理动作应答 amqp 投递
+func replyDelivery(delivery amqp.Delivery) func(action entity.ConsumeAction) error {
+	return func(action entity.ConsumeAction) error {
+		switch action {
+		case entity.ConsumeAck:
+			return delivery.Ack(false)
+		case entity.ConsumeRetry:
+			return delivery.Nack(false, true)
+		default:
+			return delivery.Nack(false, false)
+		}
 	}
 }
 
+// 重试队列参数 [消息 ttl 到期后死信至原队列, 空闲时自动删除]
+func withRetryQueueOptions(queue string, ttl time.Duration) func(params interface{}) {
+	return func(params interface{}) {
+		if queueParams, ok := params.(*rabbitmq.QueueParams); ok {
+			queueParams.SetArgs(rabbitmq.ArgMsgTtlKey, ttl.Milliseconds())
+			queueParams.SetArgs(rabbitmq.ArgDeadLetterExchange, "")
+			queueParams.SetArgs(rabbitmq.ArgDeadLetterRoutingKey, queue)
+			queueParams.SetArgs(rabbitmq.ArgQueueExpires, (ttl + retryQueueIdle).Milliseconds())
+		}
+	}
+}
+
+// 延迟分档 [10s 内按 100ms, 其余按秒取整, 控制重试队列数量]
+func retryBucket(delay time.Duration) time.Duration {
+	var bucket = delay.Round(time.Second)
+	if delay < 10*time.Second {
+		bucket = delay.Round(100 * time.Millisecond)
+	}
+	if bucket <= 0 {
+		bucket = 100 * time.Millisecond
+	}
+	return bucket
+}
+
 // WithGoodQueueOptions 推荐配置
 func WithGoodQueueOptions(params interface{}) {
 	if params == nil {
@@ -282,6 +740,12 @@ func WithGoodQueueOptions(params interface{}) {
 	return
 }
 
+// 生成消息ID
+func createMessageID(key string) string {
+	var data = fmt.Sprintf("%d:%d:%s", time.Now().UnixNano(), rand.Int63(), key)
+	return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+}
+
 // WithAckConsumeOption 手动ack
 func WithAckConsumeOption(params interface{}) {
 	if params == nil {
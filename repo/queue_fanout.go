@@ -0,0 +1,424 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// QueueBinding 队列绑定 [同一队列多个绑定, 各自过滤条件、协程池及处理结果]
+	QueueBinding struct {
+		Name        string
+		Filter      *entity.MessageFilter
+		Concurrency int // 协程池容量 [队列 ConsumerMaxNum]
+		Waiting     int // 排队上限 [超出时该绑定按重试处理]
+		Consumer    facede.Consumer
+		Retry       *entity.RetryPolicy // 重试策略 [为空时重试结果直接重新入队]
+		Dedup       *Dedup              // 去重窗口 [为空时不去重]
+		Timeout     time.Duration       // 单条消息处理超时 [0 不限时, 超时视为重试]
+		pool        *BindingPool
+		retrier     *Retrier
+	}
+
+	// QueueFanout 队列分发 [消息投递给过滤匹配的绑定, 全部绑定处理完成后统一应答]
+	QueueFanout struct {
+		queue    string
+		driver   string
+		locker   sync.RWMutex
+		bindings []*QueueBinding
+		entry    facede.QueueEntry
+		logger   *logrus.Logger
+	}
+
+	// 单次投递处理进度
+	fanoutDelivery struct {
+		locker  sync.Mutex
+		msg     *entity.QueueMessage
+		pending int
+		results map[string]entity.ConsumeAction
+		reply   func(action entity.ConsumeAction) error
+	}
+
+	// 消费上下文中的绑定名
+	fanoutBindingKey struct{}
+)
+
+const (
+	// HeaderFanoutTarget 定向绑定 [部分绑定失败重投时 只投递给该绑定]
+	HeaderFanoutTarget = "x-fanout-target"
+)
+
+var (
+	ErrorBindingName     = errors.New("queue binding name empty")
+	ErrorBindingConsumer = errors.New("queue binding consumer nil")
+	ErrorBindingExists   = errors.New("queue binding exists")
+)
+
+// NewQueueBinding 创建队列绑定 [filter 为空时匹配所有消息, concurrency 为绑定协程池容量]
+func NewQueueBinding(name, filter string, concurrency int, consumer facede.Consumer) (*QueueBinding, error) {
+	expr, err := entity.CompileFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("binding %s: %v", name, err)
+	}
+	return &QueueBinding{Name: name, Filter: expr, Concurrency: concurrency, Consumer: consumer}, nil
+}
+
+// NewQueueFanout 创建队列分发 [entry: 部分绑定重试时定向重投的队列实例]
+func NewQueueFanout(queue string, entry facede.QueueEntry) *QueueFanout {
+	return &QueueFanout{
+		queue:  queue,
+		locker: sync.RWMutex{},
+		entry:  entry,
+		logger: GetLogger("consumer"),
+	}
+}
+
+// Bind 添加绑定并创建绑定协程池 [绑定名称不可重复]
+func (fanout *QueueFanout) Bind(bindings ...*QueueBinding) error {
+	fanout.locker.Lock()
+	defer fanout.locker.Unlock()
+	var names = make(map[string]bool)
+	for _, binding := range fanout.bindings {
+		names[binding.Name] = true
+	}
+	for _, binding := range bindings {
+		switch {
+		case binding == nil || binding.Name == "":
+			return ErrorBindingName
+		case binding.Consumer == nil:
+			return fmt.Errorf("%w: %s", ErrorBindingConsumer, binding.Name)
+		case names[binding.Name]:
+			return fmt.Errorf("%w: %s", ErrorBindingExists, binding.Name)
+		}
+		names[binding.Name] = true
+	}
+	for i, binding := range bindings {
+		pool, err := NewBindingPool(fanout.queue, binding.Name, binding.Concurrency, binding.Waiting)
+		if err != nil {
+			for _, created := range bindings[:i] {
+				created.pool.Close()
+			}
+			return err
+		}
+		binding.pool = pool
+		if binding.Retry != nil && fanout.entry != nil {
+			binding.retrier = NewRetrier(fanout.entry, binding.Retry)
+		}
+	}
+	fanout.bindings = append(fanout.bindings, bindings...)
+	return nil
+}
+
+// Unbind 移除绑定 [等待已分发消息处理完成]
+func (fanout *QueueFanout) Unbind(name string) bool {
+	fanout.locker.Lock()
+	var removed *QueueBinding
+	for i, binding := range fanout.bindings {
+		if binding.Name == name {
+			removed = binding
+			fanout.bindings = append(fanout.bindings[:i:i], fanout.bindings[i+1:]...)
+			break
+		}
+	}
+	fanout.locker.Unlock()
+	if removed == nil {
+		return false
+	}
+	removed.pool.Close()
+	return true
+}
+
+// Close 移除所有绑定
+func (fanout *QueueFanout) Close() {
+	for _, name := range fanout.Names() {
+		fanout.Unbind(name)
+	}
+}
+
+// Prefetch 队列预取数 [各绑定协程池容量之和]
+// 全部绑定处理完成才应答, 慢绑定排队满时按重试定向重投, 不阻塞其他绑定
+func (fanout *QueueFanout) Prefetch() int {
+	fanout.locker.RLock()
+	defer fanout.locker.RUnlock()
+	var prefetch int
+	for _, binding := range fanout.bindings {
+		prefetch += binding.pool.Capacity()
+	}
+	return prefetch
+}
+
+// Names 绑定名称
+func (fanout *QueueFanout) Names() []string {
+	fanout.locker.RLock()
+	defer fanout.locker.RUnlock()
+	var names = make([]string, 0, len(fanout.bindings))
+	for _, binding := range fanout.bindings {
+		names = append(names, binding.Name)
+	}
+	return names
+}
+
+// Len 绑定数量
+func (fanout *QueueFanout) Len() int {
+	fanout.locker.RLock()
+	defer fanout.locker.RUnlock()
+	return len(fanout.bindings)
+}
+
+// Dispatch 分发消息 [无匹配绑定时确认, 定向消息只投递给目标绑定]
+func (fanout *QueueFanout) Dispatch(msg *entity.QueueMessage, reply func(action entity.ConsumeAction) error) {
+	fanout.locker.RLock()
+	defer fanout.locker.RUnlock()
+	var targets = fanout.match(msg)
+	if len(targets) == 0 {
+		var action = entity.ConsumeAck
+		// 定向绑定已移除 进入死信避免丢失
+		if target := msg.Headers.GetStr(HeaderFanoutTarget); target != "" {
+			action = entity.ConsumeDrop
+			fanout.logger.WithFields(logrus.Fields{"queue": fanout.queue, "id": msg.ID}).
+				Warnln("fanout target binding not found:", target)
+		}
+		fanout.answer(msg, reply, action)
+		return
+	}
+	var delivery = &fanoutDelivery{
+		msg:     msg,
+		pending: len(targets),
+		results: make(map[string]entity.ConsumeAction, len(targets)),
+		reply:   reply,
+	}
+	for _, binding := range targets {
+		var target = binding
+		// 排队已满 该绑定按重试处理 不阻塞其他绑定
+		if err := target.pool.Submit(func() {
+			fanout.settle(delivery, target.Name, fanout.handle(target, msg))
+		}); err != nil {
+			fanout.logger.WithFields(logrus.Fields{"queue": fanout.queue, "binding": target.Name, "id": msg.ID}).
+				Warnln("fanout submit error:", err)
+			fanout.failed(target, msg, entity.ConsumeRetry, err, "")
+			fanout.settle(delivery, target.Name, entity.ConsumeRetry)
+		}
+	}
+}
+
+// 匹配绑定 [过滤表达式求值]
+func (fanout *QueueFanout) match(msg *entity.QueueMessage) []*QueueBinding {
+	var (
+		targets []*QueueBinding
+		target  = msg.Headers.GetStr(HeaderFanoutTarget)
+	)
+	for _, binding := range fanout.bindings {
+		if target != "" {
+			if binding.Name == target {
+				return []*QueueBinding{binding}
+			}
+			continue
+		}
+		if binding.Filter.Match(msg) {
+			targets = append(targets, binding)
+		}
+	}
+	return targets
+}
+
+// SetDriver 设置队列驱动类型 [失败记录使用]
+func (fanout *QueueFanout) SetDriver(driver string) *QueueFanout {
+	fanout.driver = strings.ToLower(driver)
+	return fanout
+}
+
+// 绑定处理消息 [独立消息副本, 窗口内重复消息确认, 异常视为重试, 配置重试策略时延迟定向重投, 丢弃时投递死信队列]
+func (fanout *QueueFanout) handle(binding *QueueBinding, msg *entity.QueueMessage) entity.ConsumeAction {
+	var input = msg.Clone()
+	delete(input.Headers, HeaderFanoutTarget)
+	input.WithContext(context.WithValue(msg.Context(), fanoutBindingKey{}, binding.Name))
+	var key, duplicated = fanout.reserve(binding, input)
+	if duplicated {
+		return entity.ConsumeAck
+	}
+	var (
+		action, stack, err = fanout.invoke(binding, input)
+		result             = action
+	)
+	// 处理成功保留去重键至窗口结束, 失败释放 重试时可再次处理
+	if key != "" {
+		var e error
+		if action == entity.ConsumeAck {
+			e = binding.Dedup.Commit(key)
+		} else {
+			e = binding.Dedup.Release(key)
+		}
+		if e != nil {
+			err = joinError(err, e)
+		}
+	}
+	// 反复异常的毒消息 隔离后确认
+	if stack != "" && GetQuarantineRepo().Panicked(fanout.driver, binding.Name, input, err, stack) {
+		fanout.failed(binding, input, action, err, stack)
+		return entity.ConsumeAck
+	}
+	if action == entity.ConsumeRetry && binding.retrier != nil {
+		var e error
+		if result, e = binding.retrier.Retry(input, fanout.queue, entity.KvMap{HeaderFanoutTarget: binding.Name}); e != nil {
+			err = joinError(err, e)
+		}
+		if result == entity.ConsumeDrop {
+			action = entity.ConsumeDrop
+		}
+	}
+	// 丢弃的消息定向投递死信队列 [重放时只投递给该绑定]
+	if result == entity.ConsumeDrop {
+		var e error
+		if result, e = fanout.deadLetter(binding, input, err); e != nil {
+			err = joinError(err, e)
+		}
+	}
+	if err != nil || action != entity.ConsumeAck {
+		fanout.failed(binding, input, action, err, stack)
+	}
+	if err != nil {
+		fanout.logger.WithFields(logrus.Fields{
+			"queue":   fanout.queue,
+			"binding": binding.Name,
+			"type":    binding.Consumer.Type(),
+			"id":      msg.ID,
+			"action":  action.String(),
+		}).Errorln("consume error:", err)
+	}
+	return result
+}
+
+// BindingOf 当前处理消息的绑定名 [非绑定分发时为空, 消费器自行重投时定向该绑定]
+func BindingOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	var name, _ = ctx.Value(fanoutBindingKey{}).(string)
+	return name
+}
+
+// 占用去重键 [存储异常时不去重, 返回 true 为重复消息]
+func (fanout *QueueFanout) reserve(binding *QueueBinding, msg *entity.QueueMessage) (string, bool) {
+	if binding.Dedup == nil {
+		return "", false
+	}
+	var key = binding.Dedup.Key(fanout.queue, binding.Name, msg)
+	ok, err := binding.Dedup.Reserve(key)
+	if err != nil {
+		fanout.logger.WithFields(logrus.Fields{"queue": fanout.queue, "binding": binding.Name, "id": msg.ID}).
+			Warnln("dedup reserve error:", err)
+		return "", false
+	}
+	if !ok {
+		GetPrometheusRepo().IncrDedupDuplicate(fanout.queue, binding.Name)
+		fanout.logger.WithFields(logrus.Fields{"queue": fanout.queue, "binding": binding.Name, "id": msg.ID}).
+			Infoln("dedup duplicate message acked")
+		return "", true
+	}
+	return key, false
+}
+
+// 投递死信队列
+func (fanout *QueueFanout) deadLetter(binding *QueueBinding, msg *entity.QueueMessage, reason error) (entity.ConsumeAction, error) {
+	var letter = msg.Clone()
+	letter.Headers[HeaderFanoutTarget] = binding.Name
+	return DeadLetter(fanout.entry, letter, fanout.queue, reason)
+}
+
+// 调用消费器 [消息上下文派生绑定超时, 异常、超时及停止消费转换为重试]
+func (fanout *QueueFanout) invoke(binding *QueueBinding, msg *entity.QueueMessage) (action entity.ConsumeAction, stack string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			binding.pool.Panicked(e)
+			action, stack, err = entity.ConsumeRetry, string(debug.Stack()), fmt.Errorf("panic: %v", e)
+		}
+	}()
+	action, err = HandleContext(msg.Context(), binding.Consumer, msg, binding.Timeout)
+	return action, "", err
+}
+
+// 记录失败
+func (fanout *QueueFanout) failed(binding *QueueBinding, msg *entity.QueueMessage, action entity.ConsumeAction, err error, stack string) {
+	RecordFailure(&entity.ConsumeFailure{
+		Driver:   fanout.driver,
+		Queue:    fanout.queue,
+		Consumer: binding.Consumer.Type(),
+		Binding:  binding.Name,
+		Action:   action,
+		Panic:    stack != "",
+		Stack:    stack,
+		Err:      err,
+		Message:  msg,
+	})
+}
+
+// 记录绑定结果 [最后一个绑定完成时应答]
+func (fanout *QueueFanout) settle(delivery *fanoutDelivery, name string, action entity.ConsumeAction) {
+	delivery.locker.Lock()
+	delivery.results[name] = action
+	delivery.pending--
+	var done = delivery.pending == 0
+	delivery.locker.Unlock()
+	if done {
+		fanout.answer(delivery.msg, delivery.reply, fanout.outcome(delivery))
+	}
+}
+
+// 汇总结果 [全部重试:重新入队, 部分重试:定向重投后确认, 全部丢弃:丢弃, 其他:确认]
+func (fanout *QueueFanout) outcome(delivery *fanoutDelivery) entity.ConsumeAction {
+	var retries, drops []string
+	for name, action := range delivery.results {
+		switch action {
+		case entity.ConsumeRetry:
+			retries = append(retries, name)
+		case entity.ConsumeDrop:
+			drops = append(drops, name)
+		}
+	}
+	switch {
+	case len(retries) == len(delivery.results):
+		return entity.ConsumeRetry
+	case len(drops) == len(delivery.results):
+		return entity.ConsumeDrop
+	case len(retries) == 0:
+		return entity.ConsumeAck
+	case fanout.entry == nil:
+		return entity.ConsumeRetry
+	}
+	for _, name := range retries {
+		var forward = delivery.msg.Clone()
+		forward.Headers[HeaderFanoutTarget] = name
+		forward.Headers[entity.HeaderDeliveryCount] = delivery.msg.Attempts + 1
+		if err := fanout.entry.Push(forward, fanout.queue); err != nil {
+			fanout.logger.WithFields(logrus.Fields{"queue": fanout.queue, "binding": name, "id": delivery.msg.ID}).
+				Errorln("fanout republish error:", err)
+			return entity.ConsumeRetry
+		}
+	}
+	return entity.ConsumeAck
+}
+
+func (fanout *QueueFanout) answer(msg *entity.QueueMessage, reply func(action entity.ConsumeAction) error, action entity.ConsumeAction) {
+	if reply == nil {
+		return
+	}
+	if err := reply(action); err != nil {
+		fanout.logger.WithFields(logrus.Fields{"queue": fanout.queue, "id": msg.ID}).Errorln("fanout reply error:", err)
+	}
+}
+
+// 合并错误 [保留后者错误链]
+func joinError(err, next error) error {
+	if err == nil {
+		return next
+	}
+	return fmt.Errorf("%v; %w", err, next)
+}
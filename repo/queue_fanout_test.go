@@ -0,0 +1,229 @@
+package repo
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录消费消息 返回固定结果
+type fanoutTestConsumer struct {
+	locker  sync.Mutex
+	action  entity.ConsumeAction
+	handled []*entity.QueueMessage
+}
+
+func (consumer *fanoutTestConsumer) Type() string {
+	return "FanoutTest"
+}
+
+func (consumer *fanoutTestConsumer) Parse([]byte) error {
+	return nil
+}
+
+func (consumer *fanoutTestConsumer) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	consumer.locker.Lock()
+	defer consumer.locker.Unlock()
+	consumer.handled = append(consumer.handled, msg)
+	if msg.Headers.GetBool("panic") {
+		panic("fanout test panic")
+	}
+	return consumer.action, nil
+}
+
+func (consumer *fanoutTestConsumer) count() int {
+	consumer.locker.Lock()
+	defer consumer.locker.Unlock()
+	return len(consumer.handled)
+}
+
+// 记录投递消息
+type fanoutTestEntry struct {
+	facede.QueueEntry
+	locker    sync.Mutex
+	published []*entity.QueueMessage
+	queues    []string
+}
+
+func (entry *fanoutTestEntry) Push(data interface{}, queue ...string) error {
+	entry.locker.Lock()
+	defer entry.locker.Unlock()
+	entry.published = append(entry.published, data.(*entity.QueueMessage))
+	entry.queues = append(entry.queues, append(queue, "")[0])
+	return nil
+}
+
+func (entry *fanoutTestEntry) messages() []*entity.QueueMessage {
+	entry.locker.Lock()
+	defer entry.locker.Unlock()
+	return append([]*entity.QueueMessage{}, entry.published...)
+}
+
+func TestQueueFanout_Dispatch(t *testing.T) {
+	var (
+		orders  = &fanoutTestConsumer{action: entity.ConsumeAck}
+		audit   = &fanoutTestConsumer{action: entity.ConsumeRetry}
+		entry   = new(fanoutTestEntry)
+		replies = make(chan entity.ConsumeAction, 4)
+		fanout  = NewQueueFanout("events", entry)
+		reply   = func(action entity.ConsumeAction) error {
+			replies <- action
+			return nil
+		}
+	)
+	defer fanout.Close()
+	ordersBinding, err := NewQueueBinding("orders", `headers.type == "order"`, 2, orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditBinding, _ := NewQueueBinding("audit", "", 1, audit)
+	if err = fanout.Bind(ordersBinding, auditBinding); err != nil {
+		t.Fatal(err)
+	}
+	if err = fanout.Bind(&QueueBinding{Name: "audit", Consumer: audit}); err == nil {
+		t.Fatal("QueueFanout Bind duplicate name expect error")
+	}
+
+	// 部分绑定重试: 定向重投后确认原消息
+	fanout.Dispatch(entity.NewQueueMessage("events", []byte(`{}`), entity.KvMap{"type": "order"}), reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("QueueFanout mixed result reply %s, want ack", action)
+	}
+	var published = entry.messages()
+	if len(published) != 1 || published[0].Headers.GetStr(HeaderFanoutTarget) != "audit" {
+		t.Fatalf("QueueFanout republish %v", published)
+	}
+	if orders.count() != 1 || audit.count() != 1 {
+		t.Fatalf("QueueFanout handled orders=%d audit=%d", orders.count(), audit.count())
+	}
+
+	// 定向消息只投递给目标绑定, 全部重试时重新入队
+	fanout.Dispatch(published[0], reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeRetry {
+		t.Fatalf("QueueFanout target reply %s, want retry", action)
+	}
+	if orders.count() != 1 || audit.count() != 2 {
+		t.Fatalf("QueueFanout target handled orders=%d audit=%d", orders.count(), audit.count())
+	}
+	if audit.handled[1].Headers.GetStr(HeaderFanoutTarget) != "" {
+		t.Fatal("QueueFanout target header should be removed before handle")
+	}
+
+	// 目标绑定不存在 进入死信
+	fanout.Unbind("audit")
+	fanout.Dispatch(published[0], reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeDrop {
+		t.Fatalf("QueueFanout missing target reply %s, want drop", action)
+	}
+	// 无匹配绑定 直接确认
+	fanout.Dispatch(entity.NewQueueMessage("events", []byte(`{}`), entity.KvMap{"type": "user"}), reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("QueueFanout unmatched reply %s, want ack", action)
+	}
+}
+
+func TestQueueFanout_Retry(t *testing.T) {
+	var (
+		entry   = new(fanoutTestEntry)
+		audit   = &fanoutTestConsumer{action: entity.ConsumeRetry}
+		replies = make(chan entity.ConsumeAction, 2)
+		fanout  = NewQueueFanout("events", entry)
+		reply   = func(action entity.ConsumeAction) error {
+			replies <- action
+			return nil
+		}
+	)
+	defer fanout.Close()
+	var policy = &entity.RetryPolicy{MaxAttempts: 1, InitialDelay: 10 * time.Millisecond, Multiplier: 1}
+	if err := fanout.Bind(&QueueBinding{Name: "audit", Consumer: audit, Retry: policy}); err != nil {
+		t.Fatal(err)
+	}
+	// 延迟重投后确认 [驱动不支持延迟投递 使用内部延迟队列]
+	fanout.Dispatch(entity.NewQueueMessage("events", []byte(`{}`)), reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("QueueFanout retry reply %s, want ack", action)
+	}
+	var deadline = time.Now().Add(time.Second)
+	for len(entry.messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var published = entry.messages()
+	if len(published) != 1 || entity.RetryAttempt(published[0]) != 1 || published[0].Headers.GetStr(HeaderFanoutTarget) != "audit" {
+		t.Fatalf("QueueFanout delayed retry %v", published)
+	}
+	// 重试次数用尽 丢弃
+	fanout.Dispatch(published[0], reply)
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeDrop {
+		t.Fatalf("QueueFanout exhausted reply %s, want drop", action)
+	}
+}
+
+func waitFanoutReply(t *testing.T, replies chan entity.ConsumeAction) entity.ConsumeAction {
+	select {
+	case action := <-replies:
+		return action
+	case <-time.After(time.Second):
+		t.Fatal("QueueFanout reply timeout")
+	}
+	return entity.ConsumeAck
+}
+
+// 阻塞至释放
+type fanoutBlockingConsumer struct {
+	fanoutTestConsumer
+	release chan struct{}
+}
+
+func (consumer *fanoutBlockingConsumer) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	<-consumer.release
+	return consumer.fanoutTestConsumer.Handle(msg)
+}
+
+func TestQueueFanout_Prefetch(t *testing.T) {
+	var (
+		entry  = new(fanoutTestEntry)
+		fast   = &fanoutTestConsumer{action: entity.ConsumeAck}
+		slow   = &fanoutBlockingConsumer{fanoutTestConsumer{action: entity.ConsumeAck}, make(chan struct{})}
+		fanout = NewQueueFanout("events", entry)
+	)
+	defer fanout.Close()
+	defer close(slow.release)
+	if err := fanout.Bind(
+		&QueueBinding{Name: "slow", Consumer: slow, Concurrency: 1, Waiting: 2},
+		&QueueBinding{Name: "fast", Consumer: fast, Concurrency: 2},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if fanout.Prefetch() != 105 {
+		t.Fatalf("QueueFanout Prefetch %d, want 105", fanout.Prefetch())
+	}
+	// 模拟 broker 预取窗口: 未应答消息达到预取数时停止投递, 逐条到达
+	var (
+		total  = 300
+		window = make(chan struct{}, fanout.Prefetch())
+	)
+	go func() {
+		for i := 0; i < total; i++ {
+			window <- struct{}{}
+			runtime.Gosched()
+			fanout.Dispatch(entity.NewQueueMessage("events", []byte(`{}`)), func(entity.ConsumeAction) error {
+				<-window
+				return nil
+			})
+		}
+	}()
+	// 慢绑定阻塞期间 快绑定处理全部消息, 慢绑定溢出部分定向重投
+	var deadline = time.Now().Add(5 * time.Second)
+	for fast.count() < total {
+		if time.Now().After(deadline) {
+			t.Fatalf("QueueFanout fast binding handled %d, want %d", fast.count(), total)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(entry.messages()) == 0 {
+		t.Fatal("QueueFanout slow binding overflow should be republished")
+	}
+}
@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/queue_mgr/entity"
+)
+
+// 队列查看所需的信道操作 [*amqp.Channel]
+type inspectChannel interface {
+	QueueInspect(name string) (amqp.Queue, error)
+	QueuePurge(name string, noWait bool) (int, error)
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Ack(tag uint64, multiple bool) error
+	Nack(tag uint64, multiple, requeue bool) error
+}
+
+const (
+	defaultPeekSize = 10
+	maxPeekSize     = 100
+)
+
+// Len 队列就绪消息数
+func (utils *RabbitmqUtils) Len(queues ...string) (int, error) {
+	queues = append(queues, "default")
+	var size int
+	err := utils.withChannel(func(channel inspectChannel) (err error) {
+		size, err = queueLen(channel, queues[0])
+		return err
+	})
+	return size, err
+}
+
+func queueLen(channel inspectChannel, queue string) (int, error) {
+	info, err := channel.QueueInspect(queue)
+	if err != nil {
+		return 0, err
+	}
+	return info.Messages, nil
+}
+
+// Peek 获取并重新入队 头部 n 条消息 [重新入队的消息标记为 redelivered, quorum 队列投递次数加 1]
+func (utils *RabbitmqUtils) Peek(n int, queues ...string) ([]*entity.QueueMessage, error) {
+	queues = append(queues, "default")
+	var messages []*entity.QueueMessage
+	err := utils.withChannel(func(channel inspectChannel) (err error) {
+		messages, err = peekQueue(channel, n, queues[0])
+		return err
+	})
+	return messages, err
+}
+
+func peekQueue(channel inspectChannel, n int, queue string) ([]*entity.QueueMessage, error) {
+	if n <= 0 {
+		n = defaultPeekSize
+	}
+	if n > maxPeekSize {
+		n = maxPeekSize
+	}
+	var (
+		last     uint64
+		messages []*entity.QueueMessage
+	)
+	for i := 0; i < n; i++ {
+		delivery, ok, err := channel.Get(queue, false)
+		if err != nil {
+			return messages, err
+		}
+		if !ok {
+			break
+		}
+		last = delivery.DeliveryTag
+		if delivery.RoutingKey == "" {
+			delivery.RoutingKey = queue
+		}
+		messages = append(messages, entity.MessageOfDelivery(&delivery))
+	}
+	if last == 0 {
+		return messages, nil
+	}
+	// 全部重新入队
+	return messages, channel.Nack(last, true, true)
+}
+
+// Take 逐条获取消息 [未确认消息在信道关闭后按原顺序重回队列]
+func (utils *RabbitmqUtils) Take(n int, handler func(msg *entity.QueueMessage) error, queues ...string) (int, error) {
+	queues = append(queues, "default")
+	if handler == nil {
+		return 0, entity.ErrorRequired
+	}
+	var count int
+	err := utils.withChannel(func(channel inspectChannel) (err error) {
+		count, err = takeQueue(channel, n, handler, queues[0])
+		return err
+	})
+	return count, err
+}
+
+func takeQueue(channel inspectChannel, n int, handler func(msg *entity.QueueMessage) error, queue string) (int, error) {
+	var count int
+	for ; n <= 0 || count < n; count++ {
+		delivery, ok, err := channel.Get(queue, false)
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			return count, nil
+		}
+		if delivery.RoutingKey == "" {
+			delivery.RoutingKey = queue
+		}
+		err = handler(entity.MessageOfDelivery(&delivery))
+		if entity.IsSkipError(err) {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		if err = channel.Ack(delivery.DeliveryTag, false); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Purge 清空队列
+func (utils *RabbitmqUtils) Purge(queues ...string) (int, error) {
+	queues = append(queues, "default")
+	var size int
+	err := utils.withChannel(func(channel inspectChannel) (err error) {
+		size, err = purgeQueue(channel, queues[0])
+		return err
+	})
+	return size, err
+}
+
+func purgeQueue(channel inspectChannel, queue string) (int, error) {
+	return channel.QueuePurge(queue, false)
+}
+
+// 使用独立信道执行 [队列不存在等错误会关闭信道, 不影响消费发布信道]
+func (utils *RabbitmqUtils) withChannel(handler func(channel inspectChannel) error) error {
+	var client = utils.getClient()
+	if client == nil {
+		return errors.New("queue client connection failed")
+	}
+	channel, err := client.GetBroker().GetConnection().Channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = channel.Close()
+	}()
+	return handler(channel)
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+)
+
+// 内存信道 [Get 取出至未确认, Nack 批量按原顺序重回队列头部]
+type inspectTestChannel struct {
+	ready   []amqp.Delivery
+	unacked []amqp.Delivery
+	tag     uint64
+	nacks   int
+}
+
+func newInspectTestChannel(n int) *inspectTestChannel {
+	var channel = new(inspectTestChannel)
+	for i := 0; i < n; i++ {
+		channel.ready = append(channel.ready, amqp.Delivery{MessageId: fmt.Sprintf("m-%d", i), Body: []byte(fmt.Sprintf("%d", i))})
+	}
+	return channel
+}
+
+func (channel *inspectTestChannel) QueueInspect(name string) (amqp.Queue, error) {
+	return amqp.Queue{Name: name, Messages: len(channel.ready)}, nil
+}
+
+func (channel *inspectTestChannel) QueuePurge(string, bool) (int, error) {
+	var n = len(channel.ready)
+	channel.ready = nil
+	return n, nil
+}
+
+func (channel *inspectTestChannel) Get(string, bool) (amqp.Delivery, bool, error) {
+	if len(channel.ready) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	var delivery = channel.ready[0]
+	channel.ready = channel.ready[1:]
+	channel.tag++
+	delivery.DeliveryTag = channel.tag
+	channel.unacked = append(channel.unacked, delivery)
+	return delivery, true, nil
+}
+
+func (channel *inspectTestChannel) Ack(tag uint64, _ bool) error {
+	for i, delivery := range channel.unacked {
+		if delivery.DeliveryTag == tag {
+			channel.unacked = append(channel.unacked[:i], channel.unacked[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("unknown delivery tag")
+}
+
+func (channel *inspectTestChannel) Nack(tag uint64, multiple, requeue bool) error {
+	if !multiple || !requeue || tag != channel.tag {
+		return errors.New("expect requeue all deliveries")
+	}
+	channel.nacks++
+	for i := range channel.unacked {
+		channel.unacked[i].Redelivered = true
+	}
+	channel.ready = append(channel.unacked, channel.ready...)
+	channel.unacked = nil
+	return nil
+}
+
+func TestQueueInspect(t *testing.T) {
+	var channel = newInspectTestChannel(120)
+	if n, err := queueLen(channel, "orders"); n != 120 || err != nil {
+		t.Fatalf("Len %d %v, want 120", n, err)
+	}
+
+	// 默认 10 条, 查看后按原顺序重回队列并标记重投
+	messages, err := peekQueue(channel, 0, "orders")
+	if err != nil || len(messages) != defaultPeekSize {
+		t.Fatalf("Peek %d %v, want %d", len(messages), err, defaultPeekSize)
+	}
+	if messages[0].ID != "m-0" || messages[0].Queue != "orders" || messages[0].Redelivered {
+		t.Fatalf("Peek head %+v", messages[0])
+	}
+	if n, _ := queueLen(channel, "orders"); n != 120 || channel.ready[0].MessageId != "m-0" || !channel.ready[0].Redelivered {
+		t.Fatalf("Peek should requeue in order, got %d %s", n, channel.ready[0].MessageId)
+	}
+	messages, _ = peekQueue(channel, 1, "orders")
+	if len(messages) != 1 || !messages[0].Redelivered || messages[0].Attempts != 1 {
+		t.Fatalf("Peek again %+v, want redelivered", messages[0])
+	}
+
+	// 最多 100 条
+	if messages, _ = peekQueue(channel, 1000, "orders"); len(messages) != maxPeekSize {
+		t.Fatalf("Peek %d, want %d", len(messages), maxPeekSize)
+	}
+
+	// 空队列不应答
+	var empty = newInspectTestChannel(0)
+	if messages, err = peekQueue(empty, 5, "orders"); len(messages) != 0 || err != nil || empty.nacks != 0 {
+		t.Fatalf("Peek empty %d %v nacks %d", len(messages), err, empty.nacks)
+	}
+
+	// 跳过的消息保留, 其余确认移除
+	count, err := takeQueue(channel, 3, func(msg *entity.QueueMessage) error {
+		if msg.ID == "m-1" {
+			return entity.ErrorMessageSkip
+		}
+		return nil
+	}, "orders")
+	if count != 3 || err != nil || len(channel.unacked) != 1 || channel.unacked[0].MessageId != "m-1" {
+		t.Fatalf("Take %d %v unacked %d", count, err, len(channel.unacked))
+	}
+
+	if n, err := purgeQueue(channel, "orders"); n != 117 || err != nil {
+		t.Fatalf("Purge %d %v, want 117", n, err)
+	}
+	if n, _ := queueLen(channel, "orders"); n != 0 {
+		t.Fatalf("Len after purge %d, want 0", n)
+	}
+}
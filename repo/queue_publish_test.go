@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"github.com/streadway/amqp"
+	"github.com/weblfe/drivers/rabbitmq"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 发布端 [按 reply 模拟 broker 确认及退回]
+type publishTestClient struct {
+	locker    sync.Mutex
+	confirmed bool
+	seq       uint64
+	confirms  chan amqp.Confirmation
+	returns   []chan amqp.Return
+	sent      []rabbitmq.MessageParams
+	reply     func(msg rabbitmq.MessageParams) (ack, returned bool)
+}
+
+func (client *publishTestClient) Send(msg rabbitmq.MessageParams) error {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+	client.sent = append(client.sent, msg)
+	var ack, returned = client.reply(msg)
+	// broker 先于 ack 发送 basic.return
+	if returned && msg.Mandatory {
+		for _, returns := range client.returns {
+			returns <- amqp.Return{RoutingKey: msg.Key, MessageId: msg.Msg.MessageId, ReplyText: "NO_ROUTE", Body: msg.Msg.Body}
+		}
+	}
+	if client.confirmed {
+		client.seq++
+		client.confirms <- amqp.Confirmation{DeliveryTag: client.seq, Ack: ack}
+	}
+	return nil
+}
+
+func (client *publishTestClient) channel() publishChannel {
+	return client
+}
+
+func (client *publishTestClient) Confirm(bool) error {
+	client.confirmed = true
+	return nil
+}
+
+func (client *publishTestClient) NotifyPublish(confirms chan amqp.Confirmation) chan amqp.Confirmation {
+	client.confirms = confirms
+	return confirms
+}
+
+func (client *publishTestClient) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+	client.returns = append(client.returns, returns)
+	return returns
+}
+
+func (client *publishTestClient) messages() []rabbitmq.MessageParams {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+	return append([]rabbitmq.MessageParams{}, client.sent...)
+}
+
+func newPublishTestUtils() *RabbitmqUtils {
+	var mq = &RabbitmqUtils{confirmTimeout: time.Second}
+	mq.container.Store("fallback.declare", true)
+	return mq
+}
+
+func TestRabbitmqUtils_PublishConfirm(t *testing.T) {
+	var (
+		mq     = newPublishTestUtils().SetPublishConfirm(true)
+		ack    = true
+		client = &publishTestClient{reply: func(msg rabbitmq.MessageParams) (bool, bool) {
+			return ack, msg.Key == "missing"
+		}}
+	)
+	if err := mq.publishWith(client, *rabbitmq.MessageParamsOf("", "orders", "a")); err != nil {
+		t.Fatalf("expect ack, got %v", err)
+	}
+	ack = false
+	if err := mq.publishWith(client, *rabbitmq.MessageParamsOf("", "orders", "b")); err != ErrorPublishNack {
+		t.Fatalf("expect nack, got %v", err)
+	}
+
+	// 确认模式开启后再开启 mandatory 仍能识别退回 并转投备用队列
+	ack = true
+	mq.SetMandatory(true, "fallback")
+	if err := mq.publishWith(client, *rabbitmq.MessageParamsOf("", "missing", "c")); err != nil {
+		t.Fatalf("expect fallback publish, got %v", err)
+	}
+	var sent = client.messages()
+	var fallback = sent[len(sent)-1]
+	if len(sent) != 4 || !sent[2].Mandatory || fallback.Key != "fallback" || fallback.Mandatory || fallback.Msg.Headers[HeaderUnroutableKey] != "missing" {
+		t.Fatalf("expect fallback of returned message, got %d %s", len(sent), fallback.Key)
+	}
+
+	// 无备用队列 返回不可路由
+	mq.SetMandatory(true, "")
+	if err := mq.publishWith(client, *rabbitmq.MessageParamsOf("", "missing", "d")); err != ErrorUnroutable {
+		t.Fatalf("expect unroutable, got %v", err)
+	}
+
+	// 未确认 超时
+	mq.SetPublishConfirm(true, 20*time.Millisecond)
+	client.confirmed = false
+	if err := mq.publishWith(client, *rabbitmq.MessageParamsOf("", "orders", "e")); err != ErrorConfirmTimeout {
+		t.Fatalf("expect confirm timeout, got %v", err)
+	}
+}
+
+func TestRabbitmqUtils_PublishReturn(t *testing.T) {
+	var (
+		mq     = newPublishTestUtils().SetMandatory(true, "fallback")
+		client = &publishTestClient{reply: func(msg rabbitmq.MessageParams) (bool, bool) {
+			return true, msg.Key == "missing"
+		}}
+		wg sync.WaitGroup
+	)
+	// 发布与修改配置并发 [go test -race]
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			mq.SetMandatory(true, "fallback")
+		}
+	}()
+	if err := mq.publishWith(client, *rabbitmq.MessageParamsOf("", "missing", "a")); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// 非确认模式 异步转投备用队列
+	var deadline = time.Now().Add(time.Second)
+	for len(client.messages()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var sent = client.messages()
+	if len(sent) != 2 || sent[1].Key != "fallback" || string(sent[1].Msg.Body) != string(sent[0].Msg.Body) || sent[1].Msg.Headers[HeaderUnroutableText] != "NO_ROUTE" {
+		t.Fatalf("expect async fallback publish, got %d", len(sent))
+	}
+}
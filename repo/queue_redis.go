@@ -0,0 +1,446 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/utils"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisStreamQueue redis stream 队列 [XADD 入队, 消费组 XREADGROUP 读取, XACK+XDEL 确认]
+type RedisStreamQueue struct {
+	db       *RedisRepository
+	prefix   string
+	maxLen   int64
+	block    time.Duration
+	group    string
+	consumer string
+	ctrl     chan bool
+	// 消费上下文 [Stop 时取消]
+	consuming *consumeContext
+}
+
+const (
+	DriverRedis         = "REDIS"
+	redisFieldBody      = "body"
+	redisFieldHeaders   = "headers"
+	redisFieldType      = "content_type"
+	redisFieldMessageID = "message_id"
+	redisFieldTimestamp = "timestamp"
+	defaultRedisBlock   = time.Second
+	redisTakeBatch      = 100
+	redisDelayedSuffix  = ":delayed"
+	defaultRedisGroup   = "queue_mgr"
+	// 消费组读取位置 [0: 本消费者已读取未确认的消息, >: 未投递的新消息]
+	redisGroupPending = "0"
+	redisGroupNew     = ">"
+)
+
+// RedisStreamOf 创建 redis stream 队列 [namespace 为 redis 连接名]
+func RedisStreamOf(namespace ...string) *RedisStreamQueue {
+	namespace = append(namespace, "")
+	return &RedisStreamQueue{
+		db:        RedisDb(namespace[0]),
+		prefix:    utils.GetEnvVal("REDIS_QUEUE_PREFIX", "queue_mgr:"),
+		maxLen:    int64(utils.GetEnvInt("REDIS_QUEUE_MAX_LEN", 0)),
+		block:     utils.GetEnvDuration("REDIS_QUEUE_BLOCK", defaultRedisBlock),
+		group:     utils.GetEnvVal("REDIS_QUEUE_GROUP", defaultRedisGroup),
+		consumer:  utils.GetEnvVal("REDIS_QUEUE_CONSUMER", redisConsumerName()),
+		ctrl:      make(chan bool, 2),
+		consuming: new(consumeContext),
+	}
+}
+
+func (queue *RedisStreamQueue) getKey(queues []string) string {
+	queues = append(queues, "default")
+	return queue.prefix + queues[0]
+}
+
+// QueueDeclare stream 写入时自动创建
+func (queue *RedisStreamQueue) QueueDeclare(string, ...func(params interface{})) error {
+	return nil
+}
+
+// Push 推送消息
+func (queue *RedisStreamQueue) Push(data interface{}, queues ...string) error {
+	var values, err = queue.encode(data)
+	if err != nil {
+		return err
+	}
+	return queue.db.XAdd(&redis.XAddArgs{
+		Stream:       queue.getKey(queues),
+		MaxLenApprox: queue.maxLen,
+		Values:       values,
+	}).Err()
+}
+
+// PushDelay 延迟投递 [写入有序集合 score 为到期毫秒时间, 消费时转入 stream]
+func (queue *RedisStreamQueue) PushDelay(msg *entity.QueueMessage, delay time.Duration, target string) error {
+	values, err := queue.encode(msg)
+	if err != nil {
+		return err
+	}
+	// 随机序号避免相同内容成员覆盖
+	values["seq"] = fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
+	member, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return queue.db.ZAdd(queue.getKey([]string{target})+redisDelayedSuffix, redis.Z{
+		Score:  float64(time.Now().Add(delay).UnixNano() / int64(time.Millisecond)),
+		Member: string(member),
+	}).Err()
+}
+
+// 到期延迟消息转入 stream [ZREM 成功者写入, 多消费者不重复]
+func (queue *RedisStreamQueue) promote(key string) error {
+	var delayed = key + redisDelayedSuffix
+	members, err := queue.db.ZRangeByScore(delayed, redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+		Count: redisTakeBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if n, err := queue.db.ZRem(delayed, member).Result(); err != nil || n == 0 {
+			continue
+		}
+		var values map[string]interface{}
+		if err = json.Unmarshal([]byte(member), &values); err != nil {
+			continue
+		}
+		delete(values, "seq")
+		if body, ok := values[redisFieldBody].(string); ok {
+			// json 中 []byte 为 base64
+			if data, e := base64.StdEncoding.DecodeString(body); e == nil {
+				values[redisFieldBody] = data
+			}
+		}
+		if err = queue.db.XAdd(&redis.XAddArgs{Stream: key, MaxLenApprox: queue.maxLen, Values: values}).Err(); err != nil {
+			// 写入失败放回 稍后重试
+			queue.db.ZAdd(delayed, redis.Z{Score: float64(time.Now().UnixNano() / int64(time.Millisecond)), Member: member})
+			return err
+		}
+	}
+	return nil
+}
+
+// Pop 消费 [消费组阻塞读取, 回调后确认并删除]
+// 启动时先处理本消费者上次未确认的消息, 再从消费组位置读取 [首次创建消费组时包含已有积压]
+func (queue *RedisStreamQueue) Pop(callback func(broker rabbitmq.MessageWrapper), queues ...string) error {
+	var key = queue.getKey(queues)
+	return queue.read(key, func(v redis.XMessage) {
+		queue.consume(callback, queue.decode(key, v).WithContext(queue.Context()))
+		queue.ack(key, v.ID)
+	})
+}
+
+// Consume 按绑定消费 [阻塞, 处理中消息数不超过绑定容量之和, 全部绑定处理完成后按结果确认或重新写入]
+// 绑定重试策略经有序集合延迟重投, 重试用尽或丢弃时投递死信队列
+func (queue *RedisStreamQueue) Consume(name string, bindings ...*QueueBinding) error {
+	if len(bindings) == 0 {
+		return errors.New("queue bindings empty")
+	}
+	var fanout = NewQueueFanout(name, queue).SetDriver(DriverRedis)
+	if err := fanout.Bind(bindings...); err != nil {
+		return err
+	}
+	defer fanout.Close()
+	var (
+		key      = queue.getKey([]string{name})
+		inflight = make(chan struct{}, fanout.Prefetch())
+	)
+	return queue.read(key, func(v redis.XMessage) {
+		inflight <- struct{}{}
+		var msg = queue.decode(key, v).WithContext(queue.Context())
+		fanout.Dispatch(msg, func(action entity.ConsumeAction) error {
+			defer func() {
+				<-inflight
+			}()
+			return queue.reply(key, v.ID, msg, action)
+		})
+	})
+}
+
+// 消费组循环读取 [handle 处理单条消息, 停止消费时返回]
+func (queue *RedisStreamQueue) read(key string, handle func(v redis.XMessage)) error {
+	var last = redisGroupPending
+	if err := queue.createGroup(key); err != nil {
+		return err
+	}
+	for {
+		select {
+		case c := <-queue.ctrl:
+			if c {
+				return nil
+			}
+		default:
+		}
+		if err := queue.promote(key); err != nil {
+			return err
+		}
+		streams, err := queue.db.XReadGroup(&redis.XReadGroupArgs{
+			Group:    queue.group,
+			Consumer: queue.consumer,
+			Streams:  []string{key, last},
+			Count:    redisTakeBatch,
+			Block:    queue.block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		// 队列被清空时消费组随之删除 重新创建
+		if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+			if err = queue.createGroup(key); err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		var n int
+		for _, stream := range streams {
+			for _, v := range stream.Messages {
+				n++
+				if last != redisGroupNew {
+					last = v.ID
+				}
+				// 未确认期间已被删除的消息 仅确认
+				if v.Values == nil {
+					queue.ack(key, v.ID)
+					continue
+				}
+				handle(v)
+			}
+		}
+		// 未确认消息处理完成 开始读取新消息
+		if n == 0 && last != redisGroupNew {
+			last = redisGroupNew
+		}
+	}
+}
+
+// 确认并删除消息
+func (queue *RedisStreamQueue) ack(key, id string) error {
+	if err := queue.db.XAck(key, queue.group, id).Err(); err != nil {
+		return err
+	}
+	return queue.db.XDel(key, id).Err()
+}
+
+// 按处理动作应答 [重试: 重新写入 stream 后确认, 写入失败保留未确认 重启后再次处理; 确认及丢弃: 确认并删除]
+func (queue *RedisStreamQueue) reply(key, id string, msg *entity.QueueMessage, action entity.ConsumeAction) error {
+	if action == entity.ConsumeRetry {
+		var forward = msg.Clone()
+		forward.Headers[entity.HeaderDeliveryCount] = msg.Attempts + 1
+		if err := queue.Push(forward, msg.Queue); err != nil {
+			return err
+		}
+	}
+	return queue.ack(key, id)
+}
+
+// 创建消费组 [stream 不存在时创建, 已存在忽略]
+func (queue *RedisStreamQueue) createGroup(key string) error {
+	var err = queue.db.XGroupCreateMkStream(key, queue.group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// 消费单条消息 [处理异常不中断消费循环, 未达隔离阈值时重新入队]
+func (queue *RedisStreamQueue) consume(callback func(broker rabbitmq.MessageWrapper), msg *entity.QueueMessage) {
+	defer func() {
+		if e := recover(); e != nil {
+			GetLogger("consumer").WithFields(logrus.Fields{"queue": msg.Queue, "id": msg.ID}).Errorln("queue dispatch panic:", e)
+			if GetQuarantineRepo().Panicked(DriverRedis, "", msg, fmt.Errorf("panic: %v", e), string(debug.Stack())) {
+				return
+			}
+			if err := queue.Push(msg, msg.Queue); err != nil {
+				GetLogger("consumer").WithFields(logrus.Fields{"queue": msg.Queue, "id": msg.ID}).Errorln("queue requeue error:", err)
+			}
+		}
+	}()
+	callback(msg)
+}
+
+// Len 队列消息数
+func (queue *RedisStreamQueue) Len(queues ...string) (int, error) {
+	var n, err = queue.db.XLen(queue.getKey(queues)).Result()
+	return int(n), err
+}
+
+// Peek 查看头部 n 条消息
+func (queue *RedisStreamQueue) Peek(n int, queues ...string) ([]*entity.QueueMessage, error) {
+	if n <= 0 {
+		n = defaultPeekSize
+	}
+	if n > maxPeekSize {
+		n = maxPeekSize
+	}
+	var key = queue.getKey(queues)
+	items, err := queue.db.XRangeN(key, "-", "+", int64(n)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var messages = make([]*entity.QueueMessage, 0, len(items))
+	for _, v := range items {
+		messages = append(messages, queue.decode(key, v))
+	}
+	return messages, nil
+}
+
+// Purge 清空队列
+func (queue *RedisStreamQueue) Purge(queues ...string) (int, error) {
+	var key = queue.getKey(queues)
+	n, err := queue.db.XLen(key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), queue.db.Del(key).Err()
+}
+
+// Take 逐条获取消息 [handler 成功后删除, 跳过的消息保留原位]
+func (queue *RedisStreamQueue) Take(n int, handler func(msg *entity.QueueMessage) error, queues ...string) (int, error) {
+	if handler == nil {
+		return 0, entity.ErrorRequired
+	}
+	var (
+		count int
+		key   = queue.getKey(queues)
+		start = "-"
+	)
+	for n <= 0 || count < n {
+		items, err := queue.db.XRangeN(key, start, "+", redisTakeBatch).Result()
+		if err != nil {
+			return count, err
+		}
+		if len(items) == 0 {
+			return count, nil
+		}
+		for _, v := range items {
+			if n > 0 && count >= n {
+				return count, nil
+			}
+			start = nextStreamID(v.ID)
+			err = handler(queue.decode(key, v))
+			if entity.IsSkipError(err) {
+				count++
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			if err = queue.db.XDel(key, v.ID).Err(); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Stop 停止消费 [取消进行中的处理]
+func (queue *RedisStreamQueue) Stop() {
+	queue.consuming.Cancel()
+	queue.ctrl <- true
+}
+
+// Context 消费上下文 [Stop 时取消]
+func (queue *RedisStreamQueue) Context() context.Context {
+	return queue.consuming.Context()
+}
+
+// 消息编码
+func (queue *RedisStreamQueue) encode(data interface{}) (map[string]interface{}, error) {
+	var values = map[string]interface{}{
+		redisFieldTimestamp: time.Now().Unix(),
+	}
+	switch v := data.(type) {
+	case *entity.QueueMessage:
+		headers, err := json.Marshal(v.Headers)
+		if err != nil {
+			return nil, err
+		}
+		values[redisFieldBody] = v.Body
+		values[redisFieldHeaders] = string(headers)
+		values[redisFieldType] = v.ContentType
+		values[redisFieldMessageID] = v.ID
+		if !v.Timestamp.IsZero() {
+			values[redisFieldTimestamp] = v.Timestamp.Unix()
+		}
+	case []byte:
+		values[redisFieldBody] = v
+	case string:
+		values[redisFieldBody] = v
+	case fmt.Stringer:
+		values[redisFieldBody] = v.String()
+	default:
+		body, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		values[redisFieldBody] = body
+		values[redisFieldType] = "application/json"
+	}
+	return values, nil
+}
+
+// 消息解码
+func (queue *RedisStreamQueue) decode(key string, v redis.XMessage) *entity.QueueMessage {
+	var msg = entity.NewQueueMessage(strings.TrimPrefix(key, queue.prefix), []byte(fmt.Sprintf("%v", v.Values[redisFieldBody])))
+	msg.ID = v.ID
+	if id, ok := v.Values[redisFieldMessageID].(string); ok && id != "" {
+		msg.ID = id
+	}
+	if ty, ok := v.Values[redisFieldType].(string); ok {
+		msg.ContentType = ty
+	}
+	if headers, ok := v.Values[redisFieldHeaders].(string); ok && headers != "" {
+		_ = json.Unmarshal([]byte(headers), &msg.Headers)
+		msg.Attempts = msg.Headers.GetInt(entity.HeaderDeliveryCount)
+	}
+	if ts, ok := v.Values[redisFieldTimestamp].(string); ok {
+		if sec, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			msg.Timestamp = time.Unix(sec, 0)
+		}
+	}
+	msg.SetRowMessage(&v)
+	return msg
+}
+
+// stream 下一个最小ID [ms-seq]
+func nextStreamID(id string) string {
+	var parts = strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return id
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return id
+	}
+	return fmt.Sprintf("%s-%d", parts[0], seq+1)
+}
+
+// 默认消费者名 [主机名, 重启后可继续处理未确认的消息]
+func redisConsumerName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return fmt.Sprintf("consumer-%d", os.Getpid())
+}
@@ -0,0 +1,347 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// 内存 redis [仅实现 stream 队列用到的命令]
+	redisTestServer struct {
+		locker   sync.Mutex
+		listener net.Listener
+		seq      int64
+		streams  map[string]*redisTestStream
+		zsets    map[string]map[string]float64
+	}
+
+	redisTestStream struct {
+		entries []redisTestEntry
+		groups  map[string]*redisTestGroup
+	}
+
+	redisTestEntry struct {
+		seq    int64
+		fields []string
+	}
+
+	redisTestGroup struct {
+		last    int64
+		pending map[int64]bool
+	}
+
+	// 首次处理返回重试 记录处理时间
+	redisTestConsumer struct {
+		locker  sync.Mutex
+		handled []*entity.QueueMessage
+		at      []time.Time
+	}
+)
+
+func newRedisTestServer(t *testing.T) *redisTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server = &redisTestServer{
+		listener: listener,
+		streams:  make(map[string]*redisTestStream),
+		zsets:    make(map[string]map[string]float64),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return server
+}
+
+func (server *redisTestServer) queue(prefix string) *RedisStreamQueue {
+	return &RedisStreamQueue{
+		db:        NewRedisRepository(&RedisOptions{Addr: server.listener.Addr().String()}),
+		prefix:    prefix,
+		block:     10 * time.Millisecond,
+		group:     defaultRedisGroup,
+		consumer:  "test",
+		ctrl:      make(chan bool, 2),
+		consuming: new(consumeContext),
+	}
+}
+
+func (server *redisTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var reader = bufio.NewReader(conn)
+	for {
+		args, err := readRedisTestCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err = io.WriteString(conn, server.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readRedisTestCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	var args = make([]string, n)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		var buf = make([]byte, size+2)
+		if _, err = io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (server *redisTestServer) exec(args []string) string {
+	var block time.Duration
+	defer func() {
+		time.Sleep(block)
+	}()
+	server.locker.Lock()
+	defer server.locker.Unlock()
+	switch strings.ToLower(args[0]) {
+	case "xgroup":
+		var stream = server.stream(args[2])
+		if _, ok := stream.groups[args[3]]; ok {
+			return "-BUSYGROUP Consumer Group name already exists\r\n"
+		}
+		stream.groups[args[3]] = &redisTestGroup{pending: make(map[int64]bool)}
+		return "+OK\r\n"
+	case "xadd":
+		var (
+			stream = server.stream(args[1])
+			i      = 2
+		)
+		if strings.ToLower(args[i]) == "maxlen" {
+			i += 3
+		}
+		server.seq++
+		stream.entries = append(stream.entries, redisTestEntry{seq: server.seq, fields: args[i+1:]})
+		return redisTestBulk(fmt.Sprintf("1-%d", server.seq))
+	case "xreadgroup":
+		var (
+			group  = args[2]
+			key    = args[len(args)-2]
+			id     = args[len(args)-1]
+			stream = server.stream(key)
+			g, ok  = stream.groups[group]
+			items  []redisTestEntry
+		)
+		if !ok {
+			return "-NOGROUP No such key or consumer group\r\n"
+		}
+		for _, entry := range stream.entries {
+			switch {
+			case id == ">" && entry.seq > g.last:
+				g.last = entry.seq
+				g.pending[entry.seq] = true
+			case id != ">" && g.pending[entry.seq] && entry.seq > redisTestSeq(id):
+			default:
+				continue
+			}
+			items = append(items, entry)
+		}
+		if len(items) == 0 && id == ">" {
+			block = 10 * time.Millisecond
+			return "*-1\r\n"
+		}
+		var out = fmt.Sprintf("*1\r\n*2\r\n%s*%d\r\n", redisTestBulk(key), len(items))
+		for _, entry := range items {
+			out += fmt.Sprintf("*2\r\n%s*%d\r\n", redisTestBulk(fmt.Sprintf("1-%d", entry.seq)), len(entry.fields))
+			for _, field := range entry.fields {
+				out += redisTestBulk(field)
+			}
+		}
+		return out
+	case "xack":
+		var g, n = server.stream(args[1]).groups[args[2]], 0
+		for _, id := range args[3:] {
+			if g != nil && g.pending[redisTestSeq(id)] {
+				delete(g.pending, redisTestSeq(id))
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "xdel":
+		var stream, n = server.stream(args[1]), 0
+		for _, id := range args[2:] {
+			for i, entry := range stream.entries {
+				if entry.seq == redisTestSeq(id) {
+					stream.entries = append(stream.entries[:i], stream.entries[i+1:]...)
+					n++
+					break
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "xlen":
+		return fmt.Sprintf(":%d\r\n", len(server.stream(args[1]).entries))
+	case "zadd":
+		var zset = server.zset(args[1])
+		score, _ := strconv.ParseFloat(args[2], 64)
+		zset[args[3]] = score
+		return ":1\r\n"
+	case "zrangebyscore":
+		var (
+			zset    = server.zset(args[1])
+			max, _  = strconv.ParseFloat(args[3], 64)
+			members []string
+		)
+		for member, score := range zset {
+			if score <= max {
+				members = append(members, member)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return zset[members[i]] < zset[members[j]]
+		})
+		var out = fmt.Sprintf("*%d\r\n", len(members))
+		for _, member := range members {
+			out += redisTestBulk(member)
+		}
+		return out
+	case "zrem":
+		var zset = server.zset(args[1])
+		if _, ok := zset[args[2]]; !ok {
+			return ":0\r\n"
+		}
+		delete(zset, args[2])
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (server *redisTestServer) stream(key string) *redisTestStream {
+	if stream, ok := server.streams[key]; ok {
+		return stream
+	}
+	var stream = &redisTestStream{groups: make(map[string]*redisTestGroup)}
+	server.streams[key] = stream
+	return stream
+}
+
+func (server *redisTestServer) zset(key string) map[string]float64 {
+	if zset, ok := server.zsets[key]; ok {
+		return zset
+	}
+	var zset = make(map[string]float64)
+	server.zsets[key] = zset
+	return zset
+}
+
+func (server *redisTestServer) size(key string) (int, int) {
+	server.locker.Lock()
+	defer server.locker.Unlock()
+	return len(server.stream(key).entries), len(server.zset(key + redisDelayedSuffix))
+}
+
+func redisTestBulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func redisTestSeq(id string) int64 {
+	var seq, _ = strconv.ParseInt(id[strings.Index(id, "-")+1:], 10, 64)
+	return seq
+}
+
+func (consumer *redisTestConsumer) Type() string {
+	return "RedisTest"
+}
+
+func (consumer *redisTestConsumer) Parse([]byte) error {
+	return nil
+}
+
+func (consumer *redisTestConsumer) Handle(msg *entity.QueueMessage) (entity.ConsumeAction, error) {
+	consumer.locker.Lock()
+	defer consumer.locker.Unlock()
+	consumer.handled = append(consumer.handled, msg)
+	consumer.at = append(consumer.at, time.Now())
+	if len(consumer.handled) == 1 {
+		return entity.ConsumeRetry, nil
+	}
+	return entity.ConsumeAck, nil
+}
+
+func (consumer *redisTestConsumer) count() int {
+	consumer.locker.Lock()
+	defer consumer.locker.Unlock()
+	return len(consumer.handled)
+}
+
+func TestRedisStreamQueue_ConsumeRetry(t *testing.T) {
+	var (
+		server   = newRedisTestServer(t)
+		queue    = server.queue("test:")
+		consumer = new(redisTestConsumer)
+		done     = make(chan error, 1)
+		delay    = 100 * time.Millisecond
+	)
+	binding, err := NewQueueBinding("orders", "", 1, consumer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binding.Retry = &entity.RetryPolicy{MaxAttempts: 3, InitialDelay: delay, Multiplier: 1}
+	if err = queue.Push(entity.NewQueueMessage("orders", []byte(`{"id":1}`), entity.KvMap{"type": "order"}), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		done <- queue.Consume("orders", binding)
+	}()
+
+	// 首次重试 经有序集合延迟后再次投递
+	var deadline = time.Now().Add(3 * time.Second)
+	for consumer.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	queue.Stop()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if consumer.count() != 2 {
+		t.Fatalf("expect retry re-delivered, handled %d", consumer.count())
+	}
+	var retried = consumer.handled[1]
+	if elapsed := consumer.at[1].Sub(consumer.at[0]); elapsed < delay {
+		t.Fatalf("expect re-delivery after %s, got %s", delay, elapsed)
+	}
+	if entity.RetryAttempt(retried) != 1 || retried.Headers.GetStr("type") != "order" || string(retried.Body) != `{"id":1}` {
+		t.Fatalf("unexpected retried message %v %s", retried.Headers, retried.Body)
+	}
+	if messages, delayed := server.size("test:orders"); messages != 0 || delayed != 0 {
+		t.Fatalf("expect queue drained, got %d messages %d delayed", messages, delayed)
+	}
+}
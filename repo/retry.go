@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"fmt"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"sync"
+	"time"
+)
+
+type (
+	// Retrier 按重试策略延迟重投 [重试次数记录在消息头 x-retry-attempt]
+	Retrier struct {
+		entry  facede.QueueEntry
+		policy *entity.RetryPolicy
+	}
+
+	// 内部延迟队列 [驱动不支持延迟投递时使用, 进程内定时投递]
+	delayStore struct {
+		locker sync.Mutex
+		seq    uint64
+		items  map[uint64]*delayItem
+	}
+
+	delayItem struct {
+		timer *time.Timer
+		entry facede.QueueEntry
+		msg   *entity.QueueMessage
+		queue string
+	}
+)
+
+var (
+	delayStoreImpl = newDelayStore()
+)
+
+// NewRetrier 创建重试器 [policy 为空时使用默认策略]
+func NewRetrier(entry facede.QueueEntry, policy *entity.RetryPolicy) *Retrier {
+	if policy == nil {
+		policy = entity.NewRetryPolicy()
+	}
+	return &Retrier{entry: entry, policy: policy}
+}
+
+// Policy 重试策略
+func (retrier *Retrier) Policy() *entity.RetryPolicy {
+	return retrier.policy
+}
+
+// Retry 延迟重投消息 [已重投:确认, 次数用尽:丢弃, 重投失败:重新入队]
+func (retrier *Retrier) Retry(msg *entity.QueueMessage, queue string, headers entity.KvMap) (entity.ConsumeAction, error) {
+	var attempt = entity.RetryAttempt(msg) + 1
+	if retrier.policy.Exhausted(attempt) {
+		return entity.ConsumeDrop, fmt.Errorf("retry attempts exhausted: %d", attempt-1)
+	}
+	var (
+		delay   = retrier.policy.Delay(attempt)
+		forward = msg.Clone()
+	)
+	for k, v := range headers {
+		forward.Headers[k] = v
+	}
+	forward.Headers[entity.HeaderRetryAttempt] = attempt
+	forward.Headers[entity.HeaderRetryDelay] = delay.Milliseconds()
+	if err := DelayPush(retrier.entry, forward, delay, queue); err != nil {
+		return entity.ConsumeRetry, err
+	}
+	return entity.ConsumeAck, nil
+}
+
+// DelayPush 延迟投递 [驱动支持时由驱动实现, 否则进入内部延迟队列]
+func DelayPush(entry facede.QueueEntry, msg *entity.QueueMessage, delay time.Duration, queue string) error {
+	if entry == nil {
+		return entity.ErrorRequired
+	}
+	if delay <= 0 {
+		return entry.Push(msg, queue)
+	}
+	if delayed, ok := entry.(facede.DelayedQueue); ok {
+		return delayed.PushDelay(msg, delay, queue)
+	}
+	delayStoreImpl.Add(entry, msg, delay, queue)
+	return nil
+}
+
+func newDelayStore() *delayStore {
+	return &delayStore{items: make(map[uint64]*delayItem)}
+}
+
+// Add 添加延迟消息
+func (store *delayStore) Add(entry facede.QueueEntry, msg *entity.QueueMessage, delay time.Duration, queue string) {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	store.seq++
+	var (
+		id   = store.seq
+		item = &delayItem{entry: entry, msg: msg, queue: queue}
+	)
+	item.timer = time.AfterFunc(delay, func() {
+		store.fire(id)
+	})
+	store.items[id] = item
+}
+
+// 到期投递 [失败时按原延迟再次等待]
+func (store *delayStore) fire(id uint64) {
+	store.locker.Lock()
+	var item, ok = store.items[id]
+	delete(store.items, id)
+	store.locker.Unlock()
+	if !ok {
+		return
+	}
+	if err := item.entry.Push(item.msg, item.queue); err != nil {
+		GetLogger("consumer").WithField("queue", item.queue).WithField("id", item.msg.ID).Errorln("delay push error:", err)
+		store.Add(item.entry, item.msg, time.Duration(item.msg.Headers.GetInt(entity.HeaderRetryDelay, 1000))*time.Millisecond, item.queue)
+	}
+}
+
+// Len 待投递消息数
+func (store *delayStore) Len() int {
+	store.locker.Lock()
+	defer store.locker.Unlock()
+	return len(store.items)
+}
+
+// Flush 立即投递所有延迟消息 [停止服务前调用]
+func (store *delayStore) Flush() {
+	store.locker.Lock()
+	var ids = make([]uint64, 0, len(store.items))
+	for id, item := range store.items {
+		if item.timer.Stop() {
+			ids = append(ids, id)
+		}
+	}
+	store.locker.Unlock()
+	for _, id := range ids {
+		store.fire(id)
+	}
+}
+
+// GetDelayStore 内部延迟队列
+func GetDelayStore() *delayStore {
+	return delayStoreImpl
+}
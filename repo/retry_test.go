@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+	"time"
+)
+
+func TestRetrier_Retry(t *testing.T) {
+	var (
+		entry   = new(fanoutTestEntry)
+		retrier = NewRetrier(entry, &entity.RetryPolicy{MaxAttempts: 2, InitialDelay: 10 * time.Millisecond, Multiplier: 1})
+		msg     = entity.NewQueueMessage("orders", []byte(`{}`))
+	)
+	action, err := retrier.Retry(msg, "orders", entity.KvMap{"x-reason": "timeout"})
+	if err != nil || action != entity.ConsumeAck {
+		t.Fatalf("Retrier.Retry = %s, %v", action, err)
+	}
+	if entity.RetryAttempt(msg) != 0 {
+		t.Error("Retrier.Retry modified source message")
+	}
+	var deadline = time.Now().Add(time.Second)
+	for len(entry.messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var published = entry.messages()
+	if len(published) != 1 {
+		t.Fatalf("Retrier.Retry delayed push %d messages", len(published))
+	}
+	if entity.RetryAttempt(published[0]) != 1 || published[0].Headers.GetStr("x-reason") != "timeout" || published[0].Headers.GetInt(entity.HeaderRetryDelay) != 10 {
+		t.Errorf("Retrier.Retry headers %v", published[0].Headers)
+	}
+	published[0].Headers[entity.HeaderRetryAttempt] = 2
+	if action, err = retrier.Retry(published[0], "orders", nil); action != entity.ConsumeDrop || err == nil {
+		t.Errorf("Retrier.Retry exhausted = %s, %v", action, err)
+	}
+}
+
+func TestDelayStore_Flush(t *testing.T) {
+	var (
+		entry = new(fanoutTestEntry)
+		store = newDelayStore()
+	)
+	store.Add(entry, entity.NewQueueMessage("orders", []byte(`{}`)), time.Hour, "orders")
+	if store.Len() != 1 {
+		t.Fatalf("delayStore.Len = %d, want 1", store.Len())
+	}
+	store.Flush()
+	if store.Len() != 0 || len(entry.messages()) != 1 {
+		t.Errorf("delayStore.Flush left %d, pushed %d", store.Len(), len(entry.messages()))
+	}
+}
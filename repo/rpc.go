@@ -0,0 +1,292 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/utils"
+	"sync"
+	"time"
+)
+
+type (
+	// RabbitmqRpc rabbitmq 同步请求应答调用器
+	RabbitmqRpc struct {
+		queue   *RabbitmqUtils
+		channel *amqp.Channel
+		replyTo string
+		timeout time.Duration
+		locker  sync.Mutex
+		pending map[string]chan amqp.Delivery
+	}
+
+	// rpc 应答器 [消费完成后发布应答到 reply_to, 同一投递只应答一次]
+	rpcResponder struct {
+		once          sync.Once
+		locker        sync.Mutex
+		id            string
+		replyTo       string
+		correlationId string
+		output        *entity.QueueMessage
+		err           error
+		publish       func(msg rabbitmq.MessageParams) error
+	}
+
+	// 消费上下文中的应答器
+	rpcResponderKey struct{}
+)
+
+const (
+	defaultRpcTimeout = 30 * time.Second
+)
+
+var (
+	defaultRpc      *RabbitmqRpc
+	defaultRpcMutex sync.Mutex
+	ErrorRpcTimeout = errors.New("rabbitmq rpc call timeout")
+	ErrorRpcClosed  = errors.New("rabbitmq rpc reply channel closed")
+)
+
+// GetRabbitmqRpc 获取默认rpc调用器
+func GetRabbitmqRpc() *RabbitmqRpc {
+	defaultRpcMutex.Lock()
+	defer defaultRpcMutex.Unlock()
+	if defaultRpc == nil {
+		defaultRpc = NewRabbitmqRpc()
+	}
+	return defaultRpc
+}
+
+// NewRabbitmqRpc 创建rpc调用器
+func NewRabbitmqRpc(namespace ...string) *RabbitmqRpc {
+	var entry = RabbitmqOf(namespace...)
+	return &RabbitmqRpc{
+		queue:   &entry,
+		timeout: utils.GetEnvDuration("RPC_CALL_TIMEOUT", defaultRpcTimeout),
+		pending: make(map[string]chan amqp.Delivery),
+	}
+}
+
+// Call 发布消息到 queue 并等待消费者回复 [reply_to + correlation_id]
+func (rpc *RabbitmqRpc) Call(queue string, data interface{}, timeout ...time.Duration) (*amqp.Delivery, error) {
+	if queue == "" {
+		return nil, errors.New("rpc call queue empty")
+	}
+	timeout = append(timeout, rpc.timeout)
+	if timeout[0] <= 0 {
+		timeout[0] = rpc.timeout
+	}
+	if timeout[0] <= 0 {
+		timeout[0] = defaultRpcTimeout
+	}
+	replyTo, err := rpc.init()
+	if err != nil {
+		return nil, err
+	}
+	if err = rpc.queue.QueueDeclare(queue); err != nil {
+		return nil, err
+	}
+	return rpc.call(queue, data, replyTo, timeout[0], rpc.queue.publish)
+}
+
+// 发布请求并等待相同 correlation_id 的应答
+func (rpc *RabbitmqRpc) call(queue string, data interface{}, replyTo string, timeout time.Duration, publish func(msg rabbitmq.MessageParams) error) (*amqp.Delivery, error) {
+	var (
+		id    = createMessageID(queue)
+		reply = rpc.register(id)
+		msg   = rabbitmq.MessageParamsOf("", queue, data).
+			SetReplyTo(replyTo).
+			SetCorrelationId(id).
+			SetExpiration(fmt.Sprintf("%d", timeout.Milliseconds()))
+	)
+	defer rpc.remove(id)
+	if err := publish(*msg); err != nil {
+		return nil, err
+	}
+	var timer = time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case delivery, ok := <-reply:
+		if !ok {
+			return nil, ErrorRpcClosed
+		}
+		return &delivery, nil
+	case <-timer.C:
+		return nil, ErrorRpcTimeout
+	}
+}
+
+// Close 关闭应答信道
+func (rpc *RabbitmqRpc) Close() error {
+	rpc.locker.Lock()
+	defer rpc.locker.Unlock()
+	if rpc.channel == nil {
+		return nil
+	}
+	return rpc.channel.Close()
+}
+
+// 初始化 私有应答队列
+func (rpc *RabbitmqRpc) init() (string, error) {
+	rpc.locker.Lock()
+	defer rpc.locker.Unlock()
+	if rpc.channel != nil {
+		return rpc.replyTo, nil
+	}
+	var client = rpc.queue.getClient()
+	if client == nil {
+		return "", errors.New("queue client connection failed")
+	}
+	channel, err := client.GetBroker().GetConnection().Channel()
+	if err != nil {
+		return "", err
+	}
+	// 排他 自动删除 匿名队列
+	queue, err := channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		_ = channel.Close()
+		return "", err
+	}
+	deliveries, err := channel.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		_ = channel.Close()
+		return "", err
+	}
+	if err = GetPoolRepo().Add(func() { rpc.listen(deliveries) }); err != nil {
+		_ = channel.Close()
+		return "", err
+	}
+	rpc.channel = channel
+	rpc.replyTo = queue.Name
+	return rpc.replyTo, nil
+}
+
+// 监听应答
+func (rpc *RabbitmqRpc) listen(deliveries <-chan amqp.Delivery) {
+	for delivery := range deliveries {
+		rpc.resolve(delivery)
+	}
+	rpc.reset()
+}
+
+// 分发应答到等待调用
+func (rpc *RabbitmqRpc) resolve(delivery amqp.Delivery) {
+	rpc.locker.Lock()
+	defer rpc.locker.Unlock()
+	reply, ok := rpc.pending[delivery.CorrelationId]
+	if !ok {
+		log.WithField("correlation_id", delivery.CorrelationId).Warnln("rabbitmq rpc reply without caller")
+		return
+	}
+	delete(rpc.pending, delivery.CorrelationId)
+	reply <- delivery
+}
+
+// 信道关闭 释放等待调用
+func (rpc *RabbitmqRpc) reset() {
+	rpc.locker.Lock()
+	defer rpc.locker.Unlock()
+	for id, reply := range rpc.pending {
+		close(reply)
+		delete(rpc.pending, id)
+	}
+	rpc.channel = nil
+	rpc.replyTo = ""
+}
+
+func (rpc *RabbitmqRpc) register(id string) chan amqp.Delivery {
+	rpc.locker.Lock()
+	defer rpc.locker.Unlock()
+	var reply = make(chan amqp.Delivery, 1)
+	rpc.pending[id] = reply
+	return reply
+}
+
+func (rpc *RabbitmqRpc) remove(id string) {
+	rpc.locker.Lock()
+	defer rpc.locker.Unlock()
+	delete(rpc.pending, id)
+}
+
+// 需应答的投递 绑定应答器到消息上下文 [无 reply_to 时返回 nil]
+func withResponder(msg *entity.QueueMessage, delivery amqp.Delivery, publish func(msg rabbitmq.MessageParams) error) *rpcResponder {
+	if delivery.ReplyTo == "" {
+		return nil
+	}
+	var responder = &rpcResponder{
+		id:            msg.ID,
+		replyTo:       delivery.ReplyTo,
+		correlationId: delivery.CorrelationId,
+		publish:       publish,
+	}
+	msg.WithContext(context.WithValue(msg.Context(), rpcResponderKey{}, responder))
+	return responder
+}
+
+// 消费上下文中的应答器
+func responderOf(ctx context.Context) *rpcResponder {
+	if ctx == nil {
+		return nil
+	}
+	var responder, _ = ctx.Value(rpcResponderKey{}).(*rpcResponder)
+	return responder
+}
+
+// ReplyRpc 发布同步调用应答 [非 rpc 消息忽略, 重试时不应答 等待重投结果]
+func ReplyRpc(ctx context.Context, action entity.ConsumeAction) error {
+	return responderOf(ctx).Reply(action)
+}
+
+// 记录处理结果 [确认的输出消息作为应答内容]
+func (responder *rpcResponder) record(output *entity.QueueMessage, action entity.ConsumeAction, err error) {
+	if responder == nil {
+		return
+	}
+	responder.locker.Lock()
+	defer responder.locker.Unlock()
+	if output != nil && action == entity.ConsumeAck {
+		responder.output = output
+	}
+	if err != nil {
+		responder.err = err
+	}
+}
+
+// Reply 发布应答 [确认:输出消息或处理结果, 丢弃:处理结果及错误, 重试:不应答]
+func (responder *rpcResponder) Reply(action entity.ConsumeAction) error {
+	if responder == nil || action == entity.ConsumeRetry {
+		return nil
+	}
+	var err error
+	responder.once.Do(func() {
+		err = responder.publish(*responder.message(action))
+	})
+	return err
+}
+
+// 应答消息
+func (responder *rpcResponder) message(action entity.ConsumeAction) *rabbitmq.MessageParams {
+	responder.locker.Lock()
+	defer responder.locker.Unlock()
+	var publishing amqp.Publishing
+	if action == entity.ConsumeAck && responder.output != nil {
+		publishing = responder.output.Publishing()
+	} else {
+		var result = entity.RpcResult{ID: responder.id, Action: action.String()}
+		if action != entity.ConsumeAck && responder.err != nil {
+			result.Error = responder.err.Error()
+		}
+		publishing = amqp.Publishing{
+			ContentType: "application/json",
+			Timestamp:   time.Now(),
+			Body:        utils.JsonEncode(result).Bytes(),
+		}
+	}
+	// 应答不持久化 调用方断开后丢弃
+	publishing.DeliveryMode = amqp.Transient
+	return rabbitmq.MessageParamsOf("", responder.replyTo, publishing).SetCorrelationId(responder.correlationId)
+}
@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/streadway/amqp"
+	"github.com/weblfe/drivers/rabbitmq"
+	"github.com/weblfe/queue_mgr/entity"
+	"testing"
+	"time"
+)
+
+// 输出消息体追加 pong [未确认时返回错误]
+type rpcTestProcessor struct {
+	fanoutTestConsumer
+}
+
+func (processor *rpcTestProcessor) Process(msg *entity.QueueMessage) (*entity.QueueMessage, entity.ConsumeAction, error) {
+	var action, err = processor.Handle(msg)
+	if action != entity.ConsumeAck {
+		return nil, action, errors.New("rejected")
+	}
+	var output = entity.NewQueueMessage(msg.Queue, append(append([]byte{}, msg.Body...), "+pong"...))
+	output.ContentType = "text/plain"
+	return output, action, err
+}
+
+// 记录发布的应答
+type rpcTestPublisher struct {
+	published chan rabbitmq.MessageParams
+}
+
+func (publisher *rpcTestPublisher) publish(msg rabbitmq.MessageParams) error {
+	publisher.published <- msg
+	return nil
+}
+
+func TestRabbitmqRpc_Call(t *testing.T) {
+	var rpc = &RabbitmqRpc{pending: make(map[string]chan amqp.Delivery)}
+
+	// 无应答 超时
+	if _, err := rpc.call("orders", "ping", "reply.q", 20*time.Millisecond, func(rabbitmq.MessageParams) error {
+		return nil
+	}); err != ErrorRpcTimeout {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+
+	// correlation_id 不匹配的应答 不交给调用方
+	if _, err := rpc.call("orders", "ping", "reply.q", 50*time.Millisecond, func(msg rabbitmq.MessageParams) error {
+		rpc.resolve(amqp.Delivery{CorrelationId: msg.Msg.CorrelationId + "-other", Body: []byte("wrong")})
+		return nil
+	}); err != ErrorRpcTimeout {
+		t.Fatalf("expect timeout on correlation mismatch, got %v", err)
+	}
+
+	// 应答送达调用方
+	reply, err := rpc.call("orders", "ping", "reply.q", time.Second, func(msg rabbitmq.MessageParams) error {
+		if msg.Key != "orders" || msg.Msg.ReplyTo != "reply.q" || msg.Msg.CorrelationId == "" {
+			return errors.New("unexpected request " + msg.Key + " " + msg.Msg.ReplyTo)
+		}
+		go rpc.resolve(amqp.Delivery{CorrelationId: msg.Msg.CorrelationId, Body: []byte("pong")})
+		return nil
+	})
+	if err != nil || string(reply.Body) != "pong" {
+		t.Fatalf("expect pong reply, got %v %v", reply, err)
+	}
+
+	// 应答信道关闭 释放等待调用
+	if _, err = rpc.call("orders", "ping", "reply.q", time.Second, func(rabbitmq.MessageParams) error {
+		go rpc.reset()
+		return nil
+	}); err != ErrorRpcClosed {
+		t.Fatalf("expect closed, got %v", err)
+	}
+	if len(rpc.pending) != 0 {
+		t.Fatalf("expect no pending calls, got %d", len(rpc.pending))
+	}
+}
+
+func TestRpcResponder(t *testing.T) {
+	var (
+		publisher = &rpcTestPublisher{published: make(chan rabbitmq.MessageParams, 2)}
+		delivery  = amqp.Delivery{ReplyTo: "reply.q", CorrelationId: "c-1"}
+		msg       = entity.NewQueueMessage("orders", []byte("ping"))
+		responder = withResponder(msg, delivery, publisher.publish)
+	)
+	// 无 reply_to 不应答
+	if withResponder(entity.NewQueueMessage("orders", nil), amqp.Delivery{}, publisher.publish) != nil {
+		t.Fatal("expect no responder without reply_to")
+	}
+
+	// 绑定分发 输出消息作为应答
+	var (
+		replies = make(chan entity.ConsumeAction, 1)
+		fanout  = NewQueueFanout("orders", new(fanoutTestEntry))
+	)
+	defer fanout.Close()
+	if err := fanout.Bind(&QueueBinding{Name: "rpc", Consumer: &rpcTestProcessor{fanoutTestConsumer{action: entity.ConsumeAck}}}); err != nil {
+		t.Fatal(err)
+	}
+	fanout.Dispatch(msg, func(action entity.ConsumeAction) error {
+		replies <- action
+		return responder.Reply(action)
+	})
+	if action := waitFanoutReply(t, replies); action != entity.ConsumeAck {
+		t.Fatalf("expect ack, got %s", action)
+	}
+	var reply = <-publisher.published
+	if reply.Key != "reply.q" || reply.Msg.CorrelationId != "c-1" || string(reply.Msg.Body) != "ping+pong" {
+		t.Fatalf("unexpected reply %s %s %s", reply.Key, reply.Msg.CorrelationId, reply.Msg.Body)
+	}
+	// 同一投递只应答一次
+	if err := responder.Reply(entity.ConsumeAck); err != nil || len(publisher.published) != 0 {
+		t.Fatalf("expect single reply, got %d %v", len(publisher.published), err)
+	}
+
+	// 重试不应答, 丢弃时应答处理结果
+	msg = entity.NewQueueMessage("orders", []byte("ping"))
+	msg.ID = "m-2"
+	withResponder(msg, delivery, publisher.publish)
+	action, _ := HandleContext(msg.Context(), &contextTestConsumer{fanoutTestConsumer{action: entity.ConsumeDrop}}, msg, 10*time.Millisecond)
+	if err := ReplyRpc(msg.Context(), action); err != nil || len(publisher.published) != 0 {
+		t.Fatalf("expect no reply on %s, got %d %v", action, len(publisher.published), err)
+	}
+	msg = entity.NewQueueMessage("orders", []byte("ping"))
+	msg.ID = "m-3"
+	withResponder(msg, delivery, publisher.publish)
+	action, _ = HandleContext(msg.Context(), &rpcTestProcessor{fanoutTestConsumer{action: entity.ConsumeDrop}}, msg, 0)
+	if err := ReplyRpc(msg.Context(), action); err != nil {
+		t.Fatal(err)
+	}
+	reply = <-publisher.published
+	var result entity.RpcResult
+	if err := json.Unmarshal(reply.Msg.Body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "m-3" || result.Action != entity.ConsumeDrop.String() || result.Error != "rejected" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
@@ -22,7 +22,14 @@ func Http(app *fiber.App) {
 		monitorWare = monitor.New()
 		routerApi   = http.NewRouterApi(app)
 		managerApi  = http.NewManagerApi()
+		queueApi    = http.NewQueueApi()
+		shovelApi   = http.NewShovelApi()
+		failsApi    = http.NewFailsApi()
+		quarantine  = http.NewQuarantineApi()
+		fastCgiApi  = http.NewFastCgiApi()
 		promWare    = middlewares.CreatePromWare()
+		jwtWare     = middlewares.NewJwtWare()
+		adminWare   = middlewares.NewAdminWare()
 	)
 
 	// 跨域
@@ -69,4 +76,56 @@ func Http(app *fiber.App) {
 	router.Post("/queue/create", managerApi.CreateQueue)
 	// 创建队列消费器
 	router.Post("/consumer/create", managerApi.CreateConsumer)
+
+	// --- Queue-API ---
+	// 同步调用队列消费者
+	router.Post("/call/:queue", jwtWare, queueApi.Call)
+	// 查询队列待消费消息数
+	router.Get("/queue/:queue/len", jwtWare, queueApi.Len)
+	// 查看队列头部消息 [消息重回队列并标记重投]
+	router.Get("/queue/:queue/peek", jwtWare, queueApi.Peek)
+	// 清空队列 [管理员]
+	router.Post("/queue/:queue/purge", adminWare, queueApi.Purge)
+
+	// --- Shovel-API ---
+	// 创建队列消息转移任务 [管理员]
+	router.Post("/shovel", adminWare, shovelApi.Create)
+	// 罗列队列消息转移任务
+	router.Get("/shovels", jwtWare, shovelApi.List)
+	// 查询队列消息转移进度
+	router.Get("/shovel/:id", jwtWare, shovelApi.Get)
+	// 取消队列消息转移任务 [管理员]
+	router.Post("/shovel/:id/cancel", adminWare, shovelApi.Cancel)
+
+	// --- Fails-API ---
+	// 查询消费失败记录
+	router.Get("/fails", jwtWare, failsApi.List)
+	// 重放单条消费失败记录 [管理员]
+	router.Post("/fail/:id/replay", adminWare, failsApi.Replay)
+	// 批量重放消费失败记录 [管理员]
+	router.Post("/fails/replay", adminWare, failsApi.ReplayBulk)
+	// 罗列批量重放任务
+	router.Get("/fails/replays", jwtWare, failsApi.Replays)
+	// 查询批量重放进度
+	router.Get("/fails/replay/:id", jwtWare, failsApi.ReplayProgress)
+	// 取消批量重放 [管理员]
+	router.Post("/fails/replay/:id/cancel", adminWare, failsApi.CancelReplay)
+	// 标记消费失败记录 已解决|已忽略 [管理员]
+	router.Post("/fails/mark", adminWare, failsApi.Mark)
+
+	// --- Quarantine-API ---
+	// 罗列隔离的毒消息
+	router.Get("/quarantine", jwtWare, quarantine.List)
+	// 查看隔离的毒消息
+	router.Get("/quarantine/:id", jwtWare, quarantine.Get)
+	// 释放隔离的毒消息 [管理员]
+	router.Post("/quarantine/:id/release", adminWare, quarantine.Release)
+	// 删除隔离的毒消息 [管理员]
+	router.Post("/quarantine/:id/discard", adminWare, quarantine.Discard)
+
+	// --- FastCGI-API ---
+	// 罗列 FastCGI upstream [管理员]
+	router.Get("/fastcgi-upstreams", adminWare, fastCgiApi.Upstreams)
+	// FastCGI 网关 [代理到 php-fpm]
+	router.All("/fastcgi/*", jwtWare, fastCgiApi.Gateway)
 }
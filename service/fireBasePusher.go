@@ -23,6 +23,12 @@ func NewFireBasePusher(name ...string) *fireBasePusherImpl {
 	return impl
 }
 
+// SetClient 指定推送客户端 [默认按应用配置创建]
+func (pusher *fireBasePusherImpl) SetClient(client *messaging.Client) *fireBasePusherImpl {
+	pusher.client = client
+	return pusher
+}
+
 // SendAll 批量发送
 func (pusher *fireBasePusherImpl) SendAll(messages []interface{}) (*messaging.BatchResponse, error) {
 	if len(messages) <= 0 {
@@ -0,0 +1,59 @@
+package starter
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/weblfe/queue_mgr/config"
+	"github.com/weblfe/queue_mgr/domain"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+)
+
+// 队列消费
+type consumeStarter struct {
+	baseStarterConstructor
+}
+
+var (
+	consumerStarter = newConsumeStarter()
+)
+
+func GetConsumeStarter() *consumeStarter {
+	return consumerStarter
+}
+
+func newConsumeStarter() *consumeStarter {
+	var starter = new(consumeStarter)
+	starter.baseStarterConstructor = newStarterConstructor()
+	starter.name = "consumeStarter"
+	return starter
+}
+
+func (starter *consumeStarter) StartUp() {
+	starter.init(starter.boot)
+}
+
+// 按 queues 配置启动队列消费 [配置错误的队列跳过]
+func (starter *consumeStarter) boot() {
+	var queues = config.GetAppConfig().GetQueueKv()
+	for _, name := range queues.Keys() {
+		cfg, _ := queues.Get(name)
+		consume, err := entity.QueueConsumeOf(name, entity.KvMap(cfg))
+		if err != nil {
+			log.Errorln("consumeStarter queue", name, "config error:", err)
+			continue
+		}
+		entry, err := repo.GetQueueDriverRepo().Create(consume.Queue.Type)
+		if err != nil {
+			log.Errorln("consumeStarter queue", name, "driver error:", err)
+			continue
+		}
+		if err = repo.RegisterProcessor(func() {
+			if err := domain.ConsumeQueue(entry, consume); err != nil {
+				log.Errorln("consumeStarter queue", consume.Queue.Name, "consume error:", err)
+			}
+		}); err != nil {
+			log.Errorln("consumeStarter queue", name, "register error:", err)
+		}
+	}
+	log.Infoln("consumeStarter started")
+}
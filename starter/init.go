@@ -35,6 +35,8 @@ func StartUp() {
 	GetServiceRegisterStarter().StartUp()
 	// 后台任务组件 注册
 	GetScheduleStarter().StartUp()
+	// 队列消费 服务
+	GetConsumeStarter().StartUp()
 	// 应用 主程服务
 	GetAppStarter().StartUp()
 }
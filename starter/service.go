@@ -2,6 +2,7 @@ package starter
 
 import (
 	"github.com/weblfe/queue_mgr/config"
+	"github.com/weblfe/queue_mgr/domain"
 	"github.com/weblfe/queue_mgr/repo"
 	"github.com/weblfe/queue_mgr/service"
 )
@@ -32,5 +33,15 @@ func (starter *serviceRegisterStarter) boot() {
 
 	// 注册数据库 服务
 	repo.GetDatabaseRepository().InitConnection(config.GetAppConfig().GetDbKv())
+	// 消费失败 记录
+	repo.SetFailureRecorder(domain.GetFailureDomain())
 
+	// 注册 FastCGI upstream
+	var fastCgiKv = config.GetAppConfig().GetFastCgiKv()
+	for _, name := range fastCgiKv.Keys() {
+		cgi, _ := fastCgiKv.Get(name)
+		if err := domain.GetFastCGIDomain().RegisterUpstream(name, cgi.Bytes()); err != nil {
+			repo.GetLogger("fastcgi").Errorln("register fastcgi upstream", name, "error:", err)
+		}
+	}
 }
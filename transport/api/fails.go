@@ -0,0 +1,117 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// FailsApi 消费失败记录接口集合
+type FailsApi interface {
+
+	// List godoc
+	// @Summary 查询消费失败记录
+	// @Tags QueueMgrServ
+	// @Description list consume failures filtered by queue, consumer, status, time range or error text
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param queue query string false "queue/队列名"
+	// @Param consumer query string false "consumer/消费器"
+	// @Param status query string false "status/状态 failed,panic,resolved,ignored,replayed"
+	// @Param error query string false "error/异常信息包含内容"
+	// @Param since query int false "since/开始时间 unix"
+	// @Param until query int false "until/结束时间 unix"
+	// @Param page query int false "page/页码"
+	// @Param count query int false "count/每页条数"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /fails [get]
+	List(ctx *fiber.Ctx) error
+
+	// Replay godoc
+	// @Summary 重放单条消费失败记录 [管理员]
+	// @Tags QueueMgrServ
+	// @Description replay one failure to its original queue or a chosen one, outcome saved on the record
+	// @Accept  json
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path int true "id/记录ID"
+	// @Param params body entity.FailReplayParams false "replay params/重放参数 [target,driver]"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,404,502 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /fail/{id}/replay [post]
+	Replay(ctx *fiber.Ctx) error
+
+	// ReplayBulk godoc
+	// @Summary 批量重放消费失败记录 [管理员]
+	// @Tags QueueMgrServ
+	// @Description replay failures selected by ids or filter in background with rate limit
+	// @Accept  json
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param params body entity.FailReplayParams true "replay params/重放参数"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /fails/replay [post]
+	ReplayBulk(ctx *fiber.Ctx) error
+
+	// Replays godoc
+	// @Summary 罗列批量重放任务
+	// @Tags QueueMgrServ
+	// @Description list bulk replay jobs with progress
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /fails/replays [get]
+	Replays(ctx *fiber.Ctx) error
+
+	// ReplayProgress godoc
+	// @Summary 查询批量重放进度
+	// @Tags QueueMgrServ
+	// @Description get bulk replay job progress
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/任务ID"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 404 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /fails/replay/{id} [get]
+	ReplayProgress(ctx *fiber.Ctx) error
+
+	// CancelReplay godoc
+	// @Summary 取消批量重放 [管理员]
+	// @Tags QueueMgrServ
+	// @Description cancel running bulk replay job
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/任务ID"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 404,409 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /fails/replay/{id}/cancel [post]
+	CancelReplay(ctx *fiber.Ctx) error
+
+	// Mark godoc
+	// @Summary 标记消费失败记录 [管理员]
+	// @Tags QueueMgrServ
+	// @Description mark failures resolved or ignored
+	// @Accept  json
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param params body entity.FailMarkParams true "mark params/标记参数"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /fails/mark [post]
+	Mark(ctx *fiber.Ctx) error
+
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// FastCgiApi FastCGI 网关接口集合
+type FastCgiApi interface {
+
+	// Gateway godoc
+	// @Summary FastCGI 网关
+	// @Tags QueueMgrServ
+	// @Description proxy request to named php-fpm upstream, path after /fastcgi is passed as script path
+	// @Param Authorization header string true "access jwt token"
+	// @Param Queue-Consumer-Type header string false "upstream name (default FastCGI)"
+	// @Success 200 {string} string "php response"
+	// @Failure 404,502 {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /fastcgi/{path} [get]
+	Gateway(ctx *fiber.Ctx) error
+
+	// Upstreams godoc
+	// @Summary 罗列 FastCGI upstream [管理员]
+	// @Tags QueueMgrServ
+	// @Description list configured fastcgi upstreams
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /fastcgi-upstreams [get]
+	Upstreams(ctx *fiber.Ctx) error
+}
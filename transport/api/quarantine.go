@@ -0,0 +1,67 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// QuarantineApi 毒消息隔离接口集合
+type QuarantineApi interface {
+
+	// List godoc
+	// @Summary 罗列隔离的毒消息
+	// @Tags QueueMgrServ
+	// @Description list poison messages quarantined after repeated panics or crashes
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param queue query string false "queue/队列名"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /quarantine [get]
+	List(ctx *fiber.Ctx) error
+
+	// Get godoc
+	// @Summary 查看隔离的毒消息
+	// @Tags QueueMgrServ
+	// @Description get quarantined message with stack trace
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/隔离ID"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 404 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /quarantine/{id} [get]
+	Get(ctx *fiber.Ctx) error
+
+	// Release godoc
+	// @Summary 释放隔离的毒消息 [管理员]
+	// @Tags QueueMgrServ
+	// @Description push quarantined message back to its queue or a chosen one
+	// @Accept  json
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/隔离ID"
+	// @Param params body entity.QuarantineReleaseParams false "release params/释放参数"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,404,502 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /quarantine/{id}/release [post]
+	Release(ctx *fiber.Ctx) error
+
+	// Discard godoc
+	// @Summary 删除隔离的毒消息 [管理员]
+	// @Tags QueueMgrServ
+	// @Description discard quarantined message
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/隔离ID"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 404 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /quarantine/{id}/discard [post]
+	Discard(ctx *fiber.Ctx) error
+
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// QueueApi 队列消息操作接口集合
+type QueueApi interface {
+
+	// Call godoc
+	// @Summary 同步调用队列消费者
+	// @Tags QueueMgrServ
+	// @Description publish request body with reply_to/correlation_id and wait for consumer reply
+	// @Description reply body is the consumer output message, or entity.RpcResult when consumer has no output or drops the message
+	// @Accept  plain
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param queue path string true "queue/调用队列名"
+	// @Param timeout query string false "timeout/等待应答时长(eg: 5s)" default(30s)
+	// @Param X-Call-Timeout header string false "timeout/等待应答时长(eg: 5s)"
+	// @Success 200 {object} entity.RpcResult "consumer reply body"
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Failure 504 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Router /call/{queue} [post]
+	Call(ctx *fiber.Ctx) error
+
+	// Len godoc
+	// @Summary 查询队列待消费消息数
+	// @Tags QueueMgrServ
+	// @Description count of ready messages in queue
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param queue path string true "queue/队列名"
+	// @Param driver query string false "driver/队列驱动" default(AMQP)
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /queue/{queue}/len [get]
+	Len(ctx *fiber.Ctx) error
+
+	// Peek godoc
+	// @Summary 查看队列头部消息
+	// @Tags QueueMgrServ
+	// @Description peek messages at the head of queue without consuming them
+	// @Description amqp: peeked messages are requeued and marked redelivered, quorum queues count it as a delivery attempt
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param queue path string true "queue/队列名"
+	// @Param count query int false "count/查看条数(max 100)" default(10)
+	// @Param driver query string false "driver/队列驱动" default(AMQP)
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /queue/{queue}/peek [get]
+	Peek(ctx *fiber.Ctx) error
+
+	// Purge godoc
+	// @Summary 清空队列 [管理员]
+	// @Tags QueueMgrServ
+	// @Description purge all ready messages in queue, admin role required
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param queue path string true "queue/队列名"
+	// @Param driver query string false "driver/队列驱动" default(AMQP)
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /queue/{queue}/purge [post]
+	Purge(ctx *fiber.Ctx) error
+
+}
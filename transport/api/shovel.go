@@ -0,0 +1,65 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// ShovelApi 队列消息转移接口集合
+type ShovelApi interface {
+
+	// Create godoc
+	// @Summary 创建队列消息转移任务 [管理员]
+	// @Tags QueueMgrServ
+	// @Description move messages from source queue to target queue (cross driver) in background
+	// @Accept  json
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param params body entity.ShovelParams true "shovel params/转移参数"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 400,502 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /shovel [post]
+	Create(ctx *fiber.Ctx) error
+
+	// List godoc
+	// @Summary 罗列队列消息转移任务
+	// @Tags QueueMgrServ
+	// @Description list shovel jobs with progress
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /shovels [get]
+	List(ctx *fiber.Ctx) error
+
+	// Get godoc
+	// @Summary 查询队列消息转移进度
+	// @Tags QueueMgrServ
+	// @Description get shovel job progress
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/任务ID"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 404 {object} entity.JsonResponse
+	// @Failure default {object} entity.JsonResponse
+	// @Failure 401 {string} string "please try login"
+	// @Router /shovel/{id} [get]
+	Get(ctx *fiber.Ctx) error
+
+	// Cancel godoc
+	// @Summary 取消队列消息转移任务 [管理员]
+	// @Tags QueueMgrServ
+	// @Description cancel running shovel job, untransferred messages stay in source queue
+	// @Produce  json
+	// @Param Authorization header string true "access jwt token"
+	// @Param id path string true "id/任务ID"
+	// @Success 200 {object} entity.JsonResponse
+	// @Failure 404,409 {object} entity.JsonResponse
+	// @Failure 401,403 {string} string "permission denied"
+	// @Failure default {object} entity.JsonResponse
+	// @Router /shovel/{id}/cancel [post]
+	Cancel(ctx *fiber.Ctx) error
+
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/domain"
+	"github.com/weblfe/queue_mgr/entity"
+	"strconv"
+)
+
+type FailsApi struct {
+	Controller
+}
+
+func NewFailsApi() *FailsApi {
+	var api = new(FailsApi)
+	return api
+}
+
+// List 查询消费失败记录
+func (api *FailsApi) List(ctx *fiber.Ctx) error {
+	var (
+		params    = new(entity.FailQueryParams)
+		transport = api.getTransport(ctx)
+	)
+	if err := params.Parse(ctx); err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	items, total, err := domain.GetReplayDomain().List(params)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entity.KvMap{
+		"items": items,
+		"total": total,
+		"page":  params.Page,
+		"count": params.Count,
+	}))
+}
+
+// Replay 重放单条消费失败记录
+func (api *FailsApi) Replay(ctx *fiber.Ctx) error {
+	var (
+		params    = new(entity.FailReplayParams)
+		transport = api.getTransport(ctx)
+	)
+	id, err := strconv.ParseUint(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	if len(ctx.Body()) > 0 {
+		if err = params.Decode(ctx.Body()); err != nil {
+			return transport.SetCode(fiber.StatusBadRequest).
+				sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+		}
+	}
+	record, err := domain.GetReplayDomain().ReplayOne(uint(id), params)
+	if entity.IsEmptyError(err) {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, err.Error()))
+	}
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entity.KvMap{"item": record}))
+}
+
+// ReplayBulk 批量重放消费失败记录
+func (api *FailsApi) ReplayBulk(ctx *fiber.Ctx) error {
+	var (
+		params    = new(entity.FailReplayParams)
+		transport = api.getTransport(ctx)
+	)
+	if err := params.Parse(ctx); err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	progress, err := domain.GetReplayDomain().Replay(params)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(progress.KvMap()))
+}
+
+// Replays 罗列批量重放任务
+func (api *FailsApi) Replays(ctx *fiber.Ctx) error {
+	var (
+		items     []entity.KvMap
+		transport = api.getTransport(ctx)
+	)
+	for _, progress := range domain.GetReplayDomain().Jobs() {
+		items = append(items, progress.KvMap())
+	}
+	return transport.sendJson(entity.CreateInfoResponse(items...))
+}
+
+// ReplayProgress 查询批量重放进度
+func (api *FailsApi) ReplayProgress(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	progress, ok := domain.GetReplayDomain().Get(ctx.Params("id"))
+	if !ok {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, entity.ErrorEmpty.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(progress.KvMap()))
+}
+
+// CancelReplay 取消批量重放
+func (api *FailsApi) CancelReplay(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	progress, err := domain.GetReplayDomain().Cancel(ctx.Params("id"))
+	if entity.IsEmptyError(err) {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, err.Error()))
+	}
+	if err != nil {
+		return transport.SetCode(fiber.StatusConflict).
+			sendJson(entity.CreateFailResponse(fiber.StatusConflict, entity.CodeFail, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(progress.KvMap()))
+}
+
+// Mark 标记消费失败记录
+func (api *FailsApi) Mark(ctx *fiber.Ctx) error {
+	var (
+		params    = new(entity.FailMarkParams)
+		transport = api.getTransport(ctx)
+	)
+	if err := params.Parse(ctx); err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	affected, err := domain.GetReplayDomain().Mark(params)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entity.KvMap{"affected": affected}))
+}
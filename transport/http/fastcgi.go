@@ -0,0 +1,41 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/domain"
+	"github.com/weblfe/queue_mgr/entity"
+)
+
+type FastCgiApi struct {
+	Controller
+}
+
+func NewFastCgiApi() *FastCgiApi {
+	var api = new(FastCgiApi)
+	return api
+}
+
+// Gateway 代理请求到 FastCGI upstream [按 Queue-Consumer-Type 请求头选择, 去除网关路由前缀]
+func (api *FastCgiApi) Gateway(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	handler, err := domain.GetFastCGIDomain().Resolve(ctx)
+	if err != nil {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, err.Error()))
+	}
+	ctx.Request().URI().SetPath("/" + ctx.Params("*"))
+	if err = handler.Call(ctx); err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return nil
+}
+
+// Upstreams 罗列已注册 FastCGI upstream
+func (api *FastCgiApi) Upstreams(ctx *fiber.Ctx) error {
+	var items []entity.KvMap
+	for _, name := range domain.GetFastCGIDomain().Upstreams() {
+		items = append(items, entity.KvMap{"name": name})
+	}
+	return api.getTransport(ctx).sendJson(entity.CreateInfoResponse(items...))
+}
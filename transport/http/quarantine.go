@@ -0,0 +1,71 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/repo"
+)
+
+type QuarantineApi struct {
+	Controller
+}
+
+func NewQuarantineApi() *QuarantineApi {
+	var api = new(QuarantineApi)
+	return api
+}
+
+// List 罗列隔离的毒消息
+func (api *QuarantineApi) List(ctx *fiber.Ctx) error {
+	var (
+		items     []entity.KvMap
+		transport = api.getTransport(ctx)
+	)
+	for _, entry := range repo.GetQuarantineRepo().List(ctx.Query("queue")) {
+		items = append(items, entry.KvMap())
+	}
+	return transport.sendJson(entity.CreateInfoResponse(items...))
+}
+
+// Get 查看隔离的毒消息
+func (api *QuarantineApi) Get(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	entry, ok := repo.GetQuarantineRepo().Get(ctx.Params("id"))
+	if !ok {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, entity.ErrorEmpty.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entry.KvMap()))
+}
+
+// Release 释放隔离的毒消息
+func (api *QuarantineApi) Release(ctx *fiber.Ctx) error {
+	var (
+		params    = new(entity.QuarantineReleaseParams)
+		transport = api.getTransport(ctx)
+	)
+	if err := params.Parse(ctx); err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	entry, err := repo.GetQuarantineRepo().Release(ctx.Params("id"), params)
+	if entity.IsEmptyError(err) {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, err.Error()))
+	}
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entry.KvMap()))
+}
+
+// Discard 删除隔离的毒消息
+func (api *QuarantineApi) Discard(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	if err := repo.GetQuarantineRepo().Discard(ctx.Params("id")); err != nil {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse())
+}
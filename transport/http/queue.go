@@ -0,0 +1,149 @@
+package http
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/entity"
+	"github.com/weblfe/queue_mgr/facede"
+	"github.com/weblfe/queue_mgr/repo"
+	"github.com/weblfe/queue_mgr/utils"
+	"strconv"
+	"time"
+)
+
+type QueueApi struct {
+	Controller
+}
+
+const (
+	HeaderCallTimeout   = "X-Call-Timeout"
+	HeaderCorrelationID = "X-Correlation-ID"
+	defaultCallMaxTime  = 2 * time.Minute
+)
+
+func NewQueueApi() *QueueApi {
+	var api = new(QueueApi)
+	return api
+}
+
+// Call 同步调用队列消费者 并返回消费者应答
+func (api *QueueApi) Call(ctx *fiber.Ctx) error {
+	var (
+		queue     = ctx.Params("queue")
+		transport = api.getTransport(ctx)
+	)
+	if queue == "" {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeParamNil, "queue required"))
+	}
+	var reply, err = repo.GetRabbitmqRpc().Call(queue, ctx.Body(), api.getCallTimeout(ctx))
+	if err == repo.ErrorRpcTimeout {
+		return transport.SetCode(fiber.StatusGatewayTimeout).
+			sendJson(entity.CreateFailResponse(fiber.StatusGatewayTimeout, entity.CodeFail, err.Error()))
+	}
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	var contentType = reply.ContentType
+	if contentType == "" {
+		contentType = fiber.MIMEApplicationJSON
+	}
+	ctx.Set(HeaderCorrelationID, reply.CorrelationId)
+	ctx.Response().Header.SetContentType(contentType)
+	return ctx.Send(reply.Body)
+}
+
+// Len 查询队列待消费消息数
+func (api *QueueApi) Len(ctx *fiber.Ctx) error {
+	var (
+		queue     = ctx.Params("queue")
+		transport = api.getTransport(ctx)
+	)
+	entry, err := api.getEntry(ctx)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	count, err := entry.Len(queue)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entity.KvMap{"queue": queue, "count": count}))
+}
+
+// Peek 查看队列头部消息 [不消费 消息重回队列, amqp 消息标记为重投]
+func (api *QueueApi) Peek(ctx *fiber.Ctx) error {
+	var (
+		queue     = ctx.Params("queue")
+		transport = api.getTransport(ctx)
+	)
+	entry, err := api.getEntry(ctx)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	messages, err := entry.Peek(api.getCount(ctx), queue)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	var items = make([]entity.KvMap, 0, len(messages))
+	for _, msg := range messages {
+		items = append(items, msg.KvMap())
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entity.KvMap{"queue": queue, "items": items, "count": len(items)}))
+}
+
+// Purge 清空队列 [管理员]
+func (api *QueueApi) Purge(ctx *fiber.Ctx) error {
+	var (
+		queue     = ctx.Params("queue")
+		transport = api.getTransport(ctx)
+	)
+	entry, err := api.getEntry(ctx)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	count, err := entry.Purge(queue)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(entity.KvMap{"queue": queue, "purged": count}))
+}
+
+// 队列驱动 [query driver 默认 amqp]
+func (api *QueueApi) getEntry(ctx *fiber.Ctx) (facede.QueueEntry, error) {
+	if ctx.Params("queue") == "" {
+		return nil, errors.New("queue required")
+	}
+	return repo.GetQueueDriverRepo().Get(ctx.Query("driver", repo.DriverAmqp))
+}
+
+// 查看条数 [query count]
+func (api *QueueApi) getCount(ctx *fiber.Ctx) int {
+	var n, err = strconv.Atoi(ctx.Query("count"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// 调用超时时长 [query timeout 或 header X-Call-Timeout]
+func (api *QueueApi) getCallTimeout(ctx *fiber.Ctx) time.Duration {
+	var (
+		value   = ctx.Query("timeout", ctx.Get(HeaderCallTimeout))
+		maxTime = utils.GetEnvDuration("RPC_CALL_MAX_TIMEOUT", defaultCallMaxTime)
+		d, err  = time.ParseDuration(value)
+	)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	if d > maxTime {
+		return maxTime
+	}
+	return d
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/weblfe/queue_mgr/domain"
+	"github.com/weblfe/queue_mgr/entity"
+)
+
+type ShovelApi struct {
+	Controller
+}
+
+func NewShovelApi() *ShovelApi {
+	var api = new(ShovelApi)
+	return api
+}
+
+// Create 创建队列消息转移任务
+func (api *ShovelApi) Create(ctx *fiber.Ctx) error {
+	var (
+		params    = new(entity.ShovelParams)
+		transport = api.getTransport(ctx)
+	)
+	if err := params.Parse(ctx); err != nil {
+		return transport.SetCode(fiber.StatusBadRequest).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadRequest, entity.CodeVerify, err.Error()))
+	}
+	progress, err := domain.GetShovelDomain().Start(params)
+	if err != nil {
+		return transport.SetCode(fiber.StatusBadGateway).
+			sendJson(entity.CreateFailResponse(fiber.StatusBadGateway, entity.CodeSystemError, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(progress.KvMap()))
+}
+
+// List 罗列队列消息转移任务
+func (api *ShovelApi) List(ctx *fiber.Ctx) error {
+	var (
+		items     []entity.KvMap
+		transport = api.getTransport(ctx)
+	)
+	for _, progress := range domain.GetShovelDomain().List() {
+		items = append(items, progress.KvMap())
+	}
+	return transport.sendJson(entity.CreateInfoResponse(items...))
+}
+
+// Get 查询队列消息转移进度
+func (api *ShovelApi) Get(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	progress, ok := domain.GetShovelDomain().Get(ctx.Params("id"))
+	if !ok {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, entity.ErrorEmpty.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(progress.KvMap()))
+}
+
+// Cancel 取消队列消息转移任务
+func (api *ShovelApi) Cancel(ctx *fiber.Ctx) error {
+	var transport = api.getTransport(ctx)
+	progress, err := domain.GetShovelDomain().Cancel(ctx.Params("id"))
+	if entity.IsEmptyError(err) {
+		return transport.SetCode(fiber.StatusNotFound).
+			sendJson(entity.CreateFailResponse(fiber.StatusNotFound, entity.CodeFail, err.Error()))
+	}
+	if err != nil {
+		return transport.SetCode(fiber.StatusConflict).
+			sendJson(entity.CreateFailResponse(fiber.StatusConflict, entity.CodeFail, err.Error()))
+	}
+	return transport.sendJson(entity.CreateInfoResponse(progress.KvMap()))
+}
@@ -9,7 +9,9 @@ import (
 
 type (
 	httpResponseHandler struct {
-		ctx *fiber.Ctx
+		ctx         *fiber.Ctx
+		header      http.Header
+		wroteHeader bool
 	}
 )
 
@@ -37,33 +39,55 @@ func createHttpRequest(ctx *fiber.Ctx) (*http.Request, error) {
 		body     = bytes.NewReader(request.Body())
 		req, err = http.NewRequest(ctx.Method(), fullURL, body)
 	)
-	if err == nil {
-		return req, nil
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	// 透传请求头及客户端地址
+	request.Header.VisitAll(func(key, value []byte) {
+		req.Header.Add(string(key), string(value))
+	})
+	req.Host = string(request.Host())
+	req.RemoteAddr = ctx.Context().RemoteAddr().String()
+	req.RequestURI = string(request.RequestURI())
+	return req, nil
 }
 
 func (h *httpResponseHandler) Header() http.Header {
-	var values = make(http.Header)
+	if h.header != nil {
+		return h.header
+	}
+	h.header = make(http.Header)
 	if res := h.response(); res != nil {
 		res.Header.VisitAll(func(key, value []byte) {
-			name := string(key)
-			if _, ok := values[name]; !ok {
-				values[name] = []string{string(value)}
-			} else {
-				values[name] = append(values[name], string(value))
-			}
+			h.header.Add(string(key), string(value))
 		})
 	}
-	return values
+	return h.header
 }
 
 func (h *httpResponseHandler) Write(bytes []byte) (int, error) {
+	if !h.wroteHeader {
+		h.WriteHeader(http.StatusOK)
+	}
 	return h.ctx.Write(bytes)
 }
 
+// WriteHeader 写入状态码 [同时写入 Header() 设置的响应头]
 func (h *httpResponseHandler) WriteHeader(statusCode int) {
-	h.response().SetStatusCode(statusCode)
+	if h.wroteHeader {
+		return
+	}
+	h.wroteHeader = true
+	var res = h.response()
+	if h.header != nil {
+		for k, values := range h.header {
+			res.Header.Del(k)
+			for _, v := range values {
+				res.Header.Add(k, v)
+			}
+		}
+	}
+	res.SetStatusCode(statusCode)
 }
 
 func (h *httpResponseHandler) response() *fasthttp.Response {